@@ -0,0 +1,71 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ACL decides which clients get answers. Rules are evaluated in order and the first one containing
+// the client's address applies; clients matching no rule get the default policy.
+type ACL struct {
+	rules []aclRule
+
+	// deny makes the default policy deny rather than allow.
+	deny bool
+}
+
+// aclRule allows or denies the clients in a set of subnets.
+type aclRule struct {
+	allow   bool
+	subnets []*net.IPNet
+}
+
+// Allowed reports whether the client with address ip may be answered.
+func (a ACL) Allowed(ip net.IP) bool {
+	for _, rule := range a.rules {
+		for _, subnet := range rule.subnets {
+			if subnet.Contains(ip) {
+				return rule.allow
+			}
+		}
+	}
+	return !a.deny
+}
+
+// add adds a rule from the arguments of an acl directive: allow or deny followed by CIDRs, or
+// default followed by the default policy. Plain addresses are taken to be single hosts.
+func (a *ACL) add(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("acl needs allow, deny or default and a list of subnets")
+	}
+	switch args[0] {
+	case "allow", "deny":
+	case "default":
+		if len(args) != 2 || (args[1] != "allow" && args[1] != "deny") {
+			return fmt.Errorf("acl default needs allow or deny")
+		}
+		a.deny = args[1] == "deny"
+		return nil
+	default:
+		return fmt.Errorf("unknown acl action '%s', expected allow, deny or default", args[0])
+	}
+
+	rule := aclRule{allow: args[0] == "allow"}
+	for _, s := range args[1:] {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid acl subnet '%s'", s)
+		}
+		rule.subnets = append(rule.subnets, subnet)
+	}
+	a.rules = append(a.rules, rule)
+	return nil
+}
@@ -0,0 +1,69 @@
+package nightlightdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestACLAllowed(t *testing.T) {
+	var a ACL
+	for _, rule := range [][]string{
+		{"deny", "192.0.2.1"},
+		{"allow", "192.0.2.0/24", "2001:db8::/32"},
+		{"default", "deny"},
+	} {
+		if err := a.add(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"192.0.2.1", false},
+		{"192.0.2.2", true},
+		{"2001:db8::1", true},
+		{"198.51.100.1", false},
+		{"2001:db9::1", false},
+	}
+	for i, tc := range tests {
+		if got := a.Allowed(net.ParseIP(tc.ip)); got != tc.allowed {
+			t.Errorf("Test %d: expected %s allowed to be %t, got %t", i, tc.ip, tc.allowed, got)
+		}
+	}
+}
+
+func TestSetupACL(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nacl allow 10.0.0.0/8\n}", false},
+		{"nightlightdns {\nacl deny 10.0.0.1 2001:db8::1\n}", false},
+		{"nightlightdns {\nacl default deny\n}", false},
+		{"nightlightdns {\nacl allow\n}", true},
+		{"nightlightdns {\nacl permit 10.0.0.0/8\n}", true},
+		{"nightlightdns {\nacl allow 10.0.0.0/33\n}", true},
+		{"nightlightdns {\nacl default maybe\n}", true},
+	})
+}
+
+func TestServeDNSACL(t *testing.T) {
+	// The test client's address is 10.240.0.1.
+	tests := []struct {
+		options []string
+		rcode   int
+	}{
+		{nil, dns.RcodeSuccess},
+		{[]string{"acl allow 10.240.0.0/16", "acl default deny"}, dns.RcodeSuccess},
+		{[]string{"acl default deny"}, dns.RcodeRefused},
+		{[]string{"acl deny 10.240.0.1"}, dns.RcodeRefused},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, testRecords, tc.options...)
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		if resp := exchange(n, m); resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
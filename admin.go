@@ -0,0 +1,192 @@
+package nightlightdns
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// admin serves an HTTP API to list, add, replace and delete the records of the records file at
+// runtime:
+//
+//	GET    /records         lists all records
+//	POST   /records         adds the record in the body
+//	PUT    /records/{name}  replaces the records of name with the list in the body
+//	DELETE /records/{name}  deletes the records of name
+//	GET    /queries         lists the last queries, with query-log-size
+//
+// Every request needs the bearer token in its Authorization header.
+type admin struct {
+	addr    string
+	token   string
+	persist bool
+
+	file *Recordsfile
+
+	// recent are the last queries, nil unless they're kept.
+	recent *recentQueries
+
+	// mu serializes changes, so concurrent requests don't overwrite each other's edits.
+	mu sync.Mutex
+
+	ln  net.Listener
+	srv *http.Server
+}
+
+// newAdmin returns an admin API listening on addr. When persist is set changes are written back to
+// the records file.
+func newAdmin(addr, token string, persist bool) *admin {
+	return &admin{addr: addr, token: token, persist: persist}
+}
+
+// Startup starts listening.
+func (a *admin) Startup() error {
+	ln, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return err
+	}
+	a.ln = ln
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/records", a.authorized(a.handleRecords))
+	mux.HandleFunc("/records/", a.authorized(a.handleName))
+	mux.HandleFunc("/queries", a.authorized(a.handleQueries))
+	a.srv = &http.Server{Handler: mux}
+
+	go func() { a.srv.Serve(ln) }()
+	return nil
+}
+
+// Shutdown stops the API.
+func (a *admin) Shutdown() error {
+	if a.srv == nil {
+		return nil
+	}
+	return a.srv.Close()
+}
+
+// authorized wraps h so it's only called for requests carrying the token.
+func (a *admin) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+a.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleRecords lists and adds records.
+func (a *admin) handleRecords(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.file.Records())
+	case http.MethodPost:
+		var record DNSRecord
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.edit(w, http.StatusCreated, func(data DNSRecords) ([]DNSRecord, error) {
+			if err := record.validate(data.Origin); err != nil {
+				return nil, err
+			}
+			return append(append([]DNSRecord{}, data.Records...), record), nil
+		})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleQueries lists the last queries, oldest first.
+func (a *admin) handleQueries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.recent == nil {
+		http.Error(w, "queries aren't kept, see query-log-size", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.recent.list())
+}
+
+// handleName replaces and deletes the records of a name.
+func (a *admin) handleName(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/records/")
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var records []DNSRecord
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.edit(w, http.StatusOK, func(data DNSRecords) ([]DNSRecord, error) {
+			for i := range records {
+				records[i].Name = name
+				if err := records[i].validate(data.Origin); err != nil {
+					return nil, err
+				}
+			}
+			return append(without(data, name), records...), nil
+		})
+	case http.MethodDelete:
+		a.edit(w, http.StatusNoContent, func(data DNSRecords) ([]DNSRecord, error) {
+			return without(data, name), nil
+		})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// edit applies change to the loaded records and swaps in the result, writing it to the records
+// file if changes are persisted. A failing change is answered with 400, otherwise status is sent.
+func (a *admin) edit(w http.ResponseWriter, status int, change func(DNSRecords) ([]DNSRecord, error)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data := a.file.Records()
+	records, err := change(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data.Records = records
+	if err := checkNS(data, a.file.zones, a.file.Path); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if a.persist {
+		if err := a.file.writeRecords(data); err != nil {
+			log.Errorf("Failed to write %s: %v", a.file.Path, err)
+			http.Error(w, "unable to write records file", http.StatusInternalServerError)
+			return
+		}
+	}
+	a.file.setRecords(data)
+	w.WriteHeader(status)
+}
+
+// without returns a copy of the records of data, leaving out those of name.
+func without(data DNSRecords, name string) []DNSRecord {
+	name = qualify(name, data.Origin)
+	records := make([]DNSRecord, 0, len(data.Records))
+	for _, record := range data.Records {
+		if qualify(record.Name, data.Origin) != name {
+			records = append(records, record)
+		}
+	}
+	return records
+}
@@ -0,0 +1,99 @@
+package nightlightdns
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSetupAdmin(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nadmin :8081 secret\n}", false},
+		{"nightlightdns {\nadmin :8081 secret persist\n}", false},
+		{"nightlightdns {\nadmin :8081\n}", true},
+		{"nightlightdns {\nadmin :8081 secret persist now\n}", true},
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", testRecords)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com {\nadmin 127.0.0.1:0 secret\n}")
+	if err := n.admin.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.admin.Shutdown()
+	url := "http://" + n.admin.ln.Addr().String()
+
+	tests := []struct {
+		method, path, token, body string
+		status                    int
+		// contains is a part of the body of the response, if set.
+		contains string
+		// resolves is set if new.example.com is expected to resolve after the request.
+		resolves bool
+	}{
+		{"GET", "/records", "wrong", "", http.StatusUnauthorized, "", false},
+		{"POST", "/records", "", `{"name": "new", "ipaddress": "192.0.2.9"}`, http.StatusUnauthorized, "", false},
+		{"GET", "/records", "secret", "", http.StatusOK, `"name":"www"`, false},
+		{"POST", "/records", "secret", `{"name": "new", "ipaddress": "192.0.2.9"}`, http.StatusCreated, "", true},
+		{"GET", "/records", "secret", "", http.StatusOK, `"name":"new","ipaddress":"192.0.2.9"`, true},
+		{"POST", "/records", "secret", `{"name": "bad", "ipaddress": "192.0.2"}`, http.StatusBadRequest, "", true},
+		{"POST", "/records", "secret", `{"name": `, http.StatusBadRequest, "", true},
+		{"DELETE", "/records/new", "secret", "", http.StatusNoContent, "", false},
+		{"GET", "/records", "secret", "", http.StatusOK, `"name":"www"`, false},
+		{"PUT", "/records/new", "secret", `[{"ipaddress": "192.0.2.9"}]`, http.StatusOK, "", true},
+		{"DELETE", "/records/new.example.com.", "secret", "", http.StatusNoContent, "", false},
+		{"PATCH", "/records", "secret", "", http.StatusMethodNotAllowed, "", false},
+	}
+	for i, tc := range tests {
+		req, err := http.NewRequest(tc.method, url+tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("Test %d: expected status %d for %s %s, got %d: %s", i, tc.status, tc.method, tc.path, resp.StatusCode, body)
+		}
+		if !strings.Contains(string(body), tc.contains) {
+			t.Errorf("Test %d: expected %q in the response, got %s", i, tc.contains, body)
+		}
+		if got := resolves(n, "new.example.com.", "192.0.2.9"); got != tc.resolves {
+			t.Errorf("Test %d: expected new.example.com to resolve %t, got %t", i, tc.resolves, got)
+		}
+	}
+}
+
+func TestAdminPersist(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", testRecords)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com {\nadmin 127.0.0.1:0 secret persist\n}")
+	if err := n.admin.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.admin.Shutdown()
+
+	req, _ := http.NewRequest("POST", "http://"+n.admin.ln.Addr().String()+"/records", strings.NewReader(`{"name": "new", "ipaddress": "192.0.2.9"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	records, err := parseRecords(path, formatJSON, false, envOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records.Records) != 4 || records.Records[3].Name != "new" {
+		t.Errorf("Expected the new record to be written to %s, got %v", path, records.Records)
+	}
+}
@@ -0,0 +1,64 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+// allowedTypes are the only record types served from the records, as a guardrail against serving
+// anything else. Queries for other types get NODATA, even if there are records of that type.
+type allowedTypes map[uint16]bool
+
+// newAllowedTypes adds the allowedTypes of the arguments of an allowed-types directive to zoneTypes,
+// keyed by zone. Arguments naming a record type are types, the others are the zones they apply to,
+// which must be among zones. Without any the types apply to all zones.
+func newAllowedTypes(zoneTypes map[string]allowedTypes, args []string, zones []string) error {
+	a := make(allowedTypes, len(args))
+	var in []string
+	for _, arg := range args {
+		if qtype, ok := dns.StringToType[strings.ToUpper(arg)]; ok {
+			a[qtype] = true
+			continue
+		}
+		zone := plugin.Host(arg).NormalizeExact()
+		if len(zone) == 0 || plugin.Zones(zones).Matches(zone[0]) != zone[0] {
+			return fmt.Errorf("'%s' is neither a record type nor a zone of the plugin", arg)
+		}
+		in = append(in, zone[0])
+	}
+	if len(a) == 0 {
+		return fmt.Errorf("allowed-types needs at least one type")
+	}
+	if len(in) == 0 {
+		in = zones
+	}
+	for _, zone := range in {
+		if _, ok := zoneTypes[zone]; ok {
+			return fmt.Errorf("allowed-types given twice for zone '%s'", zone)
+		}
+		zoneTypes[zone] = a
+	}
+	return nil
+}
+
+// filter returns the answers to a query of qtype that may be served. A single RR of a type that
+// isn't allowed, such as a CNAME leading to an allowed type, empties the answer, as the rest
+// wouldn't make sense without it. ANY queries keep the RRs of the allowed types.
+func (a allowedTypes) filter(qtype uint16, answers []dns.RR) []dns.RR {
+	if a == nil {
+		return answers
+	}
+	allowed := make([]dns.RR, 0, len(answers))
+	for _, rr := range answers {
+		switch {
+		case a[rr.Header().Rrtype]:
+			allowed = append(allowed, rr)
+		case qtype != dns.TypeANY:
+			return []dns.RR{}
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,71 @@
+package nightlightdns
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupAllowedTypes(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns example.com {\nallowed-types A TXT\n}", false},
+		{"nightlightdns example.com example.org {\nallowed-types a txt example.com\nallowed-types AAAA example.org\n}", false},
+		{"nightlightdns example.com {\nallowed-types\n}", true},
+		{"nightlightdns example.com {\nallowed-types example.com\n}", true},
+		{"nightlightdns example.com {\nallowed-types A example.net\n}", true},
+		{"nightlightdns example.com {\nallowed-types A\nallowed-types TXT\n}", true},
+	})
+}
+
+func TestAllowedTypes(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1", "ipv6address": "2001:db8::1"},
+    {"name": "www", "type": "TXT", "text": "hello"},
+    {"name": "www", "type": "MX", "target": "mx", "preference": 10},
+    {"name": "alias", "type": "CNAME", "target": "www"},
+    {"name": "www.example.org.", "ipv6address": "2001:db8::2"}
+  ]
+}`)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com example.org {\nallowed-types A TXT example.com\n}")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`www.example.com. 30 IN TXT "hello"`)},
+		},
+		// Types that aren't allowed get NODATA, whatever the records are.
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeMX,
+		},
+		// The CNAME isn't allowed, so neither is the answer it leads to.
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeA,
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{
+				test.A("www.example.com. 30 IN A 192.0.2.1"),
+				test.TXT(`www.example.com. 30 IN TXT "hello"`),
+			},
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeAAAA,
+			Rcode: dns.RcodeNameError,
+		},
+		// Other zones serve all types.
+		{
+			Qname: "www.example.org.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("www.example.org. 30 IN AAAA 2001:db8::2")},
+		},
+	})
+}
@@ -0,0 +1,444 @@
+package nightlightdns
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// supported reports whether qtype is a query type the plugin answers.
+func supported(qtype uint16) bool {
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeTXT, dns.TypeMX, dns.TypeSRV, dns.TypePTR, dns.TypeNS, dns.TypeCAA,
+		dns.TypeHINFO, dns.TypeDNAME, dns.TypeTLSA, dns.TypeSSHFP, dns.TypeANY:
+		return true
+	}
+	return false
+}
+
+// defaultCNAMEDepth is the number of CNAMEs followed within the records by default.
+const defaultCNAMEDepth = 8
+
+// errCNAMELoop and errCNAMEDepth are returned when following CNAMEs doesn't end in time.
+var (
+	errCNAMELoop  = errors.New("CNAME loop")
+	errCNAMEDepth = errors.New("CNAME chain too long")
+)
+
+// errDNAMETooLong is returned when the name synthesized from a DNAME exceeds the maximum length.
+var errDNAMETooLong = errors.New("name synthesized from DNAME too long")
+
+// builder turns the records of a name into the RRs answering a query.
+type builder struct {
+	// ttl is the TTL of answers for records that don't set their own.
+	ttl uint32
+
+	// rr rotates the order of multi-address answers between queries.
+	rr *rotator
+
+	// depth is the number of CNAMEs followed, defaultCNAMEDepth if zero.
+	depth int
+
+	// roundRobin rotates multi-address answers, otherwise they're sorted by address.
+	roundRobin bool
+
+	// now returns the time records with an active window are checked against, time.Now if nil.
+	now func() time.Time
+}
+
+// answer returns the answer to a query for name and qtype. lookup returns the records of a name,
+// it's used for name itself and for CNAME targets. ErrNoSuchName is returned when name has no
+// records at all.
+func (b builder) answer(name string, qtype uint16, lookup func(string) ([]DNSRecord, error)) ([]dns.RR, error) {
+	records, err := lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrNoSuchName
+	}
+	// A name whose records are all outside their active window still exists.
+	records = b.active(records)
+
+	// ANY queries for a CNAME get the CNAME alone, it isn't followed.
+	record := cnameRecord(records)
+	if qtype == dns.TypeCNAME || qtype == dns.TypeANY || record == nil {
+		return b.records(name, qtype, records), nil
+	}
+
+	// A CNAME is the only record at its name, answer with it and whatever the target resolves to
+	// locally, following further CNAMEs. If a target isn't ours the resolver will continue from
+	// the last CNAME.
+	depth := b.depth
+	if depth == 0 {
+		depth = defaultCNAMEDepth
+	}
+	answers := []dns.RR{}
+	seen := map[string]bool{name: true}
+	for {
+		if len(answers) == depth {
+			return nil, errCNAMEDepth
+		}
+		answers = append(answers, cname(name, b.recordTTL(*record), record.Target))
+		name = record.Target
+		if seen[name] {
+			return nil, errCNAMELoop
+		}
+		seen[name] = true
+
+		targets, err := lookup(name)
+		if err == ErrNoSuchName {
+			return answers, nil
+		}
+		if err != nil {
+			// Don't hand out a partial answer when the store fails.
+			return nil, err
+		}
+		targets = b.active(targets)
+		if record = cnameRecord(targets); record == nil {
+			return append(answers, b.records(name, qtype, targets)...), nil
+		}
+	}
+}
+
+// records returns the RRs of type qtype for the records of name.
+func (b builder) records(name string, qtype uint16, records []DNSRecord) []dns.RR {
+	switch qtype {
+	case dns.TypeCNAME:
+		if record := cnameRecord(records); record != nil {
+			return []dns.RR{cname(name, b.recordTTL(*record), record.Target)}
+		}
+		return []dns.RR{}
+	case dns.TypeTXT:
+		return b.texts(name, records)
+	case dns.TypeMX:
+		return b.mxs(name, records)
+	case dns.TypeSRV:
+		return b.srvs(name, records)
+	case dns.TypeNS:
+		return b.nss(name, records)
+	case dns.TypeCAA:
+		return b.caas(name, records)
+	case dns.TypeHINFO:
+		return b.hinfos(name, records)
+	case dns.TypeDNAME:
+		return b.dnames(name, records)
+	case dns.TypeTLSA:
+		return b.tlsas(name, records)
+	case dns.TypeSSHFP:
+		return b.sshfps(name, records)
+	case dns.TypeANY:
+		// A CNAME is the only record at its name, whatever else the file has for it.
+		if record := cnameRecord(records); record != nil {
+			return []dns.RR{cname(name, b.recordTTL(*record), record.Target)}
+		}
+		answers := b.addresses(name, dns.TypeA, records)
+		answers = append(answers, b.addresses(name, dns.TypeAAAA, records)...)
+		answers = append(answers, b.texts(name, records)...)
+		answers = append(answers, b.mxs(name, records)...)
+		answers = append(answers, b.srvs(name, records)...)
+		answers = append(answers, b.nss(name, records)...)
+		answers = append(answers, b.caas(name, records)...)
+		answers = append(answers, b.hinfos(name, records)...)
+		answers = append(answers, b.dnames(name, records)...)
+		answers = append(answers, b.tlsas(name, records)...)
+		return append(answers, b.sshfps(name, records)...)
+	}
+	// Only answer with addresses of the requested family. A name that exists but has no address of
+	// that family gets an empty NOERROR (NODATA) response.
+	return b.addresses(name, qtype, records)
+}
+
+// recordTTL returns the TTL to answer with for record.
+func (b builder) recordTTL(record DNSRecord) uint32 {
+	if record.TTL != nil {
+		return uint32(*record.TTL)
+	}
+	return b.ttl
+}
+
+// addresses returns the A or AAAA RRs, depending on qtype, for the records of name. Records without
+// an address of that family are skipped. If any of the records has a weight, the first address is
+// picked proportionally to the weights. Otherwise the order is rotated with round robin, or sorted
+// by address so every query gets the same answer.
+func (b builder) addresses(name string, qtype uint16, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	weights, total := []int{}, 0
+	for _, record := range records {
+		if !record.isAddress() {
+			continue
+		}
+		for _, err := range record.mismatches(qtype) {
+			familyMismatches.Inc()
+			log.Debugf("Skipping address of %s: %v", name, err)
+		}
+		for _, ip := range record.addresses(qtype) {
+			switch qtype {
+			case dns.TypeA:
+				answers = append(answers, a(name, b.recordTTL(record), ip))
+			case dns.TypeAAAA:
+				answers = append(answers, aaaa(name, b.recordTTL(record), ip))
+			}
+			weights = append(weights, int(record.Weight))
+			total += int(record.Weight)
+		}
+	}
+	if total > 0 && len(answers) > 1 {
+		return weighted(answers, b.rr.pick(weights))
+	}
+	if b.roundRobin {
+		return rotate(answers, b.rr.next())
+	}
+	sortAddresses(answers)
+	return answers
+}
+
+// texts returns a TXT RR for every TXT record of name.
+func (b builder) texts(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "TXT" {
+			answers = append(answers, txt(name, b.recordTTL(record), record.Text))
+		}
+	}
+	return answers
+}
+
+// mxs returns the MX RRs of name, ordered by preference.
+func (b builder) mxs(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "MX" {
+			answers = append(answers, mx(name, b.recordTTL(record), record.Preference, record.Target))
+		}
+	}
+	sort.SliceStable(answers, func(i, j int) bool {
+		return answers[i].(*dns.MX).Preference < answers[j].(*dns.MX).Preference
+	})
+	return answers
+}
+
+// srvs returns the SRV RRs of name.
+func (b builder) srvs(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "SRV" {
+			answers = append(answers, srv(name, b.recordTTL(record), record))
+		}
+	}
+	return answers
+}
+
+// nss returns the NS RRs of name.
+func (b builder) nss(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "NS" {
+			answers = append(answers, ns(name, b.recordTTL(record), record.Target))
+		}
+	}
+	return answers
+}
+
+// caas returns the CAA RRs of name.
+func (b builder) caas(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "CAA" {
+			answers = append(answers, caa(name, b.recordTTL(record), record))
+		}
+	}
+	return answers
+}
+
+// hinfos returns the HINFO RRs of name.
+func (b builder) hinfos(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "HINFO" {
+			answers = append(answers, hinfo(name, b.recordTTL(record), record.CPU, record.OS))
+		}
+	}
+	return answers
+}
+
+// dnames returns the DNAME RRs of name.
+func (b builder) dnames(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "DNAME" {
+			answers = append(answers, dname(name, b.recordTTL(record), record.Target))
+		}
+	}
+	return answers
+}
+
+// tlsas returns the TLSA RRs of name.
+func (b builder) tlsas(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "TLSA" {
+			answers = append(answers, tlsa(name, b.recordTTL(record), record))
+		}
+	}
+	return answers
+}
+
+// sshfps returns the SSHFP RRs of name.
+func (b builder) sshfps(name string, records []DNSRecord) []dns.RR {
+	answers := []dns.RR{}
+	for _, record := range records {
+		if record.kind() == "SSHFP" {
+			answers = append(answers, sshfp(name, b.recordTTL(record), record))
+		}
+	}
+	return answers
+}
+
+// cnameRecord returns the CNAME record among records, or nil if there is none.
+func cnameRecord(records []DNSRecord) *DNSRecord {
+	for i := range records {
+		if records[i].kind() == "CNAME" {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// a returns an A RR for ip.
+func a(zone string, ttl uint32, ip net.IP) dns.RR {
+	r := new(dns.A)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}
+	r.A = ip
+	return r
+}
+
+// aaaa returns an AAAA RR for ip.
+func aaaa(zone string, ttl uint32, ip net.IP) dns.RR {
+	r := new(dns.AAAA)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}
+	r.AAAA = ip
+	return r
+}
+
+// cname returns a CNAME RR pointing to target.
+func cname(zone string, ttl uint32, target string) dns.RR {
+	r := new(dns.CNAME)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl}
+	r.Target = target
+	return r
+}
+
+// dname returns a DNAME RR redirecting the names below zone to those below target.
+func dname(zone string, ttl uint32, target string) dns.RR {
+	r := new(dns.DNAME)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeDNAME, Class: dns.ClassINET, Ttl: ttl}
+	r.Target = target
+	return r
+}
+
+// txt returns a TXT RR holding texts. Each text is split into the 255 byte character strings the
+// wire format allows.
+func txt(zone string, ttl uint32, texts []string) dns.RR {
+	r := new(dns.TXT)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl}
+	for _, t := range texts {
+		r.Txt = append(r.Txt, splitText(t)...)
+	}
+	return r
+}
+
+// splitText splits s into chunks of at most 255 bytes.
+func splitText(s string) []string {
+	const max = 255
+	if len(s) <= max {
+		return []string{s}
+	}
+	chunks := make([]string, 0, len(s)/max+1)
+	for len(s) > max {
+		chunks = append(chunks, s[:max])
+		s = s[max:]
+	}
+	return append(chunks, s)
+}
+
+// mx returns an MX RR for the mail exchange target.
+func mx(zone string, ttl uint32, preference uint16, target string) dns.RR {
+	r := new(dns.MX)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: ttl}
+	r.Preference = preference
+	r.Mx = target
+	return r
+}
+
+// srv returns an SRV RR for the SRV record.
+func srv(zone string, ttl uint32, record DNSRecord) dns.RR {
+	r := new(dns.SRV)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl}
+	r.Priority = record.Priority
+	r.Weight = record.Weight
+	r.Port = uint16(*record.Port)
+	r.Target = record.Target
+	return r
+}
+
+// ns returns an NS RR for the name server target.
+func ns(zone string, ttl uint32, target string) dns.RR {
+	r := new(dns.NS)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl}
+	r.Ns = target
+	return r
+}
+
+// caa returns a CAA RR for the CAA record.
+func caa(zone string, ttl uint32, record DNSRecord) dns.RR {
+	r := new(dns.CAA)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: ttl}
+	r.Flag = record.Flag
+	r.Tag = record.Tag
+	r.Value = record.Value
+	return r
+}
+
+// tlsa returns a TLSA RR for the TLSA record.
+func tlsa(zone string, ttl uint32, record DNSRecord) dns.RR {
+	r := new(dns.TLSA)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeTLSA, Class: dns.ClassINET, Ttl: ttl}
+	r.Usage = record.Usage
+	r.Selector = record.Selector
+	r.MatchingType = record.MatchingType
+	r.Certificate = strings.ToLower(record.Certificate)
+	return r
+}
+
+// sshfp returns an SSHFP RR for the SSHFP record.
+func sshfp(zone string, ttl uint32, record DNSRecord) dns.RR {
+	r := new(dns.SSHFP)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeSSHFP, Class: dns.ClassINET, Ttl: ttl}
+	r.Algorithm = record.Algorithm
+	r.Type = record.FingerprintType
+	r.FingerPrint = strings.ToLower(record.Fingerprint)
+	return r
+}
+
+// hinfo returns an HINFO RR for the host's cpu and os. Minimal ANY answers, as described in RFC
+// 8482, are an HINFO RR with "RFC8482" as the cpu.
+func hinfo(zone string, ttl uint32, cpu, os string) dns.RR {
+	r := new(dns.HINFO)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: ttl}
+	r.Cpu = cpu
+	r.Os = os
+	return r
+}
+
+// ptr returns a PTR RR pointing to name.
+func ptr(zone string, ttl uint32, name string) dns.RR {
+	r := new(dns.PTR)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}
+	r.Ptr = name
+	return r
+}
@@ -0,0 +1,653 @@
+package nightlightdns
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// setupErr returns the error of setting up the plugin serving records for example.com.
+func setupErr(t *testing.T, records string) error {
+	t.Helper()
+	path := writeFile(t, t.TempDir(), "dns.json", records)
+	return setup(caddy.NewTestController("dns", "nightlightdns "+path+" example.com"))
+}
+
+func TestTTL(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "short", "ipaddress": "192.0.2.2", "ttl": 5},
+    {"name": "zero", "ipaddress": "192.0.2.3", "ttl": 0}
+  ]
+}`
+	tests := []struct {
+		options string
+		cases   []test.Case
+	}{
+		{"", []test.Case{
+			{Qname: "www.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")}},
+			{Qname: "short.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("short.example.com. 5 IN A 192.0.2.2")}},
+			{Qname: "zero.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("zero.example.com. 0 IN A 192.0.2.3")}},
+		}},
+		{"ttl 300", []test.Case{
+			{Qname: "www.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("www.example.com. 300 IN A 192.0.2.1")}},
+			{Qname: "short.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("short.example.com. 5 IN A 192.0.2.2")}},
+		}},
+	}
+	for _, tc := range tests {
+		checkCases(t, newRecordsPlugin(t, records, tc.options), tc.cases)
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	for i, ttl := range []string{"-1", "4294967296"} {
+		err := setupErr(t, `{"origin": "example.com.", "records": [{"name": "www", "ipaddress": "192.0.2.1", "ttl": `+ttl+`}]}`)
+		if err == nil {
+			t.Errorf("Test %d: expected an error for ttl %s, got none", i, ttl)
+		}
+	}
+}
+
+func TestCNAME(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "alias", "type": "CNAME", "target": "www"},
+    {"name": "external", "type": "CNAME", "target": "www.example.org."}
+  ]
+}`)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("alias.example.com. 30 IN CNAME www.example.com."),
+				test.A("www.example.com. 30 IN A 192.0.2.1"),
+			},
+		},
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeCNAME,
+			Answer: []dns.RR{test.CNAME("alias.example.com. 30 IN CNAME www.example.com.")},
+		},
+		// The resolver continues from a CNAME leaving the zones.
+		{
+			Qname: "external.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.CNAME("external.example.com. 30 IN CNAME www.example.org.")},
+		},
+	})
+}
+
+func TestCNAMEChain(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "type": "CNAME", "target": "app"},
+    {"name": "app", "type": "CNAME", "target": "backend"},
+    {"name": "backend", "ipaddress": "192.0.2.1"},
+    {"name": "self", "type": "CNAME", "target": "self"},
+    {"name": "ping", "type": "CNAME", "target": "pong"},
+    {"name": "pong", "type": "CNAME", "target": "ping"}
+  ]
+}`
+	tests := []struct {
+		options string
+		tc      test.Case
+	}{
+		{"", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("app.example.com. 30 IN CNAME backend.example.com."),
+				test.A("backend.example.com. 30 IN A 192.0.2.1"),
+				test.CNAME("www.example.com. 30 IN CNAME app.example.com."),
+			},
+		}},
+		{"", test.Case{
+			Qname: "self.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		}},
+		{"", test.Case{
+			Qname: "ping.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		}},
+		// The chain of www is longer than the depth allows.
+		{"cname-depth 1", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		}},
+		{"cname-depth 1", test.Case{
+			Qname: "app.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("app.example.com. 30 IN CNAME backend.example.com."),
+				test.A("backend.example.com. 30 IN A 192.0.2.1"),
+			},
+		}},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options)
+		resp := exchange(n, tc.tc.Msg())
+		// SortAndCheck sorts the answers, check the chain is in order first.
+		if err := test.CNAMEOrder(resp); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+		if err := test.SortAndCheck(resp, tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		length int
+		chunks []int
+	}{
+		{0, []int{0}},
+		{10, []int{10}},
+		{255, []int{255}},
+		{256, []int{255, 1}},
+		{600, []int{255, 255, 90}},
+	}
+	for i, tc := range tests {
+		chunks := splitText(strings.Repeat("x", tc.length))
+		if len(chunks) != len(tc.chunks) {
+			t.Errorf("Test %d: expected %d chunks, got %d", i, len(tc.chunks), len(chunks))
+			continue
+		}
+		for j, chunk := range chunks {
+			if len(chunk) != tc.chunks[j] {
+				t.Errorf("Test %d: expected chunk %d of %d bytes, got %d", i, j, tc.chunks[j], len(chunk))
+			}
+		}
+	}
+}
+
+func TestTXT(t *testing.T) {
+	long := strings.Repeat("a", 255) + strings.Repeat("b", 45)
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "type": "TXT", "text": "v=spf1 -all"},
+    {"name": "multi", "type": "TXT", "text": ["one", "two"]},
+    {"name": "long", "type": "TXT", "text": "`+long+`"}
+  ]
+}`)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`www.example.com. 30 IN TXT "v=spf1 -all"`)},
+		},
+		{
+			Qname: "multi.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`multi.example.com. 30 IN TXT "one" "two"`)},
+		},
+		{
+			Qname: "long.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`long.example.com. 30 IN TXT "` + long[:255] + `" "` + long[255:] + `"`)},
+		},
+	})
+}
+
+func TestMX(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "@", "type": "MX", "target": "backup", "preference": 20},
+    {"name": "@", "type": "MX", "target": "mx.example.org.", "preference": 5},
+    {"name": "@", "type": "MX", "target": "primary", "preference": 10}
+  ]
+}`)
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeMX)
+	resp := exchange(n, m)
+
+	expected := []struct {
+		preference uint16
+		target     string
+	}{
+		{5, "mx.example.org."},
+		{10, "primary.example.com."},
+		{20, "backup.example.com."},
+	}
+	if len(resp.Answer) != len(expected) {
+		t.Fatalf("Expected %d answers, got %d", len(expected), len(resp.Answer))
+	}
+	for i, want := range expected {
+		mx := resp.Answer[i].(*dns.MX)
+		if mx.Preference != want.preference || mx.Mx != want.target {
+			t.Errorf("Test %d: expected MX %d %s, got %d %s", i, want.preference, want.target, mx.Preference, mx.Mx)
+		}
+		if !dns.IsFqdn(mx.Mx) {
+			t.Errorf("Test %d: expected a fully qualified target, got %s", i, mx.Mx)
+		}
+	}
+}
+
+func TestSRV(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "_http._tcp.svc", "type": "SRV", "target": "web", "port": 8080, "priority": 10, "weight": 60},
+    {"name": "web", "ipaddress": "192.0.2.1"}
+  ]
+}`)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "_http._tcp.svc.example.com.", Qtype: dns.TypeSRV,
+			Answer: []dns.RR{test.SRV("_http._tcp.svc.example.com. 30 IN SRV 10 60 8080 web.example.com.")},
+		},
+		{
+			Qname: "_http._tcp.svc.example.com.", Qtype: dns.TypeA,
+		},
+	})
+}
+
+func TestPTR(t *testing.T) {
+	n := newTestPlugin(t, "nightlightdns "+writeFile(t, t.TempDir(), "dns.json", testRecords)+" example.com in-addr.arpa ip6.arpa")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "1.2.0.192.in-addr.arpa.", Qtype: dns.TypePTR,
+			Answer: []dns.RR{test.PTR("1.2.0.192.in-addr.arpa. 30 IN PTR www.example.com.")},
+		},
+		{
+			Qname: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", Qtype: dns.TypePTR,
+			Answer: []dns.RR{test.PTR("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 30 IN PTR www.example.com.")},
+		},
+		{
+			Qname: "9.2.0.192.in-addr.arpa.", Qtype: dns.TypePTR,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+}
+
+func TestCAA(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "@", "type": "CAA", "flag": 0, "tag": "issue", "value": "letsencrypt.org"},
+    {"name": "@", "type": "CAA", "flag": 128, "tag": "iodef", "value": "mailto:security@example.com"}
+  ]
+}`)
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeCAA)
+	resp := exchange(n, m)
+
+	expected := []dns.CAA{
+		{Flag: 0, Tag: "issue", Value: "letsencrypt.org"},
+		{Flag: 128, Tag: "iodef", Value: "mailto:security@example.com"},
+	}
+	if len(resp.Answer) != len(expected) {
+		t.Fatalf("Expected %d answers, got %d", len(expected), len(resp.Answer))
+	}
+	for i, want := range expected {
+		caa, ok := resp.Answer[i].(*dns.CAA)
+		if !ok {
+			t.Fatalf("Test %d: expected a CAA RR, got %s", i, resp.Answer[i])
+		}
+		if caa.Flag != want.Flag || caa.Tag != want.Tag || caa.Value != want.Value {
+			t.Errorf("Test %d: expected CAA %d %s %q, got %d %s %q", i, want.Flag, want.Tag, want.Value, caa.Flag, caa.Tag, caa.Value)
+		}
+	}
+}
+
+func TestANY(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1", "ipv6address": "2001:db8::1"},
+    {"name": "www", "type": "TXT", "text": "hello"},
+    {"name": "www", "type": "MX", "target": "mx", "preference": 10},
+    {"name": "alias", "type": "CNAME", "target": "www"},
+    {"name": "web", "type": "CNAME", "target": "www"},
+    {"name": "web", "ipaddress": "192.0.2.7"}
+  ]
+}`
+	tests := []struct {
+		options string
+		tc      test.Case
+	}{
+		{"", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{
+				test.A("www.example.com. 30 IN A 192.0.2.1"),
+				test.AAAA("www.example.com. 30 IN AAAA 2001:db8::1"),
+				test.MX("www.example.com. 30 IN MX 10 mx.example.com."),
+				test.TXT(`www.example.com. 30 IN TXT "hello"`),
+			},
+		}},
+		// The CNAME is the only RR of its owner, its target isn't followed.
+		{"", test.Case{
+			Qname: "alias.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{test.CNAME("alias.example.com. 30 IN CNAME www.example.com.")},
+		}},
+		// Other records next to a CNAME aren't answered with.
+		{"", test.Case{
+			Qname: "web.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{test.CNAME("web.example.com. 30 IN CNAME www.example.com.")},
+		}},
+		{"", test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeANY,
+			Rcode: dns.RcodeNameError,
+		}},
+		{"minimal-any", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{test.HINFO(`www.example.com. 30 IN HINFO "RFC8482" ""`)},
+		}},
+		{"minimal-any", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		}},
+		{"minimal-any", test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeANY,
+			Rcode: dns.RcodeNameError,
+		}},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options)
+		if err := test.SortAndCheck(exchange(n, tc.tc.Msg()), tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestCommaSeparatedAddresses(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "10.0.0.1,10.0.0.2, 10.0.0.3", "ipv6address": " 2001:db8::1 ,2001:db8::2"}
+  ]
+}`)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("www.example.com. 30 IN A 10.0.0.1"),
+				test.A("www.example.com. 30 IN A 10.0.0.2"),
+				test.A("www.example.com. 30 IN A 10.0.0.3"),
+			},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{
+				test.AAAA("www.example.com. 30 IN AAAA 2001:db8::1"),
+				test.AAAA("www.example.com. 30 IN AAAA 2001:db8::2"),
+			},
+		},
+	})
+}
+
+func TestFamilyMismatch(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "v6only", "ipaddress": "2001:db8::1"},
+    {"name": "mixed", "ipaddress": "192.0.2.2, 2001:db8::2"}
+  ]
+}`)
+	tests := []struct {
+		tc         test.Case
+		mismatches float64
+	}{
+		{test.Case{Qname: "v6only.example.com.", Qtype: dns.TypeA}, 1},
+		// IPv6 literals in ipaddress still answer AAAA queries.
+		{test.Case{
+			Qname: "v6only.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("v6only.example.com. 30 IN AAAA 2001:db8::1")},
+		}, 0},
+		{test.Case{
+			Qname: "mixed.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("mixed.example.com. 30 IN A 192.0.2.2")},
+		}, 1},
+	}
+	for i, tc := range tests {
+		before := testutil.ToFloat64(familyMismatches)
+		if err := test.SortAndCheck(exchange(n, tc.tc.Msg()), tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+		if got := testutil.ToFloat64(familyMismatches) - before; got != tc.mismatches {
+			t.Errorf("Test %d: expected %v family mismatches, got %v", i, tc.mismatches, got)
+		}
+	}
+}
+
+func TestSetupFamilyMismatch(t *testing.T) {
+	// IPv4 addresses in ipv6address never answered anything, they are rejected.
+	err := setupErr(t, `{"origin": "example.com.", "records": [{"name": "www", "ipv6address": "192.0.2.1"}]}`)
+	if err == nil || !strings.Contains(err.Error(), "can't answer AAAA queries") {
+		t.Errorf("Expected an error for an IPv4 address in ipv6address, got: %v", err)
+	}
+}
+
+func TestMismatches(t *testing.T) {
+	record := DNSRecord{Name: "www", Ipaddress: "192.0.2.1,2001:db8::1", Ipv6address: "192.0.2.2"}
+	tests := []struct {
+		qtype   uint16
+		address string
+	}{
+		{dns.TypeA, "2001:db8::1"},
+		{dns.TypeAAAA, "192.0.2.2"},
+	}
+	for i, tc := range tests {
+		errs := record.mismatches(tc.qtype)
+		if len(errs) != 1 {
+			t.Fatalf("Test %d: expected 1 mismatch, got %v", i, errs)
+		}
+		var mismatch *FamilyMismatchError
+		if !errors.As(errs[0], &mismatch) || mismatch.Address != tc.address || mismatch.Qtype != tc.qtype {
+			t.Errorf("Test %d: expected a FamilyMismatchError for %s, got %v", i, tc.address, errs[0])
+		}
+	}
+}
+
+// TestCNAMEGuard checks the runtime guard, for chains a store keeps generating.
+func TestCNAMEGuard(t *testing.T) {
+	endless := func(name string) ([]DNSRecord, error) {
+		return []DNSRecord{{Name: name, Type: "CNAME", Target: "x" + name}}, nil
+	}
+	loop := func(name string) ([]DNSRecord, error) {
+		target := "a.example.com."
+		if name == target {
+			target = "b.example.com."
+		}
+		return []DNSRecord{{Name: name, Type: "CNAME", Target: target}}, nil
+	}
+	tests := []struct {
+		depth  int
+		lookup func(string) ([]DNSRecord, error)
+		err    error
+	}{
+		{0, endless, errCNAMEDepth},
+		{3, endless, errCNAMEDepth},
+		{0, loop, errCNAMELoop},
+	}
+	for i, tc := range tests {
+		b := builder{ttl: defaultTTL, rr: newRotator(), depth: tc.depth}
+		if _, err := b.answer("a.example.com.", dns.TypeA, tc.lookup); !errors.Is(err, tc.err) {
+			t.Errorf("Test %d: expected %v, got %v", i, tc.err, err)
+		}
+	}
+}
+
+func TestHINFO(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "host", "type": "HINFO", "cpu": "x86_64", "os": "Linux", "ttl": 60},
+    {"name": "host", "ipaddress": "192.0.2.1"},
+    {"name": "www", "ipaddress": "192.0.2.2"}
+  ]
+}`
+	tests := []struct {
+		options string
+		tc      test.Case
+	}{
+		{"", test.Case{
+			Qname: "host.example.com.", Qtype: dns.TypeHINFO,
+			Answer: []dns.RR{test.HINFO(`host.example.com. 60 IN HINFO "x86_64" "Linux"`)},
+		}},
+		{"", test.Case{
+			Qname: "host.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{
+				test.A("host.example.com. 30 IN A 192.0.2.1"),
+				test.HINFO(`host.example.com. 60 IN HINFO "x86_64" "Linux"`),
+			},
+		}},
+		{"", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeHINFO,
+		}},
+		// Explicit HINFO queries still get the configured records.
+		{"minimal-any", test.Case{
+			Qname: "host.example.com.", Qtype: dns.TypeHINFO,
+			Answer: []dns.RR{test.HINFO(`host.example.com. 60 IN HINFO "x86_64" "Linux"`)},
+		}},
+		{"minimal-any", test.Case{
+			Qname: "host.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{test.HINFO(`host.example.com. 30 IN HINFO "RFC8482" ""`)},
+		}},
+		{"minimal-any", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeANY,
+			Answer: []dns.RR{test.HINFO(`www.example.com. 30 IN HINFO "RFC8482" ""`)},
+		}},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options)
+		if err := test.SortAndCheck(exchange(n, tc.tc.Msg()), tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestSetupHINFO(t *testing.T) {
+	err := setupErr(t, `{"origin": "example.com.", "records": [{"name": "host", "type": "HINFO", "os": "Linux"}]}`)
+	if err == nil || !strings.Contains(err.Error(), "HINFO record needs a cpu") {
+		t.Errorf("Expected an HINFO record without a cpu to fail, got %v", err)
+	}
+	testParse(t, []parseTest{
+		{"nightlightdns {\nminimal-any\n}", false},
+		{"nightlightdns {\nminimal-any hinfo\n}", true},
+	})
+}
+
+func TestTLSA(t *testing.T) {
+	digest := strings.Repeat("AB", 32)
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "_443._tcp.www", "type": "TLSA", "usage": 3, "selector": 1, "matching_type": 1, "certificate": "`+digest+`", "ttl": 60},
+    {"name": "www", "ipaddress": "192.0.2.1"}
+  ]
+}`)
+	tests := []struct {
+		qname    string
+		expected []string
+	}{
+		{"_443._tcp.www.example.com.", []string{"_443._tcp.www.example.com.\t60\tIN\tTLSA\t3 1 1 " + strings.ToLower(digest)}},
+		{"www.example.com.", nil},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeTLSA)
+		resp := exchange(n, m)
+		if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != len(tc.expected) {
+			t.Fatalf("Test %d: expected %d answers, got %s", i, len(tc.expected), resp)
+		}
+		for j, rr := range resp.Answer {
+			if rr.String() != tc.expected[j] {
+				t.Errorf("Test %d: expected %q, got %q", i, tc.expected[j], rr)
+			}
+		}
+	}
+}
+
+func TestSetupTLSA(t *testing.T) {
+	tests := []struct {
+		record string
+		err    string
+	}{
+		{`"usage": 3, "selector": 1, "matching_type": 1, "certificate": "` + strings.Repeat("ab", 32) + `"`, ""},
+		{`"usage": 3, "selector": 1, "matching_type": 2, "certificate": "` + strings.Repeat("ab", 64) + `"`, ""},
+		// Full certificates can be of any size.
+		{`"usage": 3, "selector": 0, "matching_type": 0, "certificate": "3082"`, ""},
+		{`"usage": 3, "selector": 1, "matching_type": 1, "certificate": "xyz"`, "must be hex encoded"},
+		{`"usage": 3, "selector": 1, "matching_type": 1`, "needs a certificate"},
+		{`"usage": 3, "selector": 1, "matching_type": 1, "certificate": "abcd"`, "certificate of 2 bytes, matching_type 1 needs 32"},
+		{`"usage": 4, "selector": 1, "matching_type": 0, "certificate": "abcd"`, "invalid usage 4"},
+		{`"usage": 3, "selector": 2, "matching_type": 0, "certificate": "abcd"`, "invalid selector 2"},
+		{`"usage": 3, "selector": 1, "matching_type": 3, "certificate": "abcd"`, "invalid matching_type 3"},
+	}
+	for i, tc := range tests {
+		err := setupErr(t, `{"origin": "example.com.", "records": [{"name": "_443._tcp.www", "type": "TLSA", `+tc.record+`}]}`)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("Test %d: expected an error containing %q, got %v", i, tc.err, err)
+		}
+	}
+}
+
+func TestSSHFP(t *testing.T) {
+	sha1 := strings.Repeat("0F", 20)
+	sha256 := strings.Repeat("a5", 32)
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "host", "type": "SSHFP", "algorithm": 4, "fp_type": 2, "fingerprint": "`+sha256+`"},
+    {"name": "host", "type": "SSHFP", "algorithm": 1, "fp_type": 1, "fingerprint": "`+sha1+`"},
+    {"name": "host", "ipaddress": "192.0.2.1"}
+  ]
+}`)
+	m := new(dns.Msg)
+	m.SetQuestion("host.example.com.", dns.TypeSSHFP)
+	resp := exchange(n, m)
+
+	// Fingerprints are presented in upper case.
+	expected := []string{
+		"host.example.com.\t30\tIN\tSSHFP\t1 1 " + sha1,
+		"host.example.com.\t30\tIN\tSSHFP\t4 2 " + strings.ToUpper(sha256),
+	}
+	got := []string{}
+	for _, rr := range resp.Answer {
+		got = append(got, rr.String())
+	}
+	sort.Strings(got)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d answers, got %q", len(expected), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Test %d: expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestSetupSSHFP(t *testing.T) {
+	tests := []struct {
+		record string
+		err    string
+	}{
+		{`"algorithm": 4, "fp_type": 2, "fingerprint": "` + strings.Repeat("ab", 32) + `"`, ""},
+		{`"algorithm": 6, "fp_type": 1, "fingerprint": "` + strings.Repeat("ab", 20) + `"`, ""},
+		{`"algorithm": 4, "fp_type": 2, "fingerprint": "not hex"`, "must be hex encoded"},
+		{`"algorithm": 4, "fp_type": 2, "fingerprint": "` + strings.Repeat("ab", 20) + `"`, "fingerprint of 20 bytes, fp_type 2 needs 32"},
+		{`"algorithm": 4, "fp_type": 2`, "fingerprint of 0 bytes"},
+		{`"algorithm": 5, "fp_type": 2, "fingerprint": "` + strings.Repeat("ab", 32) + `"`, "invalid algorithm 5"},
+		{`"algorithm": 4, "fp_type": 3, "fingerprint": "` + strings.Repeat("ab", 32) + `"`, "invalid fp_type 3"},
+	}
+	for i, tc := range tests {
+		err := setupErr(t, `{"origin": "example.com.", "records": [{"name": "host", "type": "SSHFP", `+tc.record+`}]}`)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("Test %d: expected no error, got %v", i, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("Test %d: expected an error containing %q, got %v", i, tc.err, err)
+		}
+	}
+}
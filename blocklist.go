@@ -0,0 +1,102 @@
+package nightlightdns
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// blocklist answers queries for the names it lists with a block response, before any lookup.
+type blocklist struct {
+	// names are the blocked names, wildcards the names all names below of are blocked.
+	names     map[string]bool
+	wildcards map[string]bool
+
+	// rcode is the response code of block responses, unless sinkhole answers them with addresses.
+	rcode    int
+	sinkhole *catchAll
+}
+
+// newBlocklist loads the blocklist at path, answering blocked queries as response says: nxdomain,
+// refused, or with the sinkhole addresses given.
+func newBlocklist(path string, response []string) (*blocklist, error) {
+	b := &blocklist{names: map[string]bool{}, wildcards: map[string]bool{}, rcode: dns.RcodeNameError}
+	switch {
+	case len(response) == 0 || (len(response) == 1 && response[0] == "nxdomain"):
+	case len(response) == 1 && response[0] == "refused":
+		b.rcode = dns.RcodeRefused
+	default:
+		b.sinkhole = &catchAll{}
+		for _, addr := range response {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid blocklist response '%s', expected nxdomain, refused or addresses", addr)
+			}
+			b.sinkhole.ips = append(b.sinkhole.ips, ip)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return b, b.read(f, path)
+}
+
+// read adds the names of the blocklist file f, one per line. Lines starting with "*." block all
+// names below the rest of the line, empty lines and comments starting with "#" are skipped.
+func (b *blocklist) read(f *os.File, path string) error {
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		name := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(name, "#"); i >= 0 {
+			name = strings.TrimSpace(name[:i])
+		}
+		if name == "" {
+			continue
+		}
+		wildcard := strings.HasPrefix(name, "*.")
+		name = qualify(strings.TrimPrefix(name, "*."), ".")
+		if _, ok := dns.IsDomainName(name); !ok {
+			return fmt.Errorf("invalid name %q on line %d of blocklist %q", name, line, path)
+		}
+		if wildcard {
+			b.wildcards[name] = true
+		} else {
+			b.names[name] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// blocked reports whether name, lowercased and fully qualified, is blocked.
+func (b *blocklist) blocked(name string) bool {
+	if b.names[name] {
+		return true
+	}
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		if b.wildcards[name[off:]] {
+			return true
+		}
+	}
+	return false
+}
+
+// block writes the block response to state. It's made up rather than taken from the records, so
+// it isn't authoritative with StrictAA.
+func (n Nightlightdns) block(state request.Request) (int, error) {
+	b := n.blocklist
+	switch {
+	case b.sinkhole != nil:
+		return n.reply(state, b.sinkhole.answer(state.Name(), state.QType()), nil, true)
+	case b.rcode == dns.RcodeNameError:
+		return n.nxdomain(state, true)
+	}
+	return n.dnserror(b.rcode, state, nil)
+}
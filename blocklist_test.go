@@ -0,0 +1,112 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testBlocklist = `# Blocked names
+www.example.com
+*.ads.example.com.   # and everything below
+Tracker.Example.com
+`
+
+func TestSetupBlocklist(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "blocklist", testBlocklist)
+	invalid := writeFile(t, dir, "invalid", "www.example.com\nbad..name\n")
+	testParse(t, []parseTest{
+		{fmt.Sprintf("nightlightdns {\nblocklist %s\n}", path), false},
+		{fmt.Sprintf("nightlightdns {\nblocklist %s nxdomain\n}", path), false},
+		{fmt.Sprintf("nightlightdns {\nblocklist %s refused\n}", path), false},
+		{fmt.Sprintf("nightlightdns {\nblocklist %s 0.0.0.0 ::\n}", path), false},
+		{fmt.Sprintf("nightlightdns {\nblocklist %s servfail\n}", path), true},
+		{fmt.Sprintf("nightlightdns {\nblocklist %s\n}", invalid), true},
+		{"nightlightdns {\nblocklist /does/not/exist\n}", true},
+		{"nightlightdns {\nblocklist\n}", true},
+	})
+}
+
+func TestBlocked(t *testing.T) {
+	b, err := newBlocklist(writeFile(t, t.TempDir(), "blocklist", testBlocklist), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		blocked bool
+	}{
+		{"www.example.com.", true},
+		{"tracker.example.com.", true},
+		{"app.www.example.com.", false},
+		{"example.com.", false},
+		{"pixel.ads.example.com.", true},
+		{"a.b.ads.example.com.", true},
+		// Wildcards only cover the names below.
+		{"ads.example.com.", false},
+		{"badads.example.com.", false},
+	}
+	for i, tc := range tests {
+		if got := b.blocked(tc.name); got != tc.blocked {
+			t.Errorf("Test %d: expected blocked %v for %s, got %v", i, tc.blocked, tc.name, got)
+		}
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "blocklist", testBlocklist)
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "app", "ipaddress": "192.0.2.2"}
+  ]
+}`
+	tests := []struct {
+		response string
+		tc       test.Case
+	}{
+		// Blocked names are blocked whatever records they have.
+		{"", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		}},
+		{"", test.Case{
+			Qname: "WWW.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		}},
+		{"", test.Case{
+			Qname: "app.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("app.example.com. 30 IN A 192.0.2.2")},
+		}},
+		{"refused", test.Case{
+			Qname: "pixel.ads.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeRefused,
+		}},
+		{"0.0.0.0 ::", test.Case{
+			Qname: "pixel.ads.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("pixel.ads.example.com. 30 IN A 0.0.0.0")},
+		}},
+		{"0.0.0.0 ::", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("www.example.com. 30 IN AAAA ::")},
+		}},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, fmt.Sprintf("blocklist %s %s", path, tc.response))
+		before := testutil.ToFloat64(blocked.WithLabelValues(""))
+		checkCases(t, n, []test.Case{tc.tc})
+		want := before
+		if tc.tc.Qname != "app.example.com." {
+			want++
+		}
+		if got := testutil.ToFloat64(blocked.WithLabelValues("")); got != want {
+			t.Errorf("Test %d: expected blocked_total %v, got %v", i, want, got)
+		}
+	}
+}
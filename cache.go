@@ -0,0 +1,133 @@
+package nightlightdns
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// backendNegativeTTL is how long the backends remember that a name has no records, by default.
+	backendNegativeTTL = 5 * time.Second
+	// backendCacheSize bounds the number of names a backend cache holds.
+	backendCacheSize = 10000
+)
+
+// recordCache is an LRU cache of the records of names, used by the backends so repeated queries
+// don't each go to the database or service. Records are cached for the lowest TTL among them.
+type recordCache struct {
+	sync.Mutex
+	// backend names the backend in the cache metrics.
+	backend string
+	// defaultTTL is the TTL of records without one. When ttl is set it's used for all records
+	// instead, negative is how long empty results are cached.
+	defaultTTL, ttl, negative time.Duration
+
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+
+	// now is the clock entries expire by.
+	now func() time.Time
+}
+
+// cacheEntry is a cached lookup result.
+type cacheEntry struct {
+	key     string
+	records []DNSRecord
+	expires time.Time
+}
+
+// newRecordCache returns a cache for backend holding up to size entries. Records without a TTL of
+// their own are cached for ttl seconds, empty results for backendNegativeTTL unless changed with
+// setNegativeTTL.
+func newRecordCache(backend string, ttl uint32, size int) *recordCache {
+	return &recordCache{
+		backend:    backend,
+		defaultTTL: time.Duration(ttl) * time.Second,
+		negative:   backendNegativeTTL,
+		size:       size,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// setTTL makes the cache keep all records for ttl, rather than for their TTL.
+func (c *recordCache) setTTL(ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.ttl = ttl
+}
+
+// setNegativeTTL sets how long empty results are cached.
+func (c *recordCache) setNegativeTTL(ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.negative = ttl
+}
+
+// get returns the records cached under key, if they haven't expired.
+func (c *recordCache) get(key string) ([]DNSRecord, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	if ok && c.now().After(e.Value.(*cacheEntry).expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		cacheMisses.WithLabelValues(c.backend).Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	cacheHits.WithLabelValues(c.backend).Inc()
+	return e.Value.(*cacheEntry).records, true
+}
+
+// set caches records under key. A full cache evicts the least recently used entry first.
+func (c *recordCache) set(key string, records []DNSRecord) {
+	c.Lock()
+	defer c.Unlock()
+	ttl := c.expiry(records)
+	if ttl <= 0 {
+		return
+	}
+	entry := &cacheEntry{key: key, records: records, expires: c.now().Add(ttl)}
+
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		cacheEvictions.WithLabelValues(c.backend).Inc()
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+}
+
+// expiry returns how long records are cached: the negative TTL for no records, the override if
+// set, and otherwise the lowest TTL of the records.
+func (c *recordCache) expiry(records []DNSRecord) time.Duration {
+	switch {
+	case len(records) == 0:
+		return c.negative
+	case c.ttl > 0:
+		return c.ttl
+	}
+	expiry := time.Duration(-1)
+	for _, record := range records {
+		ttl := c.defaultTTL
+		if record.TTL != nil {
+			ttl = time.Duration(*record.TTL) * time.Second
+		}
+		if expiry < 0 || ttl < expiry {
+			expiry = ttl
+		}
+	}
+	return expiry
+}
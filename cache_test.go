@@ -0,0 +1,163 @@
+package nightlightdns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSetupCacheTTL(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nbackend http http://localhost:8080/records\nnegcache-ttl 30s\n}", false},
+		{"nightlightdns {\nbackend http http://localhost:8080/records\ncache-ttl 1m\n}", false},
+		{"nightlightdns {\nbackend http http://localhost:8080/records\nnegcache-ttl\n}", true},
+		{"nightlightdns {\nbackend http http://localhost:8080/records\nnegcache-ttl soon\n}", true},
+	})
+}
+
+func TestNegativeCache(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"records": []}`))
+	}))
+	defer srv.Close()
+
+	h, err := NewHTTPBackend(srv.URL, time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	h.cache.now = func() time.Time { return now }
+	h.cache.setNegativeTTL(10 * time.Second)
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: h}
+	hits := cacheHits.WithLabelValues("http")
+	before := testutil.ToFloat64(hits)
+
+	tests := []struct {
+		elapsed  time.Duration // since the previous query
+		qtype    uint16
+		requests int32 // the requests made so far
+	}{
+		{0, dns.TypeA, 1},
+		// Served from the cache within the negative TTL.
+		{0, dns.TypeA, 1},
+		{9 * time.Second, dns.TypeA, 1},
+		// Other types are cached on their own.
+		{0, dns.TypeAAAA, 2},
+		// Expired after the negative TTL.
+		{2 * time.Second, dns.TypeA, 3},
+	}
+	for i, tc := range tests {
+		now = now.Add(tc.elapsed)
+		m := new(dns.Msg)
+		m.SetQuestion("nope.example.com.", tc.qtype)
+		if resp := exchange(n, m); resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			t.Errorf("Test %d: expected a negative answer, got %s", i, dns.RcodeToString[resp.Rcode])
+		}
+		if got := atomic.LoadInt32(&requests); got != tc.requests {
+			t.Errorf("Test %d: expected %d requests to the backend, got %d", i, tc.requests, got)
+		}
+	}
+	if got := testutil.ToFloat64(hits) - before; got != 2 {
+		t.Errorf("Expected 2 cache hits, got %v", got)
+	}
+}
+
+func TestPositiveCache(t *testing.T) {
+	requests := map[string]int{}
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		mu.Lock()
+		requests[name]++
+		mu.Unlock()
+		switch name {
+		case "www.example.com.":
+			w.Write([]byte(`{"records": [{"name": "www.example.com.", "ipaddress": "192.0.2.1", "ttl": 60}]}`))
+		case "app.example.com.":
+			w.Write([]byte(`{"records": [{"name": "app.example.com.", "ipaddress": "192.0.2.2"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	type step struct {
+		elapsed  time.Duration // since the previous query
+		requests int           // the requests for the name so far
+	}
+	tests := []struct {
+		cacheTTL time.Duration
+		name     string
+		steps    []step
+	}{
+		// Cached for the TTL of the record.
+		{0, "www.example.com.", []step{{0, 1}, {59 * time.Second, 1}, {2 * time.Second, 2}}},
+		// Cached for the default TTL without one.
+		{0, "app.example.com.", []step{{0, 1}, {29 * time.Second, 1}, {2 * time.Second, 2}}},
+		// cache-ttl overrides the TTL of the records.
+		{10 * time.Second, "www.example.com.", []step{{0, 1}, {9 * time.Second, 1}, {2 * time.Second, 2}}},
+	}
+	for i, tc := range tests {
+		h, err := NewHTTPBackend(srv.URL, time.Second, defaultTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		h.cache.now = func() time.Time { return now }
+		h.cache.setTTL(tc.cacheTTL)
+		n := Nightlightdns{Zones: []string{"example.com."}, Store: h}
+		mu.Lock()
+		requests[tc.name] = 0
+		mu.Unlock()
+
+		for j, st := range tc.steps {
+			now = now.Add(st.elapsed)
+			m := new(dns.Msg)
+			m.SetQuestion(tc.name, dns.TypeA)
+			if resp := exchange(n, m); len(resp.Answer) != 1 {
+				t.Errorf("Test %d, query %d: expected 1 answer for %s, got %d", i, j, tc.name, len(resp.Answer))
+			}
+			mu.Lock()
+			got := requests[tc.name]
+			mu.Unlock()
+			if got != st.requests {
+				t.Errorf("Test %d, query %d: expected %d requests for %s, got %d", i, j, st.requests, tc.name, got)
+			}
+		}
+	}
+}
+
+func TestRecordCacheEviction(t *testing.T) {
+	c := newRecordCache("test", defaultTTL, 2)
+	evictions := cacheEvictions.WithLabelValues("test")
+	records := []DNSRecord{{Name: "www.example.com.", Ipaddress: "192.0.2.1"}}
+
+	c.set("a", records)
+	c.set("b", records)
+	c.get("a")
+	c.set("c", records)
+
+	tests := []struct {
+		key    string
+		cached bool
+	}{
+		{"a", true},
+		// The least recently used entry was evicted.
+		{"b", false},
+		{"c", true},
+	}
+	for i, tc := range tests {
+		if _, ok := c.get(tc.key); ok != tc.cached {
+			t.Errorf("Test %d: expected %s cached %t, got %t", i, tc.key, tc.cached, ok)
+		}
+	}
+	if got := testutil.ToFloat64(evictions); got != 1 {
+		t.Errorf("Expected 1 eviction, got %v", got)
+	}
+}
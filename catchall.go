@@ -0,0 +1,28 @@
+package nightlightdns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// catchAll answers for names that have no records, with the addresses of the default directive.
+type catchAll struct {
+	ips []net.IP
+	ttl uint32
+}
+
+// answer returns the A or AAAA RRs, depending on qtype, of the default addresses for name. Other
+// query types get no answers.
+func (c *catchAll) answer(name string, qtype uint16) []dns.RR {
+	answers := []dns.RR{}
+	for _, ip := range c.ips {
+		switch {
+		case qtype == dns.TypeA && ip.To4() != nil:
+			answers = append(answers, a(name, c.ttl, ip.To4()))
+		case qtype == dns.TypeAAAA && ip.To4() == nil:
+			answers = append(answers, aaaa(name, c.ttl, ip))
+		}
+	}
+	return answers
+}
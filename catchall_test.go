@@ -0,0 +1,57 @@
+package nightlightdns
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupDefault(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\ndefault 192.0.2.53\n}", false},
+		{"nightlightdns {\ndefault 192.0.2.53 2001:db8::53\n}", false},
+		{"nightlightdns {\ndefault\n}", true},
+		{"nightlightdns {\ndefault sinkhole\n}", true},
+	})
+}
+
+func TestCatchAll(t *testing.T) {
+	tests := []struct {
+		options string
+		tc      test.Case
+	}{
+		{"", test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		}},
+		{"default 192.0.2.53 2001:db8::53", test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("nope.example.com. 30 IN A 192.0.2.53")},
+		}},
+		{"default 192.0.2.53 2001:db8::53", test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("nope.example.com. 30 IN AAAA 2001:db8::53")},
+		}},
+		{"default 192.0.2.53", test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeMX,
+		}},
+		// Names with records are answered from them.
+		{"default 192.0.2.53", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		}},
+		// Fallthrough passes the name on instead, the next plugin answers SERVFAIL.
+		{"default 192.0.2.53\nfallthrough", test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		}},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, testRecords, tc.options)
+		if err := test.SortAndCheck(exchange(n, tc.tc.Msg()), tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package nightlightdns
+
+import (
+	"os"
+	"strings"
+
+	"github.com/coredns/coredns/coremain"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// chaos answers the CHAOS class TXT queries servers conventionally answer with their version and
+// host name, following the chaos plugin. An empty version or host name is kept hidden.
+type chaos struct {
+	version  string
+	hostname string
+}
+
+// newChaos returns a chaos with the version from args, if given, and the host name of the machine.
+func newChaos(args []string) *chaos {
+	c := &chaos{version: "CoreDNS-" + coremain.CoreVersion}
+	if len(args) > 0 {
+		c.version = strings.Join(args, " ")
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		c.hostname = hostname
+	}
+	return c
+}
+
+// isChaosName reports whether name is one of the CHAOS names servers answer with their version and
+// host name.
+func isChaosName(name string) bool {
+	switch strings.ToLower(name) {
+	case "version.bind.", "version.server.", "hostname.bind.", "id.server.":
+		return true
+	}
+	return false
+}
+
+// answer returns the TXT RR answering a CHAOS query for name, or nil if name isn't one of ours or
+// its text is hidden.
+func (c *chaos) answer(name string) []dns.RR {
+	text := ""
+	switch strings.ToLower(name) {
+	case "version.bind.", "version.server.":
+		text = c.version
+	case "hostname.bind.", "id.server.":
+		text = c.hostname
+	}
+	if text == "" {
+		return nil
+	}
+	return []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0},
+		Txt: []string{text},
+	}}
+}
+
+// serveChaos writes the answer to a CHAOS TXT query for the server's version or host name. Those
+// that aren't configured are refused, so they stay hidden.
+func (n Nightlightdns) serveChaos(state request.Request) (bool, int, error) {
+	if state.QClass() != dns.ClassCHAOS || state.QType() != dns.TypeTXT || !isChaosName(state.Name()) {
+		return false, dns.RcodeSuccess, nil
+	}
+	var answers []dns.RR
+	if n.chaos != nil {
+		answers = n.chaos.answer(state.Name())
+	}
+	if answers == nil {
+		rcode, err := n.dnserror(dns.RcodeRefused, state, nil)
+		return true, rcode, err
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Authoritative = true
+	m.Answer = answers
+	n.writeMsg(state, m)
+	return true, dns.RcodeSuccess, nil
+}
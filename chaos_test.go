@@ -0,0 +1,69 @@
+package nightlightdns
+
+import (
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/coremain"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupVersion(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nversion 1.2.3\n}", false},
+		{"nightlightdns {\nversion\n}", true},
+		{"nightlightdns {\nchaos\n}", false},
+		{"nightlightdns {\nchaos NightlightDNS 1.0\n}", false},
+	})
+}
+
+func TestChaos(t *testing.T) {
+	hostname, _ := os.Hostname()
+	tests := []struct {
+		options string
+		qname   string
+		qtype   uint16
+		rcode   int
+		text    string
+	}{
+		// CHAOS queries are refused by default.
+		{"", "version.bind.", dns.TypeTXT, dns.RcodeRefused, ""},
+		{"", "hostname.bind.", dns.TypeTXT, dns.RcodeRefused, ""},
+		{"version 1.2.3", "version.bind.", dns.TypeTXT, dns.RcodeSuccess, "1.2.3"},
+		{"version 1.2.3", "VERSION.Server.", dns.TypeTXT, dns.RcodeSuccess, "1.2.3"},
+		{"version 1.2.3", "hostname.bind.", dns.TypeTXT, dns.RcodeRefused, ""},
+		// Other queries for the names aren't ours, the next plugin answers them.
+		{"version 1.2.3", "version.bind.", dns.TypeA, dns.RcodeServerFailure, ""},
+		{"version 1.2.3", "www.example.com.", dns.TypeTXT, dns.RcodeRefused, ""},
+		{"chaos", "version.bind.", dns.TypeTXT, dns.RcodeSuccess, "CoreDNS-" + coremain.CoreVersion},
+		{"chaos", "id.server.", dns.TypeTXT, dns.RcodeSuccess, hostname},
+		{"chaos NightlightDNS 1.0", "version.server.", dns.TypeTXT, dns.RcodeSuccess, "NightlightDNS 1.0"},
+		// The version directive overrides that of chaos.
+		{"chaos NightlightDNS 1.0\nversion 1.2.3", "version.bind.", dns.TypeTXT, dns.RcodeSuccess, "1.2.3"},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, testRecords, tc.options)
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		m.Question[0].Qclass = dns.ClassCHAOS
+		resp := exchange(n, m)
+		if resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		if tc.text == "" {
+			if len(resp.Answer) != 0 {
+				t.Errorf("Test %d: expected no answer, got %v", i, resp.Answer)
+			}
+			continue
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("Test %d: expected one answer, got %v", i, resp.Answer)
+		}
+		txt, ok := resp.Answer[0].(*dns.TXT)
+		if !ok || txt.Hdr.Class != dns.ClassCHAOS || len(txt.Txt) != 1 || txt.Txt[0] != tc.text {
+			t.Errorf("Test %d: expected a CHAOS TXT RR with %q, got %s", i, tc.text, resp.Answer[0])
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package nightlightdns
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// consistentHash orders multi-address answers by a hash of the client's address, so a client keeps
+// getting the same first address while different clients are spread evenly over the addresses. The
+// order is found with rendezvous hashing: every address is scored by hashing it together with the
+// client, so adding or removing an address only moves the clients of that address.
+type consistentHash struct {
+	// qname also hashes the query name, so a client's names don't all land on the same address.
+	qname bool
+}
+
+// order reorders the A and AAAA RRs of answers for client, in place. Other RRs keep their position.
+func (c *consistentHash) order(answers []dns.RR, client net.IP, qname string) {
+	if c == nil {
+		return
+	}
+	key := client.String()
+	if c.qname {
+		key += "/" + strings.ToLower(qname)
+	}
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		positions, set := []int{}, []dns.RR{}
+		for i, rr := range answers {
+			if rr.Header().Rrtype == qtype {
+				positions = append(positions, i)
+				set = append(set, rr)
+			}
+		}
+		if len(set) < 2 {
+			continue
+		}
+
+		scores := make(map[dns.RR]uint64, len(set))
+		for _, rr := range set {
+			scores[rr] = score(key, address(rr))
+		}
+		sort.SliceStable(set, func(i, j int) bool { return scores[set[i]] > scores[set[j]] })
+		for i, pos := range positions {
+			answers[pos] = set[i]
+		}
+	}
+}
+
+// score returns the rendezvous hash of ip for key.
+func score(key string, ip net.IP) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write(ip)
+	// FNV alone mixes the last bytes poorly, so finish with a 64 bit mixer.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
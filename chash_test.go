@@ -0,0 +1,83 @@
+package nightlightdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// firstAddressFor returns the first address of the answer to an A query for name from client.
+func firstAddressFor(t *testing.T, n Nightlightdns, name, client string) string {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: client})
+	n.ServeDNS(context.TODO(), rec, m)
+	if len(rec.Msg.Answer) != 3 {
+		t.Fatalf("Expected 3 answers for %s, got %d", name, len(rec.Msg.Answer))
+	}
+	return rec.Msg.Answer[0].(*dns.A).A.String()
+}
+
+func TestSetupSelect(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nselect chash\n}", false},
+		{"nightlightdns {\nselect chash qname\n}", false},
+		{"nightlightdns {\nselect\n}", true},
+		{"nightlightdns {\nselect random\n}", true},
+		{"nightlightdns {\nselect chash client\n}", true},
+	})
+}
+
+func TestConsistentHash(t *testing.T) {
+	n := newRecordsPlugin(t, threeAddresses, "select chash")
+
+	// A client always gets the same first address.
+	for _, client := range []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"} {
+		first := firstAddressFor(t, n, "www.example.com.", client)
+		for i := 0; i < 10; i++ {
+			if got := firstAddressFor(t, n, "www.example.com.", client); got != first {
+				t.Fatalf("Expected %s to keep getting %s first, got %s", client, first, got)
+			}
+		}
+	}
+
+	// Different clients are spread evenly over the addresses.
+	const clients = 3000
+	counts := map[string]int{}
+	for i := 0; i < clients; i++ {
+		counts[firstAddressFor(t, n, "www.example.com.", fmt.Sprintf("10.%d.%d.1", i/256, i%256))]++
+	}
+	for _, address := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		if share := float64(counts[address]) / clients; share < 0.28 || share > 0.38 {
+			t.Errorf("Expected %s first for about a third of the clients, got %.1f%%", address, share*100)
+		}
+	}
+}
+
+func TestConsistentHashQname(t *testing.T) {
+	// With qname the names of a client are spread over the addresses too.
+	c := &consistentHash{qname: true}
+	rrs := func() []dns.RR {
+		return []dns.RR{
+			a("www.example.com.", 30, net.ParseIP("192.0.2.1").To4()),
+			a("www.example.com.", 30, net.ParseIP("192.0.2.2").To4()),
+			a("www.example.com.", 30, net.ParseIP("192.0.2.3").To4()),
+		}
+	}
+	firsts := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		answers := rrs()
+		c.order(answers, net.ParseIP("10.0.0.1"), fmt.Sprintf("www%d.example.com.", i))
+		firsts[answers[0].(*dns.A).A.String()] = true
+	}
+	if len(firsts) != 3 {
+		t.Errorf("Expected the names of one client to get every address first, got %v", firsts)
+	}
+}
@@ -0,0 +1,249 @@
+package nightlightdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultConsulPrefix is the KV prefix the Consul backend reads if none is configured.
+	defaultConsulPrefix = "nightlight/"
+	// consulRefresh is the interval at which the keys are read again without blocking queries, and
+	// the longest wait before retrying after a failure.
+	consulRefresh = 30 * time.Second
+	// consulWait is how long a blocking query waits for a change before Consul answers anyway.
+	consulWait = 5 * time.Minute
+)
+
+// ConsulBackend is a RecordStore that serves records from the Consul KV store. Every key under the
+// prefix is named after a fully qualified name, e.g. "nightlight/www.example.com.", and holds a JSON
+// array of the records of that name, in the records file format without the name field.
+//
+// All keys are read at once and kept in memory, queries never wait for Consul. They're read again
+// periodically, or with watch set whenever they change, using blocking queries. While Consul can't
+// be reached the last data read is served.
+type ConsulBackend struct {
+	builder
+	sync.RWMutex
+
+	url    string
+	prefix string
+	watch  bool
+
+	client  *http.Client
+	timeout time.Duration
+
+	// names holds the records by name, index is the Consul index they were read at.
+	names  map[string][]DNSRecord
+	index  uint64
+	loaded bool
+
+	// cancel stops the goroutine started by start, done waits for it to exit.
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// NewConsulBackend returns a ConsulBackend reading the keys under prefix from the Consul agent at
+// address, e.g. "http://consul:8500". Requests give up after timeout, blocking queries wait for up
+// to consulWait on top of it. Records without a TTL are answered with ttl.
+func NewConsulBackend(address, prefix string, watch bool, timeout time.Duration, ttl uint32) (*ConsulBackend, error) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid Consul backend URL %q", address)
+	}
+	return &ConsulBackend{
+		builder: builder{ttl: ttl, rr: newRotator()},
+		url:     strings.TrimSuffix(address, "/"),
+		prefix:  strings.TrimPrefix(prefix, "/"),
+		watch:   watch,
+		client:  &http.Client{},
+		timeout: timeout,
+		names:   map[string][]DNSRecord{},
+	}, nil
+}
+
+// Lookup implements RecordStore.
+func (c *ConsulBackend) Lookup(name string, qtype uint16) ([]dns.RR, error) {
+	return c.answer(name, qtype, c.records)
+}
+
+// records returns the records of name from the last read of the keys.
+func (c *ConsulBackend) records(name string) ([]DNSRecord, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	c.RLock()
+	defer c.RUnlock()
+	return c.names[name], nil
+}
+
+// Ready reports whether the keys were read at least once.
+func (c *ConsulBackend) Ready() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.loaded
+}
+
+// start reads the keys and keeps reading them until Close is called. Consul being unreachable
+// doesn't stop CoreDNS from starting, reading is retried in the background.
+func (c *ConsulBackend) start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	if err := c.read(ctx, false); err != nil {
+		c.failed(err)
+	}
+
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+		c.refresh(ctx)
+	}()
+	return nil
+}
+
+// refresh reads the keys until ctx is done, waiting consulRefresh between reads unless watching.
+// Failed reads are retried with an increasing delay.
+func (c *ConsulBackend) refresh(ctx context.Context) {
+	delay := time.Duration(0)
+	if !c.watch {
+		delay = consulRefresh
+	}
+	backoff := time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		err := c.read(ctx, c.watch)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.failed(err)
+			delay = backoff
+			if backoff *= 2; backoff > consulRefresh {
+				backoff = consulRefresh
+			}
+			continue
+		}
+		backoff = time.Second
+		delay = 0
+		if !c.watch {
+			delay = consulRefresh
+		}
+	}
+}
+
+// failed records a failed read, the last data read stays in use.
+func (c *ConsulBackend) failed(err error) {
+	log.Warningf("Failed to read records from Consul at %s: %v", c.url, err)
+	backendStale.WithLabelValues("consul").Set(1)
+}
+
+// consulPair is a key of the Consul KV API, the value is decoded from base64.
+type consulPair struct {
+	Key   string
+	Value []byte
+}
+
+// read reads all keys under the prefix and swaps in their records. With blocking set, the request
+// only returns once the keys changed since the last read, or consulWait passed.
+func (c *ConsulBackend) read(ctx context.Context, blocking bool) error {
+	c.RLock()
+	index := c.index
+	c.RUnlock()
+
+	q := url.Values{"recurse": {"true"}}
+	timeout := c.timeout
+	if blocking && index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", consulWait.String())
+		// Consul adds up to a sixteenth of the wait time to spread out the responses.
+		timeout += consulWait + consulWait/16
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/v1/kv/"+c.prefix+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// No keys under the prefix is answered with 404.
+	pairs := []consulPair{}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+			return fmt.Errorf("unable to parse response: %v", err)
+		}
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("unexpected status %q", resp.Status)
+	}
+
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if blocking && next == index {
+		// The wait passed without a change.
+		backendStale.WithLabelValues("consul").Set(0)
+		return nil
+	}
+	if next < index {
+		// The index went backwards, e.g. after a restore from a snapshot. Start over without
+		// blocking on it.
+		next = 0
+	}
+
+	names := make(map[string][]DNSRecord, len(pairs))
+	for _, pair := range pairs {
+		name := strings.TrimPrefix(pair.Key, c.prefix)
+		if name == "" || pair.Value == nil {
+			continue
+		}
+		name = qualify(name, ".")
+		list := []DNSRecord{}
+		if err := json.Unmarshal(pair.Value, &list); err != nil {
+			log.Warningf("Skipping invalid value of Consul key %s: %v", pair.Key, err)
+			continue
+		}
+		for i := range list {
+			list[i].Name = name
+		}
+		records := qualifyRecords(DNSRecords{Origin: ".", Records: list}, "Consul key "+pair.Key)
+		names[name] = append(names[name], records...)
+	}
+
+	c.Lock()
+	c.names = names
+	c.index = next
+	c.loaded = true
+	c.Unlock()
+
+	backendStale.WithLabelValues("consul").Set(0)
+	log.Debugf("Loaded %d names from Consul at %s", len(names), c.url)
+	return nil
+}
+
+// Close stops reading the keys.
+func (c *ConsulBackend) Close() error {
+	if c.cancel == nil {
+		return nil
+	}
+	c.cancel()
+	c.done.Wait()
+	return nil
+}
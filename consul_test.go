@@ -0,0 +1,247 @@
+package nightlightdns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// consulServer is a mock of the Consul KV API, serving the keys it holds under /v1/kv/.
+type consulServer struct {
+	sync.Mutex
+	pairs  []consulPair
+	index  uint64
+	down   bool
+	params []string
+}
+
+func (s *consulServer) set(index uint64, kv map[string]string) {
+	s.Lock()
+	defer s.Unlock()
+	s.index = index
+	s.pairs = nil
+	for key, value := range kv {
+		s.pairs = append(s.pairs, consulPair{Key: key, Value: []byte(value)})
+	}
+}
+
+func (s *consulServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.params = append(s.params, r.URL.RawQuery)
+	if s.down {
+		http.Error(w, "No cluster leader", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Path != "/v1/kv/nightlight/" || r.URL.Query().Get("recurse") != "true" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	if len(s.pairs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(s.pairs)
+}
+
+func TestSetupConsulBackend(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nbackend consul http://consul:8500\n}", false},
+		{"nightlightdns {\nbackend consul http://consul:8500 prefix nightlight/\n}", false},
+		{"nightlightdns {\nbackend consul http://consul:8500 prefix nightlight/ watch\n}", false},
+		{"nightlightdns {\nbackend consul http://consul:8500 prefix\n}", true},
+		{"nightlightdns {\nbackend consul http://consul:8500 blocking\n}", true},
+		{"nightlightdns {\nbackend consul\n}", true},
+	})
+}
+
+func TestNewConsulBackend(t *testing.T) {
+	tests := []struct {
+		address   string
+		shouldErr bool
+	}{
+		{"http://consul:8500", false},
+		{"https://consul.example.com/", false},
+		{"consul:8500", true},
+		{"://", true},
+	}
+	for i, tc := range tests {
+		_, err := NewConsulBackend(tc.address, defaultConsulPrefix, false, time.Second, defaultTTL)
+		if tc.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %v for %q, got %v", i, tc.shouldErr, tc.address, err)
+		}
+	}
+}
+
+func TestConsulBackend(t *testing.T) {
+	mock := &consulServer{}
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	c, err := NewConsulBackend(srv.URL, "/nightlight/", false, time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: c}
+
+	tests := []struct {
+		kv    map[string]string
+		down  bool
+		stale float64
+		cases []test.Case
+	}{
+		{
+			kv: map[string]string{
+				"nightlight/www.example.com.": `[{"ipaddress": "192.0.2.1", "ttl": 60}]`,
+				"nightlight/api.example.com":  `[{"ipaddress": "192.0.2.2"}, {"ipv6address": "2001:db8::2"}]`,
+				"nightlight/bad.example.com.": `{"ipaddress"`,
+			},
+			cases: []test.Case{
+				{
+					Qname: "www.example.com.", Qtype: dns.TypeA,
+					Answer: []dns.RR{test.A("www.example.com. 60 IN A 192.0.2.1")},
+				},
+				{
+					Qname: "api.example.com.", Qtype: dns.TypeAAAA,
+					Answer: []dns.RR{test.AAAA("api.example.com. 30 IN AAAA 2001:db8::2")},
+				},
+				// Keys with invalid values are skipped.
+				{
+					Qname: "bad.example.com.", Qtype: dns.TypeA,
+					Rcode: dns.RcodeNameError,
+				},
+			},
+		},
+		{
+			kv: map[string]string{
+				"nightlight/www.example.com.": `[{"ipaddress": "192.0.2.10", "ttl": 60}]`,
+			},
+			cases: []test.Case{
+				{
+					Qname: "www.example.com.", Qtype: dns.TypeA,
+					Answer: []dns.RR{test.A("www.example.com. 60 IN A 192.0.2.10")},
+				},
+				{
+					Qname: "api.example.com.", Qtype: dns.TypeA,
+					Rcode: dns.RcodeNameError,
+				},
+			},
+		},
+		// While Consul is down the last data read is served.
+		{
+			down:  true,
+			stale: 1,
+			cases: []test.Case{
+				{
+					Qname: "www.example.com.", Qtype: dns.TypeA,
+					Answer: []dns.RR{test.A("www.example.com. 60 IN A 192.0.2.10")},
+				},
+			},
+		},
+		// No keys under the prefix at all.
+		{
+			kv: map[string]string{},
+			cases: []test.Case{
+				{
+					Qname: "www.example.com.", Qtype: dns.TypeA,
+					Rcode: dns.RcodeNameError,
+				},
+			},
+		},
+	}
+	for i, tc := range tests {
+		mock.Lock()
+		mock.down = tc.down
+		mock.Unlock()
+		if !tc.down {
+			mock.set(uint64(i+1), tc.kv)
+		}
+		if err := c.read(context.Background(), false); err != nil {
+			if !tc.down {
+				t.Fatalf("Test %d: %v", i, err)
+			}
+			c.failed(err)
+		} else if tc.down {
+			t.Fatalf("Test %d: expected an error reading from Consul, got none", i)
+		}
+		if !c.Ready() {
+			t.Errorf("Test %d: expected the backend to be ready", i)
+		}
+		if got := testutil.ToFloat64(backendStale.WithLabelValues("consul")); got != tc.stale {
+			t.Errorf("Test %d: expected the stale metric %v, got %v", i, tc.stale, got)
+		}
+		checkCases(t, n, tc.cases)
+	}
+}
+
+func TestConsulBackendBlocking(t *testing.T) {
+	mock := &consulServer{}
+	mock.set(7, map[string]string{"nightlight/www.example.com.": `[{"ipaddress": "192.0.2.1"}]`})
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	c, err := NewConsulBackend(srv.URL, defaultConsulPrefix, true, time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first read can't block, there's no index to wait on yet.
+	for i := 0; i < 2; i++ {
+		if err := c.read(context.Background(), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(mock.params) != 2 || mock.params[0] != "recurse=true" || mock.params[1] != "index=7&recurse=true&wait=5m0s" {
+		t.Errorf("Expected a plain read and then a blocking one at index 7, got %q", mock.params)
+	}
+
+	// An index going backwards starts over without blocking.
+	mock.set(3, map[string]string{"nightlight/www.example.com.": `[{"ipaddress": "192.0.2.2"}]`})
+	if err := c.read(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if c.index != 0 {
+		t.Errorf("Expected the index to be reset, got %d", c.index)
+	}
+	rrs, err := c.Lookup("www.example.com.", dns.TypeA)
+	if err != nil || len(rrs) != 1 || rrs[0].(*dns.A).A.String() != "192.0.2.2" {
+		t.Errorf("Expected the records at index 3, got %v, %v", rrs, err)
+	}
+}
+
+func TestConsulBackendClose(t *testing.T) {
+	srv := httptest.NewServer(&consulServer{down: true})
+	defer srv.Close()
+
+	c, err := NewConsulBackend(srv.URL, defaultConsulPrefix, true, time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Consul being down doesn't stop the backend from starting.
+	if err := c.start(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Ready() {
+		t.Error("Expected the backend not to be ready without a successful read")
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Close to stop reading from Consul")
+	}
+}
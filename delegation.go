@@ -0,0 +1,109 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// Delegator is a RecordStore that can delegate subzones to other name servers.
+type Delegator interface {
+	RecordStore
+
+	// Delegation returns the NS RRs of the delegated subzone of zone containing name, and the
+	// address RRs of those name servers that are known, as glue. The NS RRs are empty when name
+	// isn't delegated. The apex of zone is never delegated, its NS RRs are the zone's own.
+	Delegation(zone, name string) (nss, glue []dns.RR)
+}
+
+// Delegation implements Delegator. NS records at the origin of a records file or at zone belong to
+// the zone itself, NS records below both delegate the subzone at their name.
+func (s *JSONStore) Delegation(zone, name string) (nss, glue []dns.RR) {
+	name = strings.ToLower(dns.Fqdn(name))
+	zone = strings.ToLower(dns.Fqdn(zone))
+
+	for _, f := range s.Files {
+		if nss = s.delegation(f, zone, name); len(nss) > 0 {
+			break
+		}
+	}
+	for _, rr := range nss {
+		target := rr.(*dns.NS).Ns
+		records := s.LookupName(target)
+		glue = append(glue, s.addresses(target, dns.TypeA, records)...)
+		glue = append(glue, s.addresses(target, dns.TypeAAAA, records)...)
+	}
+	return nss, glue
+}
+
+// delegation returns the NS RRs of the subzone of f containing name, if it's delegated. The
+// delegation closest to the origin wins, anything below it isn't ours to answer. Only names below
+// both the origin and zone can be delegated, so a file without an origin of its own can't delegate
+// the zone's apex.
+func (s *JSONStore) delegation(f *Recordsfile, zone, name string) []dns.RR {
+	origin := dns.Fqdn(f.Records().Origin)
+
+	cut := ""
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		c := name[off:]
+		if c == origin || c == zone || !dns.IsSubDomain(origin, c) || !dns.IsSubDomain(zone, c) {
+			break
+		}
+		if len(s.nss(c, f.lookupExact(c))) > 0 {
+			cut = c
+		}
+	}
+	if cut == "" {
+		return nil
+	}
+	return s.nss(cut, f.lookupExact(cut))
+}
+
+// referral writes a non-authoritative response pointing the client to the name servers of a
+// delegated subzone. With minimal responses only the glue that can't be resolved elsewhere, of
+// name servers within the subzone itself, is sent.
+func (n Nightlightdns) referral(state request.Request, nss, glue []dns.RR) (int, error) {
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Ns = nss
+	m.Extra = glue
+	if n.MinimalResponses {
+		m.Extra = requiredGlue(nss[0].Header().Name, glue)
+	}
+
+	n.writeMsg(state, m)
+	return dns.RcodeSuccess, nil
+}
+
+// requiredGlue returns the glue of the name servers within zone, resolvers can't find their
+// addresses without it.
+func requiredGlue(zone string, glue []dns.RR) []dns.RR {
+	required := []dns.RR{}
+	for _, rr := range glue {
+		if dns.IsSubDomain(zone, rr.Header().Name) {
+			required = append(required, rr)
+		}
+	}
+	return required
+}
+
+// checkNS returns an error for the first NS record of data, read from path, that isn't within one
+// of zones. Those would neither answer for the zone nor delegate part of it. Without zones any NS
+// record is accepted.
+func checkNS(data DNSRecords, zones []string, path string) error {
+	if len(zones) == 0 {
+		return nil
+	}
+	for _, record := range data.Records {
+		if record.Delete || record.kind() != "NS" {
+			continue
+		}
+		if name := qualify(record.Name, data.Origin); plugin.Zones(zones).Matches(name) == "" {
+			return fmt.Errorf("record %q in %q: NS record is outside of the zones %s", record.Name, path, strings.Join(zones, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package nightlightdns
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+const delegationRecords = `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "sub", "type": "NS", "target": "ns1.sub"},
+    {"name": "sub", "type": "NS", "target": "ns.example.net."},
+    {"name": "ns1.sub", "ipaddress": "192.0.2.53", "ipv6address": "2001:db8::53"},
+    {"name": "ns.example.net.", "ipaddress": "198.51.100.53"}
+  ]
+}`
+
+func TestDelegation(t *testing.T) {
+	nss := []dns.RR{
+		test.NS("sub.example.com. 30 IN NS ns.example.net."),
+		test.NS("sub.example.com. 30 IN NS ns1.sub.example.com."),
+	}
+	tests := []struct {
+		options string
+		tc      test.Case
+	}{
+		{"", test.Case{
+			Qname: "www.sub.example.com.", Qtype: dns.TypeA,
+			Ns: nss,
+			Extra: []dns.RR{
+				test.A("ns.example.net. 30 IN A 198.51.100.53"),
+				test.A("ns1.sub.example.com. 30 IN A 192.0.2.53"),
+				test.AAAA("ns1.sub.example.com. 30 IN AAAA 2001:db8::53"),
+			},
+		}},
+		{"", test.Case{
+			Qname: "sub.example.com.", Qtype: dns.TypeNS,
+			Ns: nss,
+			Extra: []dns.RR{
+				test.A("ns.example.net. 30 IN A 198.51.100.53"),
+				test.A("ns1.sub.example.com. 30 IN A 192.0.2.53"),
+				test.AAAA("ns1.sub.example.com. 30 IN AAAA 2001:db8::53"),
+			},
+		}},
+		// Only the glue of name servers within the subzone is required.
+		{"minimal-responses", test.Case{
+			Qname: "www.sub.example.com.", Qtype: dns.TypeA,
+			Ns: nss,
+			Extra: []dns.RR{
+				test.A("ns1.sub.example.com. 30 IN A 192.0.2.53"),
+				test.AAAA("ns1.sub.example.com. 30 IN AAAA 2001:db8::53"),
+			},
+		}},
+		{"", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		}},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, delegationRecords, tc.options)
+		resp := exchange(n, tc.tc.Msg())
+		if err := test.SortAndCheck(resp, tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+		if referral := len(tc.tc.Ns) > 0; resp.Authoritative == referral {
+			t.Errorf("Test %d: expected authoritative %t, got %t", i, !referral, resp.Authoritative)
+		}
+	}
+}
+
+func TestMinimalResponses(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "sub", "type": "NS", "target": "ns"},
+    {"name": "ns", "ipaddress": "192.0.2.53"}
+  ]
+}`
+	tests := []struct {
+		options string
+		extra   int
+	}{
+		{"", 1},
+		// The name server is outside the subzone, its address can be resolved without glue.
+		{"minimal-responses", 0},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options)
+		m := new(dns.Msg)
+		m.SetQuestion("www.sub.example.com.", dns.TypeA)
+		resp := exchange(n, m)
+		if len(resp.Ns) != 1 {
+			t.Fatalf("Test %d: expected a referral, got %v", i, resp)
+		}
+		if len(resp.Extra) != tc.extra {
+			t.Errorf("Test %d: expected %d additional RRs with %q, got %v", i, tc.extra, tc.options, resp.Extra)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// dns64 synthesizes AAAA RRs from A RRs for clients on IPv6-only networks behind NAT64, following
+// RFC 6147, by embedding the IPv4 addresses into a prefix as described in RFC 6052.
+type dns64 struct {
+	prefix net.IP
+	ones   int
+}
+
+// newDNS64 returns a dns64 for the CIDR prefix, whose length must be one of 32, 40, 48, 56, 64 or 96.
+func newDNS64(prefix string) (*dns64, error) {
+	_, subnet, err := net.ParseCIDR(prefix)
+	if err != nil || subnet.IP.To4() != nil {
+		return nil, fmt.Errorf("invalid dns64 prefix '%s'", prefix)
+	}
+	ones, _ := subnet.Mask.Size()
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("invalid dns64 prefix length %d, expected 32, 40, 48, 56, 64 or 96", ones)
+	}
+	return &dns64{prefix: subnet.IP.To16(), ones: ones}, nil
+}
+
+// synthesize returns rrs with every A RR replaced by an AAAA RR for its embedded address. Other
+// RRs, such as the CNAMEs leading to the addresses, are kept.
+func (d *dns64) synthesize(rrs []dns.RR) []dns.RR {
+	synthesized := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if a, ok := rr.(*dns.A); ok {
+			rr = aaaa(a.Hdr.Name, a.Hdr.Ttl, d.embed(a.A))
+		}
+		synthesized = append(synthesized, rr)
+	}
+	return synthesized
+}
+
+// embed returns the IPv6 address for ip within the prefix. Bits 64 to 71 must be zero, so the
+// address skips them with prefixes shorter than 96 bits.
+func (d *dns64) embed(ip net.IP) net.IP {
+	embedded := make(net.IP, net.IPv6len)
+	copy(embedded, d.prefix)
+	i := d.ones / 8
+	for _, b := range ip.To4() {
+		if i == 8 {
+			i++
+		}
+		embedded[i] = b
+		i++
+	}
+	return embedded
+}
+
+// hasType reports whether rrs hold an RR of type qtype.
+func hasType(rrs []dns.RR, qtype uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package nightlightdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupDNS64(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\ndns64 64:ff9b::/96\n}", false},
+		{"nightlightdns {\ndns64 2001:db8::/32\n}", false},
+		{"nightlightdns {\ndns64\n}", true},
+		{"nightlightdns {\ndns64 64:ff9b::/80\n}", true},
+		{"nightlightdns {\ndns64 10.0.0.0/8\n}", true},
+		{"nightlightdns {\ndns64 64:ff9b::\n}", true},
+	})
+}
+
+// TestEmbed checks the examples of RFC 6052, section 2.4.
+func TestEmbed(t *testing.T) {
+	tests := []struct {
+		prefix, embedded string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::c000:221"},
+		{"64:ff9b::/96", "64:ff9b::c000:221"},
+	}
+	for i, tc := range tests {
+		d, err := newDNS64(tc.prefix)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		if got := d.embed(net.ParseIP("192.0.2.33")); !got.Equal(net.ParseIP(tc.embedded)) {
+			t.Errorf("Test %d: expected %s within %s, got %s", i, tc.embedded, tc.prefix, got)
+		}
+	}
+}
+
+func TestDNS64(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "v4", "ipaddress": "192.0.2.33"},
+    {"name": "both", "ipaddress": "192.0.2.34", "ipv6address": "2001:db8::34"},
+    {"name": "alias", "type": "CNAME", "target": "v4"},
+    {"name": "mail", "type": "MX", "target": "v4", "preference": 10}
+  ]
+}`, "dns64 64:ff9b::/96")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "v4.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("v4.example.com. 30 IN AAAA 64:ff9b::c000:221")},
+		},
+		{
+			Qname: "v4.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("v4.example.com. 30 IN A 192.0.2.33")},
+		},
+		// Names with AAAA records keep them.
+		{
+			Qname: "both.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("both.example.com. 30 IN AAAA 2001:db8::34")},
+		},
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{
+				test.CNAME("alias.example.com. 30 IN CNAME v4.example.com."),
+				test.AAAA("v4.example.com. 30 IN AAAA 64:ff9b::c000:221"),
+			},
+		},
+		// Names without addresses have nothing to synthesize from.
+		{
+			Qname: "mail.example.com.", Qtype: dns.TypeAAAA,
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeAAAA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+}
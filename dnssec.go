@@ -0,0 +1,226 @@
+package nightlightdns
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// signer signs responses for a zone on the fly with a single key, following the dnssec plugin.
+type signer struct {
+	zone   string
+	key    *dns.DNSKEY
+	signer crypto.Signer
+	tag    uint16
+
+	// ttl is the TTL of the DNSKEY and of the NSEC records of negative responses.
+	ttl uint32
+}
+
+// newSigner loads the key pair at base, as written by dnssec-keygen: base.key holds the public key
+// and base.private the private key. The key's owner name is the zone that is signed.
+func newSigner(base string, ttl uint32) (*signer, error) {
+	pub, err := os.Open(base + ".key")
+	if err != nil {
+		return nil, err
+	}
+	defer pub.Close()
+	rr, err := dns.ReadRR(pub, base+".key")
+	if err != nil {
+		return nil, err
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("no public key found in %q", base+".key")
+	}
+
+	priv, err := os.Open(base + ".private")
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Close()
+	p, err := key.ReadPrivateKey(priv, base+".private")
+	if err != nil {
+		return nil, err
+	}
+	s, ok := p.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("no private key found in %q", base+".private")
+	}
+
+	return &signer{
+		zone:   plugin.Name(key.Header().Name).Normalize(),
+		key:    key,
+		signer: s,
+		tag:    key.KeyTag(),
+		ttl:    ttl,
+	}, nil
+}
+
+// dnskey returns the DNSKEY RR of the zone.
+func (s *signer) dnskey() dns.RR {
+	key := dns.Copy(s.key)
+	key.Header().Name = s.zone
+	key.Header().Ttl = s.ttl
+	return key
+}
+
+// nsec returns the NSEC RR proving that name has no RRsets but those of types. It uses "black lies":
+// the next name is the closest possible one, so NXDOMAIN responses become NODATA ones and nothing
+// about the rest of the zone is revealed. The bitmap holds types, and RRSIG and NSEC of the NSEC
+// itself, so it can't be used to deny the types that exist.
+func (s *signer) nsec(name string, types []uint16) dns.RR {
+	bitmap := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	for _, t := range types {
+		if t != dns.TypeRRSIG && t != dns.TypeNSEC {
+			bitmap = append(bitmap, t)
+		}
+	}
+	sort.Slice(bitmap, func(i, j int) bool { return bitmap[i] < bitmap[j] })
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: s.ttl},
+		NextDomain: "\\000." + name,
+		TypeBitMap: bitmap,
+	}
+}
+
+// typesAt returns the types of the RRsets at qname other than qtype, for the NSEC denying qtype.
+// NS is only listed next to the SOA of the zone's apex: on its own it marks a delegation, and a
+// validator would take the zone to end at qname.
+func (n Nightlightdns) typesAt(qname string, qtype uint16) []uint16 {
+	name := n.rewrites.apply(qname)
+	exists := map[uint16]bool{}
+	add := func(rrs []dns.RR) {
+		for _, rr := range rrs {
+			if strings.EqualFold(rr.Header().Name, name) {
+				exists[rr.Header().Rrtype] = true
+			}
+		}
+	}
+
+	if n.blocklist != nil && n.blocklist.blocked(qname) {
+		if n.blocklist.sinkhole != nil {
+			add(n.blocklist.sinkhole.answer(name, dns.TypeA))
+			add(n.blocklist.sinkhole.answer(name, dns.TypeAAAA))
+		}
+	} else {
+		rrs, err := n.Store.Lookup(name, dns.TypeANY)
+		add(rrs)
+		if dnsutil.IsReverse(name) > 0 {
+			ptrs, _ := n.Store.Lookup(name, dns.TypePTR)
+			add(ptrs)
+		}
+		if err == ErrNoSuchName && n.catchAll != nil {
+			add(n.catchAll.answer(name, dns.TypeA))
+			add(n.catchAll.answer(name, dns.TypeAAAA))
+		}
+	}
+	if n.soa(qname) != nil {
+		exists[dns.TypeSOA] = true
+	}
+	if n.signer != nil && qname == n.signer.zone {
+		exists[dns.TypeDNSKEY] = true
+	}
+	if n.dns64 != nil && exists[dns.TypeA] {
+		exists[dns.TypeAAAA] = true
+	}
+	if !exists[dns.TypeSOA] {
+		delete(exists, dns.TypeNS)
+	}
+	delete(exists, qtype)
+
+	types := make([]uint16, 0, len(exists))
+	for t := range exists {
+		types = append(types, t)
+	}
+	return types
+}
+
+// sign returns rrs with an RRSIG added after every RRset of the zone. RRsets outside of it, such as
+// those of a CNAME target resolved upstream, aren't the zone's to sign.
+func (s *signer) sign(rrs []dns.RR) ([]dns.RR, error) {
+	now := time.Now().UTC()
+	inception := uint32(now.Add(-3 * time.Hour).Unix())
+	expiration := uint32(now.Add(8 * 24 * time.Hour).Unix())
+
+	signed := make([]dns.RR, 0, 2*len(rrs))
+	for _, rrset := range rrsets(rrs) {
+		signed = append(signed, rrset...)
+		hdr := rrset[0].Header()
+		if hdr.Rrtype == dns.TypeRRSIG || !dns.IsSubDomain(s.zone, hdr.Name) {
+			continue
+		}
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: hdr.Ttl},
+			Algorithm:  s.key.Algorithm,
+			KeyTag:     s.tag,
+			SignerName: s.zone,
+			Inception:  inception,
+			Expiration: expiration,
+		}
+		if err := sig.Sign(s.signer, rrset); err != nil {
+			return nil, err
+		}
+		signed = append(signed, sig)
+	}
+	return signed, nil
+}
+
+// rrsets groups rrs by owner name and type, keeping the order in which they first appear.
+func rrsets(rrs []dns.RR) [][]dns.RR {
+	sets := [][]dns.RR{}
+	seen := make(map[string]int)
+	for _, rr := range rrs {
+		key := rr.Header().Name + "/" + dns.TypeToString[rr.Header().Rrtype]
+		i, ok := seen[key]
+		if !ok {
+			i = len(sets)
+			seen[key] = i
+			sets = append(sets, nil)
+		}
+		sets[i] = append(sets[i], rr)
+	}
+	return sets
+}
+
+// signMsg signs m, the response to state, if DNSSEC is enabled for the query's zone and the client
+// set the DO bit. Negative responses get an NSEC record and are turned into NODATA responses.
+func (n Nightlightdns) signMsg(state request.Request, m *dns.Msg) error {
+	s := n.signer
+	if s == nil || !state.Do() || !dns.IsSubDomain(s.zone, state.Name()) {
+		return nil
+	}
+
+	if len(m.Answer) == 0 && (m.Rcode == dns.RcodeSuccess || m.Rcode == dns.RcodeNameError) {
+		var types []uint16
+		if m.Rcode == dns.RcodeSuccess {
+			types = n.typesAt(state.Name(), state.QType())
+		}
+		m.Ns = append(m.Ns, s.nsec(state.Name(), types))
+		m.Rcode = dns.RcodeSuccess
+	}
+
+	var err error
+	if m.Answer, err = s.sign(m.Answer); err != nil {
+		return fmt.Errorf("%w: %v", errSigning, err)
+	}
+	if m.Ns, err = s.sign(m.Ns); err != nil {
+		return fmt.Errorf("%w: %v", errSigning, err)
+	}
+
+	if opt := m.IsEdns0(); opt != nil {
+		opt.SetDo()
+	} else {
+		m.SetEdns0(uint16(state.Size()), true)
+	}
+	return nil
+}
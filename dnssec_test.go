@@ -0,0 +1,132 @@
+package nightlightdns
+
+import (
+	"crypto"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// writeKey writes a new key pair for zone to dir, as dnssec-keygen would, and returns the path of
+// the files without extension and the public key.
+func writeKey(t *testing.T, dir, zone string) (string, *dns.DNSKEY) {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, "Kexample.com.+013+00001")
+	writeFile(t, dir, filepath.Base(base)+".key", key.String()+"\n")
+	writeFile(t, dir, filepath.Base(base)+".private", key.PrivateKeyString(priv.(crypto.PrivateKey)))
+	return base, key
+}
+
+// verify checks the RRSIGs in rrs against key, and that every RRset in rrs has one.
+func verify(t *testing.T, key *dns.DNSKEY, rrs []dns.RR) {
+	t.Helper()
+	sets := map[uint16][]dns.RR{}
+	sigs := map[uint16]*dns.RRSIG{}
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs[sig.TypeCovered] = sig
+			continue
+		}
+		sets[rr.Header().Rrtype] = append(sets[rr.Header().Rrtype], rr)
+	}
+	for qtype, rrset := range sets {
+		sig, ok := sigs[qtype]
+		if !ok {
+			t.Errorf("Expected an RRSIG for the %s RRset", dns.TypeToString[qtype])
+			continue
+		}
+		if err := sig.Verify(key, rrset); err != nil {
+			t.Errorf("Expected the RRSIG of the %s RRset to verify, got: %v", dns.TypeToString[qtype], err)
+		}
+		if !sig.ValidityPeriod(time.Now()) {
+			t.Errorf("Expected the RRSIG of the %s RRset to be valid now", dns.TypeToString[qtype])
+		}
+	}
+}
+
+func TestSetupDNSSEC(t *testing.T) {
+	base, _ := writeKey(t, t.TempDir(), "example.com.")
+	testParse(t, []parseTest{
+		{"nightlightdns example.com {\ndnssec keyfile " + base + "\n}", false},
+		{"nightlightdns example.com {\ndnssec keyfile\n}", true},
+		{"nightlightdns example.com {\ndnssec " + base + "\n}", true},
+		{"nightlightdns example.com {\ndnssec keyfile " + base + ".missing\n}", true},
+		// Zone file answers are served before signing, so the two don't mix.
+		{"nightlightdns example.com {\nzonefile db.example.com\ndnssec keyfile " + base + "\n}", true},
+	})
+}
+
+func TestDNSSEC(t *testing.T) {
+	base, key := writeKey(t, t.TempDir(), "example.com.")
+	n := newRecordsPlugin(t, testRecords, "dnssec keyfile "+base)
+
+	tests := []struct {
+		qname  string
+		qtype  uint16
+		do     bool
+		answer []uint16 // the types in the answer section, including RRSIG
+		ns     []uint16 // the types in the authority section, including RRSIG
+	}{
+		{"www.example.com.", dns.TypeA, true, []uint16{dns.TypeA, dns.TypeRRSIG}, nil},
+		{"www.example.com.", dns.TypeA, false, []uint16{dns.TypeA}, nil},
+		{"example.com.", dns.TypeDNSKEY, true, []uint16{dns.TypeDNSKEY, dns.TypeRRSIG}, nil},
+		// Negative answers are NODATA with a signed NSEC.
+		{"www.example.com.", dns.TypeTXT, true, nil, []uint16{dns.TypeNSEC, dns.TypeRRSIG}},
+		{"nope.example.com.", dns.TypeA, true, nil, []uint16{dns.TypeNSEC, dns.TypeRRSIG}},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		m.SetEdns0(4096, tc.do)
+		resp := exchange(n, m)
+
+		if resp.Rcode != dns.RcodeSuccess {
+			t.Errorf("Test %d: expected NOERROR, got %s", i, dns.RcodeToString[resp.Rcode])
+		}
+		for _, section := range []struct {
+			rrs   []dns.RR
+			types []uint16
+		}{{resp.Answer, tc.answer}, {resp.Ns, tc.ns}} {
+			if len(section.rrs) != len(section.types) {
+				t.Errorf("Test %d: expected %d RRs, got %v", i, len(section.types), section.rrs)
+				continue
+			}
+			for j, rr := range section.rrs {
+				if rr.Header().Rrtype != section.types[j] {
+					t.Errorf("Test %d: expected %s, got %s", i, dns.TypeToString[section.types[j]], rr)
+				}
+			}
+			if tc.do {
+				verify(t, key, section.rrs)
+			}
+		}
+		if opt := resp.IsEdns0(); opt == nil || opt.Do() != tc.do {
+			t.Errorf("Test %d: expected the DO bit %t in the response", i, tc.do)
+		}
+	}
+
+	// The NSEC denies the type asked for, and lists those that exist.
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeTXT)
+	m.SetEdns0(4096, true)
+	nsec := exchange(n, m).Ns[0].(*dns.NSEC)
+	types := map[uint16]bool{}
+	for _, qtype := range nsec.TypeBitMap {
+		types[qtype] = true
+	}
+	if types[dns.TypeTXT] || !types[dns.TypeA] || !types[dns.TypeAAAA] {
+		t.Errorf("Expected the NSEC to list A and AAAA but not TXT, got %v", nsec)
+	}
+}
@@ -0,0 +1,31 @@
+package nightlightdns
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errSigning is wrapped by the errors of signing a response.
+var errSigning = errors.New("signing failed")
+
+// extendedError returns the Extended DNS Error, as defined in RFC 8914, telling the client why err
+// resulted in SERVFAIL. The error itself isn't sent, it may reveal more about the backend than the
+// client should know.
+func extendedError(err error) *dns.EDNS0_EDE {
+	var netErr net.Error
+	switch code := status.Code(err); {
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded),
+		code == codes.Unavailable, code == codes.DeadlineExceeded:
+		return &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeNetworkError, ExtraText: "backend unreachable"}
+	case errors.Is(err, errCNAMELoop), errors.Is(err, errCNAMEDepth):
+		return &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeOther, ExtraText: err.Error()}
+	case errors.Is(err, errSigning):
+		return &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeOther, ExtraText: errSigning.Error()}
+	}
+	return &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeOther, ExtraText: "backend failure"}
+}
@@ -0,0 +1,91 @@
+package nightlightdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExtendedError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code uint16
+		text string
+	}{
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, dns.ExtendedErrorCodeNetworkError, "backend unreachable"},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), dns.ExtendedErrorCodeNetworkError, "backend unreachable"},
+		{status.Error(codes.Unavailable, "no connection"), dns.ExtendedErrorCodeNetworkError, "backend unreachable"},
+		{status.Error(codes.DeadlineExceeded, "too slow"), dns.ExtendedErrorCodeNetworkError, "backend unreachable"},
+		{errCNAMELoop, dns.ExtendedErrorCodeOther, "CNAME loop"},
+		{fmt.Errorf("%w: no key for example.com.", errSigning), dns.ExtendedErrorCodeOther, "signing failed"},
+		// Other errors aren't revealed.
+		{errors.New("password authentication failed for user admin"), dns.ExtendedErrorCodeOther, "backend failure"},
+	}
+	for i, tc := range tests {
+		ede := extendedError(tc.err)
+		if ede.InfoCode != tc.code || ede.ExtraText != tc.text {
+			t.Errorf("Test %d: expected EDE %d %q, got %d %q", i, tc.code, tc.text, ede.InfoCode, ede.ExtraText)
+		}
+	}
+}
+
+func TestSetupEDE(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nede\n}", false},
+		{"nightlightdns {\nede on\n}", true},
+	})
+}
+
+func TestEDE(t *testing.T) {
+	tests := []struct {
+		ede   bool
+		edns  bool
+		err   error
+		rcode int
+		// text is the extra text of the expected EDE option, none if empty.
+		text string
+	}{
+		{true, true, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, dns.RcodeServerFailure, "backend unreachable"},
+		{true, true, errors.New("disk full"), dns.RcodeServerFailure, "backend failure"},
+		// Without the ede directive, or EDNS, there's no EDE.
+		{false, true, errors.New("disk full"), dns.RcodeServerFailure, ""},
+		{true, false, errors.New("disk full"), dns.RcodeServerFailure, ""},
+		// Other responses don't get one either.
+		{true, true, nil, dns.RcodeNameError, ""},
+	}
+	for i, tc := range tests {
+		s := newMockStore()
+		s.err = tc.err
+		n := Nightlightdns{Zones: []string{"example.com."}, Store: s, EDE: tc.ede}
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		if tc.edns {
+			m.SetEdns0(1232, false)
+		}
+		resp := exchange(n, m)
+		if resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+		}
+
+		var ede *dns.EDNS0_EDE
+		if opt := resp.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_EDE); ok {
+					ede = e
+				}
+			}
+		}
+		switch {
+		case tc.text == "" && ede != nil:
+			t.Errorf("Test %d: expected no EDE, got %d %q", i, ede.InfoCode, ede.ExtraText)
+		case tc.text != "" && (ede == nil || ede.ExtraText != tc.text):
+			t.Errorf("Test %d: expected an EDE with %q, got %v", i, tc.text, ede)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package nightlightdns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultExportPath is the path the records are exported at if the export address doesn't have one.
+const defaultExportPath = "/records"
+
+// export serves the loaded records read-only over HTTP, so other instances can pick them up:
+//
+//	GET /records                      the records, in the format of a JSON records file
+//	GET /records?name={name}&type=... the records of name, as the HTTP backend requests them
+//
+// Responses carry an ETag and a Last-Modified header derived from the version of the records, so
+// pollers can make conditional requests and only download records that changed.
+type export struct {
+	addr string
+	path string
+
+	file *Recordsfile
+
+	ln  net.Listener
+	srv *http.Server
+}
+
+// newExport returns an export listening on address, which is a host and port optionally followed
+// by the path to serve the records at, e.g. ":9154/records".
+func newExport(address string) (*export, error) {
+	e := &export{addr: address, path: defaultExportPath}
+	if i := strings.Index(address, "/"); i >= 0 {
+		e.addr, e.path = address[:i], address[i:]
+	}
+	if _, _, err := net.SplitHostPort(e.addr); err != nil {
+		return nil, fmt.Errorf("invalid export address '%s': %v", address, err)
+	}
+	return e, nil
+}
+
+// Startup starts listening.
+func (e *export) Startup() error {
+	ln, err := net.Listen("tcp", e.addr)
+	if err != nil {
+		return err
+	}
+	e.ln = ln
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(e.path, e.handleRecords)
+	e.srv = &http.Server{Handler: mux}
+
+	go func() { e.srv.Serve(ln) }()
+	return nil
+}
+
+// Shutdown stops the export.
+func (e *export) Shutdown() error {
+	if e.srv == nil {
+		return nil
+	}
+	return e.srv.Close()
+}
+
+// handleRecords serves the records, or those of a single name.
+func (e *export) handleRecords(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, version, modified := e.file.snapshot()
+	if name := r.URL.Query().Get("name"); name != "" {
+		data = DNSRecords{Origin: data.Origin, Records: e.file.LookupName(name)}
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// ServeContent answers conditional requests, comparing the ETag and the modification time.
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, modified.UnixNano(), version))
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
@@ -0,0 +1,104 @@
+package nightlightdns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupExport(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nexport :9154\n}", false},
+		{"nightlightdns {\nexport :9154/records\n}", false},
+		{"nightlightdns {\nexport\n}", true},
+		{"nightlightdns {\nexport localhost/records\n}", true},
+	})
+}
+
+func TestExport(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", testRecords)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com {\nexport 127.0.0.1:0/export\n}")
+	if err := n.export.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.export.Shutdown()
+	url := "http://" + n.export.ln.Addr().String() + "/export"
+
+	get := func(method, query string, header http.Header) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, url+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("GET", "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var data DNSRecords
+	if err := json.Unmarshal(body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Records) != 3 || data.Origin != "example.com." {
+		t.Errorf("Expected the 3 records of example.com., got %+v", data)
+	}
+	etag, modified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("Expected an ETag and a Last-Modified header, got %v", resp.Header)
+	}
+
+	tests := []struct {
+		method, query string
+		header        http.Header
+		status        int
+	}{
+		{"GET", "", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"GET", "", http.Header{"If-None-Match": {`"0-0"`}}, http.StatusOK},
+		{"GET", "", http.Header{"If-Modified-Since": {modified}}, http.StatusNotModified},
+		{"HEAD", "", nil, http.StatusOK},
+		{"GET", "?name=www.example.com.", nil, http.StatusOK},
+		{"POST", "", nil, http.StatusMethodNotAllowed},
+	}
+	for i, tc := range tests {
+		if resp, _ := get(tc.method, tc.query, tc.header); resp.StatusCode != tc.status {
+			t.Errorf("Test %d: expected status %d, got %d", i, tc.status, resp.StatusCode)
+		}
+	}
+
+	// A name's records are served as the HTTP backend requests them.
+	h, err := NewHTTPBackend(url, time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCases(t, Nightlightdns{Zones: []string{"example.com."}, Store: h}, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+	})
+
+	// Changed records get a new ETag.
+	f := n.Store.(*JSONStore).Files[0]
+	f.setRecords(DNSRecords{Origin: "example.com.", Records: []DNSRecord{{Name: "new", Ipaddress: "192.0.2.9"}}})
+	resp, _ = get("GET", "", http.Header{"If-None-Match": {etag}})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("Expected changed records to be sent with a new ETag, got status %d and ETag %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
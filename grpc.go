@@ -0,0 +1,113 @@
+package nightlightdns
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"time"
+
+	"github.com/martezr/nightlightdns/pb"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// GRPCBackend is a RecordStore that looks up records with the Lookup RPC of the Records service
+// defined in pb/records.proto. A single connection is shared by all queries.
+type GRPCBackend struct {
+	builder
+
+	conn    *grpc.ClientConn
+	client  pb.RecordsClient
+	timeout time.Duration
+	cache   *recordCache
+}
+
+// NewGRPCBackend returns a GRPCBackend for the service at target, e.g. "host:443", using TLS unless
+// plaintext is set. Lookups give up after timeout. Records without a TTL are answered with ttl.
+func NewGRPCBackend(target string, plaintext bool, timeout time.Duration, ttl uint32) (*GRPCBackend, error) {
+	creds := credentials.NewTLS(&tls.Config{})
+	if plaintext {
+		creds = insecure.NewCredentials()
+	}
+	// Dialing doesn't block, the connection is established and kept up in the background.
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCBackend{
+		builder: builder{ttl: ttl, rr: newRotator()},
+		conn:    conn,
+		client:  pb.NewRecordsClient(conn),
+		timeout: timeout,
+		cache:   newRecordCache("grpc", ttl, backendCacheSize),
+	}, nil
+}
+
+// Lookup implements RecordStore.
+func (g *GRPCBackend) Lookup(name string, qtype uint16) ([]dns.RR, error) {
+	return g.answer(name, qtype, func(name string) ([]DNSRecord, error) {
+		return g.records(name, qtype)
+	})
+}
+
+// records returns the records of name for a query of qtype, from the cache if they were looked up
+// recently.
+func (g *GRPCBackend) records(name string, qtype uint16) ([]DNSRecord, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+	key := name + "/" + dns.TypeToString[qtype]
+	if records, ok := g.cache.get(key); ok {
+		return records, nil
+	}
+
+	records, err := g.lookup(name, qtype)
+	if err != nil {
+		backendFailures.WithLabelValues("grpc").Inc()
+		return nil, err
+	}
+	g.cache.set(key, records)
+	return records, nil
+}
+
+// lookup calls the Lookup RPC for name.
+func (g *GRPCBackend) lookup(name string, qtype uint16) ([]DNSRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+
+	resp, err := g.client.Lookup(ctx, &pb.LookupRequest{Name: name, Type: dns.TypeToString[qtype]})
+	if err != nil {
+		return nil, err
+	}
+
+	data := DNSRecords{}
+	for _, r := range resp.Records {
+		record := DNSRecord{
+			Name:        r.Name,
+			Type:        r.Type,
+			Ipaddress:   r.Ipaddress,
+			Ipv6address: r.Ipv6Address,
+			Target:      r.Target,
+			Preference:  uint16(r.Preference),
+			Priority:    uint16(r.Priority),
+			Weight:      uint16(r.Weight),
+			Text:        r.Text,
+		}
+		if strings.EqualFold(r.Type, "SRV") {
+			port := int64(r.Port)
+			record.Port = &port
+		}
+		if r.Ttl > 0 {
+			ttl := int64(r.Ttl)
+			record.TTL = &ttl
+		}
+		data.Records = append(data.Records, record)
+	}
+	return qualifyRecords(data, "gRPC backend"), nil
+}
+
+// Close closes the connection.
+func (g *GRPCBackend) Close() error {
+	return g.conn.Close()
+}
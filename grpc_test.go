@@ -0,0 +1,131 @@
+package nightlightdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/martezr/nightlightdns/pb"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordsServer is a Records service answering from records, keyed by name. Lookups of "fail."
+// names fail.
+type recordsServer struct {
+	pb.UnimplementedRecordsServer
+	records map[string][]*pb.Record
+}
+
+// Lookup implements pb.RecordsServer.
+func (s *recordsServer) Lookup(ctx context.Context, req *pb.LookupRequest) (*pb.LookupResponse, error) {
+	if req.Name == "fail.example.com." {
+		return nil, status.Error(codes.Unavailable, "backend down")
+	}
+	return &pb.LookupResponse{Records: s.records[req.Name]}, nil
+}
+
+// startRecordsServer starts serving s on a local port and returns its address. The server is
+// stopped when the test ends.
+func startRecordsServer(t *testing.T, s pb.RecordsServer) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	pb.RegisterRecordsServer(srv, s)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	return ln.Addr().String()
+}
+
+func TestGRPCBackend(t *testing.T) {
+	addr := startRecordsServer(t, &recordsServer{records: map[string][]*pb.Record{
+		"www.example.com.": {
+			{Name: "www.example.com.", Ipaddress: "192.0.2.1", Ttl: 60},
+			{Name: "www.example.com.", Ipv6Address: "2001:db8::1"},
+		},
+		"_http._tcp.example.com.": {
+			{Name: "_http._tcp.example.com.", Type: "SRV", Target: "www.example.com.", Priority: 10, Weight: 5, Port: 8080},
+		},
+	}})
+	g, err := NewGRPCBackend(addr, true, time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: g}
+	failures := backendFailures.WithLabelValues("grpc")
+	before := testutil.ToFloat64(failures)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 60 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("www.example.com. 30 IN AAAA 2001:db8::1")},
+		},
+		{
+			Qname: "_http._tcp.example.com.", Qtype: dns.TypeSRV,
+			Answer: []dns.RR{test.SRV("_http._tcp.example.com. 30 IN SRV 10 5 8080 www.example.com.")},
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			Qname: "fail.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		},
+	})
+	if got := testutil.ToFloat64(failures) - before; got != 1 {
+		t.Errorf("Expected 1 backend failure, got %v", got)
+	}
+}
+
+func TestGRPCBackendTimeout(t *testing.T) {
+	// Nothing listens at the address, the lookup fails within the timeout.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	g, err := NewGRPCBackend(addr, true, 100*time.Millisecond, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	start := time.Now()
+	if _, err := g.Lookup("www.example.com.", dns.TypeA); err == nil {
+		t.Error("Expected an error looking up from an unreachable service")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected the lookup to give up after the timeout, took %s", d)
+	}
+}
+
+func TestSetupGRPCBackend(t *testing.T) {
+	addr := startRecordsServer(t, &recordsServer{records: map[string][]*pb.Record{
+		"www.example.com.": {{Name: "www.example.com.", Ipaddress: "192.0.2.1"}},
+	}})
+	n := newTestPlugin(t, "nightlightdns example.com {\nbackend grpc "+addr+" insecure\n}")
+	defer n.Store.(*GRPCBackend).Close()
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+	})
+}
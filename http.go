@@ -0,0 +1,93 @@
+package nightlightdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// HTTPBackend is a RecordStore that fetches records from a REST endpoint. The endpoint is queried
+// with a GET request carrying the name and type query parameters, and must answer with the records
+// of the name in the same JSON format as the records file.
+type HTTPBackend struct {
+	builder
+
+	url    string
+	client *http.Client
+	cache  *recordCache
+}
+
+// NewHTTPBackend returns an HTTPBackend querying endpoint, giving up on requests after timeout.
+// Records without a TTL are answered with ttl.
+func NewHTTPBackend(endpoint string, timeout time.Duration, ttl uint32) (*HTTPBackend, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid HTTP backend URL %q", endpoint)
+	}
+	return &HTTPBackend{
+		builder: builder{ttl: ttl, rr: newRotator()},
+		url:     endpoint,
+		client:  &http.Client{Timeout: timeout},
+		cache:   newRecordCache("http", ttl, backendCacheSize),
+	}, nil
+}
+
+// Lookup implements RecordStore.
+func (h *HTTPBackend) Lookup(name string, qtype uint16) ([]dns.RR, error) {
+	return h.answer(name, qtype, func(name string) ([]DNSRecord, error) {
+		return h.records(name, qtype)
+	})
+}
+
+// records returns the records of name for a query of qtype, from the cache if they were fetched
+// recently.
+func (h *HTTPBackend) records(name string, qtype uint16) ([]DNSRecord, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+	key := name + "/" + dns.TypeToString[qtype]
+	if records, ok := h.cache.get(key); ok {
+		return records, nil
+	}
+
+	records, err := h.fetch(name, qtype)
+	if err != nil {
+		backendFailures.WithLabelValues("http").Inc()
+		return nil, err
+	}
+	h.cache.set(key, records)
+	return records, nil
+}
+
+// fetch requests the records of name from the endpoint.
+func (h *HTTPBackend) fetch(name string, qtype uint16) ([]DNSRecord, error) {
+	u, err := url.Parse(h.url)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("name", name)
+	q.Set("type", dns.TypeToString[qtype])
+	u.RawQuery = q.Encode()
+
+	resp, err := h.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %q from %s", resp.Status, h.url)
+	}
+
+	data := DNSRecords{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("unable to parse response from %s: %v", h.url, err)
+	}
+	return qualifyRecords(data, "HTTP backend"), nil
+}
@@ -0,0 +1,88 @@
+package nightlightdns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestHTTPBackend(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("name") {
+		case "www.example.com.":
+			w.Write([]byte(`{"records": [{"name": "www.example.com.", "ipaddress": "192.0.2.1", "ttl": 60}]}`))
+		case "fail.example.com.":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		case "garbage.example.com.":
+			w.Write([]byte(`{"records": [`))
+		default:
+			w.Write([]byte(`{"records": []}`))
+		}
+	}))
+	defer srv.Close()
+
+	h, err := NewHTTPBackend(srv.URL+"/records", time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: h}
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 60 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			Qname: "fail.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		},
+		{
+			Qname: "garbage.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		},
+	})
+}
+
+func TestHTTPBackendRequest(t *testing.T) {
+	var name, qtype string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, qtype = r.URL.Query().Get("name"), r.URL.Query().Get("type")
+		w.Write([]byte(`{"records": []}`))
+	}))
+	defer srv.Close()
+
+	h, err := NewHTTPBackend(srv.URL, time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Lookup("WWW.Example.com", dns.TypeAAAA)
+	if name != "www.example.com." || qtype != "AAAA" {
+		t.Errorf("Expected a request for www.example.com. AAAA, got %q %q", name, qtype)
+	}
+}
+
+func TestNewHTTPBackend(t *testing.T) {
+	tests := []struct {
+		url       string
+		shouldErr bool
+	}{
+		{"http://localhost:8080/records", false},
+		{"https://records.example.com", false},
+		{"ftp://records.example.com", true},
+		{"://", true},
+	}
+	for i, tc := range tests {
+		_, err := NewHTTPBackend(tc.url, time.Second, defaultTTL)
+		if tc.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t for %q, got: %v", i, tc.shouldErr, tc.url, err)
+		}
+	}
+}
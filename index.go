@@ -0,0 +1,82 @@
+package nightlightdns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// index holds the lookup tables built from the records file.
+type index struct {
+	// names maps lowercased, fully qualified names to their records. The records carry that
+	// qualified name.
+	names map[string][]DNSRecord
+
+	// nodes holds every name that exists in the tree formed by the records: the record names and all
+	// of their ancestors.
+	nodes map[string]bool
+
+	// addrs maps the string form of every address to the names that have it, for reverse lookups.
+	addrs map[string][]string
+}
+
+// newIndex builds the lookup tables for data.
+func newIndex(data DNSRecords) *index {
+	idx := &index{
+		names: make(map[string][]DNSRecord, len(data.Records)),
+		nodes: make(map[string]bool),
+		addrs: make(map[string][]string),
+	}
+	for _, record := range data.Records {
+		if record.Disabled {
+			continue
+		}
+		record.Name = qualify(record.Name, data.Origin)
+		if record.Target != "" {
+			record.Target = qualify(record.Target, data.Origin)
+		}
+		idx.names[record.Name] = append(idx.names[record.Name], record)
+		for off, end := 0, false; !end; off, end = dns.NextLabel(record.Name, off) {
+			idx.nodes[record.Name[off:]] = true
+		}
+
+		if !record.isAddress() {
+			continue
+		}
+		for _, addr := range append(splitAddresses(record.Ipaddress), splitAddresses(record.Ipv6address)...) {
+			if ip := net.ParseIP(addr); ip != nil {
+				idx.addrs[ip.String()] = appendUnique(idx.addrs[ip.String()], record.Name)
+			}
+		}
+	}
+	return idx
+}
+
+// lookup returns the records of name. If name doesn't exist, the wildcard at its closest encloser is
+// used, following RFC 4592: a wildcard only applies when no closer name exists, so an exact match, or
+// any existing name in between, always wins. An empty non-terminal exists too, it has no records.
+func (idx *index) lookup(name string) []DNSRecord {
+	if records, ok := idx.names[name]; ok {
+		return records
+	}
+	if idx.nodes[name] {
+		return nil
+	}
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		encloser := name[off:]
+		if idx.nodes[encloser] {
+			return idx.names["*."+encloser]
+		}
+	}
+	return nil
+}
+
+// appendUnique appends s to list unless it's already present.
+func appendUnique(list []string, s string) []string {
+	for _, l := range list {
+		if l == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,142 @@
+package nightlightdns
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// benchData returns the records of a file with n address records.
+func benchData(b *testing.B, n int) DNSRecords {
+	var data DNSRecords
+	if err := json.Unmarshal([]byte(benchRecords(n)), &data); err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// BenchmarkIndexLookup looks up a name of a file with 10,000 records in its index.
+func BenchmarkIndexLookup(b *testing.B) {
+	idx := newIndex(benchData(b, 10000))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(idx.lookup("www9999.example.com.")) == 0 {
+			b.Fatal("Expected records for www9999.example.com.")
+		}
+	}
+}
+
+// BenchmarkLinearLookup looks up the same name by scanning the records, as before the index.
+func BenchmarkLinearLookup(b *testing.B) {
+	data := benchData(b, 10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var found []DNSRecord
+		for _, record := range data.Records {
+			if strings.EqualFold(qualify(record.Name, data.Origin), "www9999.example.com.") {
+				found = append(found, record)
+			}
+		}
+		if len(found) == 0 {
+			b.Fatal("Expected records for www9999.example.com.")
+		}
+	}
+}
+
+func TestIndexWildcard(t *testing.T) {
+	idx := newIndex(DNSRecords{
+		Origin: "example.com.",
+		Records: []DNSRecord{
+			{Name: "*", Ipaddress: "192.0.2.1"},
+			{Name: "www", Ipaddress: "192.0.2.2"},
+			{Name: "host.sub", Ipaddress: "192.0.2.3"},
+		},
+	})
+	tests := []struct {
+		name    string
+		address string // Empty if name has no records.
+	}{
+		{"www.example.com.", "192.0.2.2"},
+		{"other.example.com.", "192.0.2.1"},
+		{"a.b.c.example.com.", "192.0.2.1"},
+		{"host.sub.example.com.", "192.0.2.3"},
+		// sub exists as an empty non-terminal, the wildcard doesn't apply to it or below it.
+		{"sub.example.com.", ""},
+		{"other.sub.example.com.", ""},
+		{"example.com.", ""},
+	}
+	for i, tc := range tests {
+		records := idx.lookup(tc.name)
+		switch {
+		case tc.address == "" && len(records) > 0:
+			t.Errorf("Test %d: expected no records for %s, got %v", i, tc.name, records)
+		case tc.address != "" && (len(records) != 1 || records[0].Ipaddress != tc.address):
+			t.Errorf("Test %d: expected %s for %s, got %v", i, tc.address, tc.name, records)
+		}
+	}
+}
+
+func TestEmptyNonTerminal(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "a.b", "ipaddress": "192.0.2.1"},
+    {"name": "x.y.z", "type": "TXT", "text": "deep"},
+    {"name": "off.gone", "ipaddress": "192.0.2.2", "disabled": true}
+  ]
+}`, testSOA)
+	soa := test.SOA("example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 2021010101 7200 3600 1209600 300")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "a.b.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("a.b.example.com. 30 IN A 192.0.2.1")},
+		},
+		// The ancestors of a record exist, with no data of any type.
+		{
+			Qname: "b.example.com.", Qtype: dns.TypeA,
+			Ns: []dns.RR{soa},
+		},
+		{
+			Qname: "B.example.com.", Qtype: dns.TypeTXT,
+			Ns: []dns.RR{soa},
+		},
+		{
+			Qname: "y.z.example.com.", Qtype: dns.TypeA,
+			Ns: []dns.RR{soa},
+		},
+		{
+			Qname: "z.example.com.", Qtype: dns.TypeANY,
+			Ns: []dns.RR{soa},
+		},
+		// Siblings and names below records don't.
+		{
+			Qname: "c.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{soa},
+		},
+		{
+			Qname: "x.b.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{soa},
+		},
+		{
+			Qname: "below.a.b.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{soa},
+		},
+		// Disabled records don't make their ancestors exist.
+		{
+			Qname: "gone.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{soa},
+		},
+	})
+}
@@ -0,0 +1,41 @@
+package nightlightdns
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// jitter adds a random number of seconds to the TTLs of answers, so clients that cached them at the
+// same time don't all come back at once.
+type jitter struct {
+	sync.Mutex
+	max uint32
+
+	// rnd draws the number of seconds added, under the lock as a rand.Rand isn't safe for
+	// concurrent use.
+	rnd *rand.Rand
+}
+
+// newJitter returns a jitter of up to max seconds.
+func newJitter(max uint32) *jitter {
+	return &jitter{max: max, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// apply adds a random value between 0 and max to the TTL of every RR in rrs. All of them get the
+// same value, so the RRs of an RRset keep sharing their TTL.
+func (j *jitter) apply(rrs []dns.RR) {
+	if j == nil || j.max == 0 || len(rrs) == 0 {
+		return
+	}
+
+	j.Lock()
+	d := uint32(j.rnd.Int63n(int64(j.max) + 1))
+	j.Unlock()
+
+	for _, rr := range rrs {
+		rr.Header().Ttl += d
+	}
+}
@@ -0,0 +1,56 @@
+package nightlightdns
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupTTLJitter(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nttl-jitter 10\n}", false},
+		{"nightlightdns {\nttl-jitter 0\n}", false},
+		{"nightlightdns {\nttl-jitter\n}", true},
+		{"nightlightdns {\nttl-jitter -1\n}", true},
+		{"nightlightdns {\nttl-jitter 10s\n}", true},
+	})
+}
+
+func TestTTLJitter(t *testing.T) {
+	tests := []struct {
+		options  string
+		min, max uint32
+	}{
+		{"", 30, 30},
+		{"ttl-jitter 0", 30, 30},
+		{"ttl-jitter 10", 30, 40},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, threeAddresses, tc.options)
+		if n.jitter != nil {
+			n.jitter.rnd = rand.New(rand.NewSource(1))
+		}
+
+		seen := map[uint32]bool{}
+		for q := 0; q < 200; q++ {
+			m := new(dns.Msg)
+			m.SetQuestion("www.example.com.", dns.TypeA)
+			resp := exchange(n, m)
+			ttl := resp.Answer[0].Header().Ttl
+			for _, rr := range resp.Answer {
+				if rr.Header().Ttl != ttl {
+					t.Fatalf("Test %d: expected the RRs of an RRset to share their TTL, got %v", i, resp.Answer)
+				}
+			}
+			if ttl < tc.min || ttl > tc.max {
+				t.Fatalf("Test %d: expected a TTL between %d and %d, got %d", i, tc.min, tc.max, ttl)
+			}
+			seen[ttl] = true
+		}
+		// The seeded draws of 200 queries hit every value in the band.
+		if want := int(tc.max-tc.min) + 1; len(seen) != want {
+			t.Errorf("Test %d: expected %d different TTLs, got %d", i, want, len(seen))
+		}
+	}
+}
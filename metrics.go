@@ -1,20 +1,171 @@
 package nightlightdns
 
 import (
-	"sync"
-
 	"github.com/coredns/coredns/plugin"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-// requestCount exports a prometheus metric that is incremented every time a query is seen by the example plugin.
+// requestCount counts the queries for names in the zones, by zone.
 var requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Namespace: plugin.Namespace,
 	Subsystem: "nightlightdns",
 	Name:      "request_count_total",
 	Help:      "Counter of requests made.",
+}, []string{"server", "zone"})
+
+// responseCount counts the responses written by the plugin, by zone and rcode. Empty NOERROR
+// responses are counted as NODATA.
+var responseCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "responses_total",
+	Help:      "Counter of responses written, by rcode.",
+}, []string{"server", "zone", "rcode"})
+
+// requestDuration exports a histogram of the time spent handling a query.
+var requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "request_duration_seconds",
+	Buckets:   plugin.TimeBuckets,
+	Help:      "Histogram of the time (in seconds) each request took.",
 }, []string{"server"})
 
-var once sync.Once
+// lookupErrors counts queries whose lookup failed, answered with SERVFAIL.
+var lookupErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "lookup_errors_total",
+	Help:      "Counter of lookups that failed and were answered with SERVFAIL.",
+}, []string{"server", "zone"})
+
+// recordCount exports the number of records loaded from each records file.
+var recordCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "records",
+	Help:      "The number of records loaded from the records file.",
+}, []string{"file"})
+
+// lastReload exports when the records of each records file were last replaced.
+var lastReload = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "last_reload_timestamp_seconds",
+	Help:      "The time the records of the records file were last replaced, in seconds since the epoch.",
+}, []string{"file"})
+
+// dataVersion is 1 for the SHA-256 hash of the contents each records file was last loaded from, or
+// of the records set through the admin API.
+var dataVersion = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "data_version",
+	Help:      "The SHA-256 hash of the records file contents that are served, as the hash label.",
+}, []string{"file", "hash"})
+
+// reloadFailures counts reloads of a records file that failed, leaving the previous records in place.
+var reloadFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "reload_failures_total",
+	Help:      "Counter of records file reloads that failed.",
+}, []string{"file"})
+
+// recordsFileBytes exports the size of each records file when it was last loaded.
+var recordsFileBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "records_file_bytes",
+	Help:      "The size of the records file when it was last loaded, in bytes.",
+}, []string{"file"})
+
+// lastReloadError is set to 1 while the last reload of a records file failed, and lastReloadErrorTime
+// exports when a reload last failed.
+var (
+	lastReloadError = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nightlightdns",
+		Name:      "last_reload_error",
+		Help:      "Whether the last reload of the records file failed.",
+	}, []string{"file"})
+	lastReloadErrorTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nightlightdns",
+		Name:      "last_reload_error_timestamp_seconds",
+		Help:      "The time a reload of the records file last failed, in seconds since the epoch.",
+	}, []string{"file"})
+)
+
+// backendStale is set to 1 while a backend serving records from memory failed to refresh them.
+var backendStale = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "backend_stale",
+	Help:      "Whether the last refresh of the records of a backend failed.",
+}, []string{"backend"})
+
+// backendFailures counts failed lookups in a backend, each resulting in SERVFAIL.
+var backendFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "backend_failures_total",
+	Help:      "Counter of backend lookups that failed.",
+}, []string{"backend"})
+
+// cacheHits and cacheMisses count the lookups a backend answered from its cache, and those it didn't.
+// cacheEvictions counts the entries dropped to make room in a full cache.
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nightlightdns",
+		Name:      "cache_hits_total",
+		Help:      "Counter of backend lookups answered from the cache.",
+	}, []string{"backend"})
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nightlightdns",
+		Name:      "cache_misses_total",
+		Help:      "Counter of backend lookups not found in the cache.",
+	}, []string{"backend"})
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nightlightdns",
+		Name:      "cache_evictions_total",
+		Help:      "Counter of entries evicted from a full backend cache.",
+	}, []string{"backend"})
+)
+
+// rateLimited counts queries refused because the client exceeded the rate limit.
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "ratelimited_total",
+	Help:      "Counter of queries refused by the rate limit.",
+}, []string{"server"})
+
+// blocked counts queries answered with the block response of the blocklist.
+var blocked = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "blocked_total",
+	Help:      "Counter of queries for names on the blocklist.",
+}, []string{"server"})
+
+// slowQueries counts queries that took longer than the slowlog threshold.
+var slowQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "slow_queries_total",
+	Help:      "Counter of queries that took longer than the slowlog threshold.",
+}, []string{"server"})
+
+// familyMismatches counts addresses skipped because their family doesn't match the query type.
+var familyMismatches = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "nightlightdns",
+	Name:      "family_mismatch_total",
+	Help:      "Counter of addresses skipped for not matching the family of the query.",
+})
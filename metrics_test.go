@@ -0,0 +1,208 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sampleCount returns the number of observations of the histogram h.
+func sampleCount(t *testing.T, h prometheus.Observer) uint64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := h.(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRecordCountMetric(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", testRecords)
+	newTestPlugin(t, "nightlightdns "+path+" example.com")
+
+	if got := testutil.ToFloat64(recordCount.WithLabelValues(path)); got != 3 {
+		t.Errorf("Expected a record count of 3 for %s, got %v", path, got)
+	}
+}
+
+func TestRequestDurationMetric(t *testing.T) {
+	n := newRecordsPlugin(t, testRecords)
+	// The queries are served without a server in their context.
+	before := sampleCount(t, requestDuration.WithLabelValues(""))
+
+	for _, name := range []string{"www.example.com.", "nope.example.com.", "www.example.org."} {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		exchange(n, m)
+	}
+	if got := sampleCount(t, requestDuration.WithLabelValues("")) - before; got != 3 {
+		t.Errorf("Expected 3 observations, got %d", got)
+	}
+}
+
+func TestLookupErrorsMetric(t *testing.T) {
+	s := newMockStore("www.example.com. 60 IN A 192.0.2.1")
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: s}
+	failures := lookupErrors.WithLabelValues("", "example.com.")
+	before := testutil.ToFloat64(failures)
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	exchange(n, m)
+	if got := testutil.ToFloat64(failures) - before; got != 0 {
+		t.Errorf("Expected no lookup errors for a successful lookup, got %v", got)
+	}
+
+	s.err = fmt.Errorf("backend down")
+	if resp := exchange(n, m); resp.Rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL for a failed lookup, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if got := testutil.ToFloat64(failures) - before; got != 1 {
+		t.Errorf("Expected 1 lookup error, got %v", got)
+	}
+}
+
+func TestResponseCountMetric(t *testing.T) {
+	n := newRecordsPlugin(t, testRecords)
+	rcodes := []string{"NOERROR", "NODATA", "NXDOMAIN", "SERVFAIL"}
+	count := func() map[string]float64 {
+		counts := map[string]float64{}
+		for _, rcode := range rcodes {
+			counts[rcode] = testutil.ToFloat64(responseCount.WithLabelValues("", "example.com.", rcode))
+		}
+		return counts
+	}
+
+	tests := []struct {
+		qname string
+		qtype uint16
+		rcode string // the count expected to increase, empty if none is
+	}{
+		{"www.example.com.", dns.TypeA, "NOERROR"},
+		{"www.example.com.", dns.TypeTXT, "NODATA"},
+		{"nope.example.com.", dns.TypeA, "NXDOMAIN"},
+		{"www.example.org.", dns.TypeA, ""},
+	}
+	for i, tc := range tests {
+		before := count()
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		exchange(n, m)
+		after := count()
+		for _, rcode := range rcodes {
+			want := 0.0
+			if rcode == tc.rcode {
+				want = 1
+			}
+			if got := after[rcode] - before[rcode]; got != want {
+				t.Errorf("Test %d: expected the %s count to increase by %v, got %v", i, rcode, want, got)
+			}
+		}
+	}
+}
+
+func TestRequestCountZoneLabel(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", `{
+  "records": [
+    {"name": "www.example.com.", "ipaddress": "192.0.2.1"},
+    {"name": "www.sub.example.com.", "ipaddress": "192.0.2.2"},
+    {"name": "www.example.org.", "ipaddress": "192.0.2.3"}
+  ]
+}`)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com sub.example.com example.org")
+	zones := []string{"example.com.", "sub.example.com.", "example.org.", ""}
+
+	tests := []struct {
+		qname string
+		zone  string // the zone whose count increases, empty if none does
+	}{
+		{"www.example.com.", "example.com."},
+		{"nope.example.com.", "example.com."},
+		// The most specific zone counts.
+		{"www.sub.example.com.", "sub.example.com."},
+		{"www.example.org.", "example.org."},
+		// Queries outside of the zones aren't counted.
+		{"www.example.net.", ""},
+	}
+	for i, tc := range tests {
+		before := map[string]float64{}
+		for _, zone := range zones {
+			before[zone] = testutil.ToFloat64(requestCount.WithLabelValues("", zone))
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		exchange(n, m)
+		for _, zone := range zones {
+			want := 0.0
+			if zone == tc.zone && zone != "" {
+				want = 1
+			}
+			if got := testutil.ToFloat64(requestCount.WithLabelValues("", zone)) - before[zone]; got != want {
+				t.Errorf("Test %d: expected the count of zone %q to increase by %v for %s, got %v", i, zone, want, tc.qname, got)
+			}
+		}
+	}
+}
+
+func TestReloadErrorMetrics(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "dns.json", testRecords)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com")
+	f := n.Store.(*JSONStore).Files[0]
+
+	tests := []struct {
+		// records are written to the file before reloading it, it's removed if empty.
+		records string
+		failed  bool
+		// address is the one www resolves to after the reload.
+		address string
+	}{
+		{`{"records": [`, true, "192.0.2.1"},
+		{"", true, "192.0.2.1"},
+		{testRecords, false, "192.0.2.1"},
+		{`{"records": [{"name": "www.example.com.", "ipaddress": "192.0.2.9"}]}`, false, "192.0.2.9"},
+	}
+	// good are the records last loaded successfully, their size is exported.
+	good := testRecords
+	for i, tc := range tests {
+		if tc.records == "" {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			writeFile(t, dir, "dns.json", tc.records)
+		}
+		failures := testutil.ToFloat64(reloadFailures.WithLabelValues(path))
+		start := float64(time.Now().Unix())
+		f.update()
+		if !tc.failed {
+			good = tc.records
+		}
+
+		failed := testutil.ToFloat64(lastReloadError.WithLabelValues(path)) == 1
+		if failed != tc.failed {
+			t.Errorf("Test %d: expected last_reload_error %v, got %v", i, tc.failed, failed)
+		}
+		if tc.failed {
+			if got := testutil.ToFloat64(reloadFailures.WithLabelValues(path)); got != failures+1 {
+				t.Errorf("Test %d: expected %v reload failures, got %v", i, failures+1, got)
+			}
+			if at := testutil.ToFloat64(lastReloadErrorTime.WithLabelValues(path)); at < start {
+				t.Errorf("Test %d: expected the time of the error to be at least %v, got %v", i, start, at)
+			}
+		}
+		if got := testutil.ToFloat64(recordsFileBytes.WithLabelValues(path)); got != float64(len(good)) {
+			t.Errorf("Test %d: expected records_file_bytes %d, got %v", i, len(good), got)
+		}
+		// A failed reload keeps the last good records.
+		if !resolves(n, "www.example.com.", tc.address) {
+			t.Errorf("Test %d: expected www to resolve to %s", i, tc.address)
+		}
+	}
+}
@@ -1,116 +1,429 @@
-// Package example is a CoreDNS plugin that prints "example" to stdout on every packet received.
+// Package nightlightdns is a CoreDNS plugin that answers queries for its zones from records files,
+// a zone file or a remote backend such as SQL, Redis, Consul, HTTP or gRPC.
 //
-// It serves as an example CoreDNS plugin with numerous code comments.
+// The records are kept current as they change, and answers can be tailored to the client's subnet
+// or region, signed with DNSSEC and transferred to secondaries.
 package nightlightdns
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net"
-	"strings"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/fall"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
 
 	"github.com/miekg/dns"
 )
 
-type DNSRecords struct {
-	Records []DNSRecord `json:"records"`
-}
-type DNSRecord struct {
-	Name      string `json:"name"`
-	Ipaddress string `json:"ipaddress"`
-}
-
 // Define log to be a logger with the plugin name in it. This way we can just use log.Info and
 // friends to log.
 var log = clog.NewWithPlugin("nightlightdns")
 
-// Example is an example plugin to show how to write a plugin.
+// Nightlightdns answers the queries for its zones from its store, passing the others to the next
+// plugin.
 type Nightlightdns struct {
 	Next plugin.Handler
+
+	// Zones are the zones queries are answered for, others are passed to the next plugin.
+	Zones []string
+
+	// Zonefile, when set, is served instead of Store.
+	Zonefile *Zonefile
+
+	// Store is queried for the answers, by default it serves the records file.
+	Store RecordStore
+
+	// Log is the format of the query log, logPlain or logJSON.
+	Log string
+
+	// Slowlog is the duration after which a query is logged as slow, zero disables it.
+	Slowlog time.Duration
+
+	// SOA holds the SOA record of every zone, by zone name, if one was configured.
+	SOA map[string]*dns.SOA
+
+	// serial replaces the serial of the SOA records when it follows changes to the records.
+	serial *soaSerial
+
+	// MinimalAny answers ANY queries with a single HINFO RR, following RFC 8482, instead of all
+	// records of the name.
+	MinimalAny bool
+
+	// MaxUDPSize caps the UDP size of responses and is advertised in their OPT RR, if set.
+	MaxUDPSize uint16
+
+	// MinimalResponses leaves out the additional records resolvers don't need.
+	MinimalResponses bool
+
+	// Compress compresses the names in responses. It's on unless disabled.
+	Compress bool
+
+	// EDE adds an Extended DNS Error to SERVFAIL responses, explaining the failure.
+	EDE bool
+
+	// StrictAA sets the AA bit only on responses answered from the records of the zones. Made up
+	// answers, such as those of the catch-all, records outside the zones and refusals don't get it.
+	StrictAA bool
+
+	// ACL restricts which clients get answers.
+	ACL ACL
+
+	// AllowTransfer holds the clients allowed to transfer the zones with AXFR, transfers are
+	// refused if it's nil.
+	AllowTransfer *ACL
+
+	// TrustedProxies holds the load balancers and resolvers whose ECS option names the real client,
+	// if set. See client.
+	TrustedProxies *ACL
+
+	Fall fall.F
+
+	// regions maps client subnets to the regions of records, if set.
+	regions regionMap
+
+	// rewrites map query names to the names looked up in the store.
+	rewrites rewrites
+
+	// dns64 synthesizes AAAA answers from A records, if set.
+	dns64 *dns64
+
+	// chash picks the first address of multi-address answers by the client, if set.
+	chash *consistentHash
+
+	// jitter is added to the TTLs of answers, if set.
+	jitter *jitter
+
+	// shuffler randomizes the order of answers, if set.
+	shuffler *shuffler
+
+	// allowedTypes limits the types of the records served in a zone, keyed by zone. All types are
+	// served in zones without an entry.
+	allowedTypes map[string]allowedTypes
+
+	// chaos answers CHAOS class queries for the server's version and host name, if set.
+	chaos *chaos
+
+	// blocklist answers for blocked names, if set.
+	blocklist *blocklist
+
+	// catchAll answers for unknown names, if set.
+	catchAll *catchAll
+
+	// signer signs responses, if DNSSEC is enabled.
+	signer *signer
+
+	// limiter limits the query rate of every client, if set.
+	limiter *rateLimiter
+
+	// admin is the admin API for the records file, if enabled.
+	admin *admin
+
+	// recent keeps the last queries for the admin API, if set.
+	recent *recentQueries
+
+	// views are the records served instead of Store to the queries of the servers they're keyed by.
+	views map[string]*JSONStore
+
+	// export serves the records file to other instances, if enabled.
+	export *export
+
+	// upstream resolves CNAME targets outside the zones, if set.
+	upstream *upstreamResolver
 }
 
-// ServeDNS implements the plugin.Handler interface. This method gets called when example is used
-// in a Server.
+// ServeDNS implements the plugin.Handler interface. Queries for names in the zones are answered from
+// the store, after the ACL, rate limit, blocklist and delegations had their say. Queries for other
+// names, and those that fall through, are passed to the next plugin.
 func (n Nightlightdns) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
-	// This function could be simpler. I.e. just fmt.Println("example") here, but we want to show
-	// a slightly more complex example as to make this more interesting.
-	// Here we wrap the dns.ResponseWriter in a new ResponseWriter and call the next plugin, when the
-	// answer comes back, it will print "example".
+	// Time the query from the start, whatever path it ends up taking.
+	start := time.Now()
+	defer func() {
+		requestDuration.WithLabelValues(metrics.WithServer(ctx)).Observe(time.Since(start).Seconds())
+	}()
 
-	var (
-		err error
-	)
+	// Debug log that we've seen the query. This will only be shown when the debug plugin is loaded.
+	log.Debug("Received query")
 
-	// Debug log that we've have seen the query. This will only be shown when the debug plugin is loaded.
-	log.Debug("Received response")
-	state := request.Request{W: w, Req: r}
+	// Record the response for the query log.
+	rec := dnstest.NewRecorder(w)
+	w = rec
+
+	// Our own responses are counted, those of the next plugin written to w aren't.
+	counter := &responseCounter{ResponseWriter: w, server: metrics.WithServer(ctx)}
+	state := request.Request{W: counter, Req: r}
 	qname := state.Name()
-	log.Info(qname)
-	answers := []dns.RR{}
 
-	// check record type here and bail out if not A or AAAA
-	if state.QType() != dns.TypeA && state.QType() != dns.TypeAAAA {
+	// The version and host name of the server are answered whatever zones are served, as the chaos
+	// plugin would, and refused unless configured.
+	if ok, rcode, err := n.serveChaos(state); ok {
+		return rcode, err
+	}
+
+	// Queries to a server with a view of its own are answered from its records.
+	n = n.view(ctx)
+
+	// Only answer for names in our zones. The zone labels the metrics, which keeps their cardinality
+	// down to the configured zones.
+	zone := plugin.Zones(n.Zones).Matches(qname)
+	if zone == "" {
+		return plugin.NextOrFailure(n.Name(), n.Next, ctx, w, r)
+	}
+	counter.zone = zone
+
+	// Log the queries for our zones, whatever path they end up taking.
+	defer func() {
+		d := time.Since(start)
+		logQuery(n.Log, state, rec, d)
+		if n.recent != nil {
+			n.recent.add(state, rec, d)
+		}
+		if n.Slowlog > 0 && d > n.Slowlog {
+			slowQueries.WithLabelValues(metrics.WithServer(ctx)).Inc()
+			log.Warningf("Slow query for %s %s took %s", state.Type(), qname, d)
+		}
+	}()
+
+	// Refuse clients the ACL denies before looking anything up.
+	client, subnet := n.client(state)
+	if !n.ACL.Allowed(client) {
+		return n.dnserror(dns.RcodeRefused, state, nil)
+	}
+
+	// Refuse clients sending more queries than the rate limit allows.
+	if n.limiter != nil && !n.limiter.allow(client.String()) {
+		rateLimited.WithLabelValues(metrics.WithServer(ctx)).Inc()
+		return n.dnserror(dns.RcodeRefused, state, nil)
+	}
+
+	// The records only exist in the Internet class, queries for other classes are refused.
+	if state.QClass() != dns.ClassINET {
+		if n.Fall.Through(qname) {
+			return plugin.NextOrFailure(n.Name(), n.Next, ctx, w, r)
+		}
+		requestCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
+		return n.dnserror(dns.RcodeRefused, state, nil)
+	}
+
+	// Zone transfers send the whole zone, from the zone file or the store.
+	if state.QType() == dns.TypeAXFR {
+		requestCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
+		return n.transfer(state)
+	}
+
+	// Blocked names get the block response, whatever records they have.
+	if n.blocklist != nil && n.blocklist.blocked(qname) {
+		requestCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
+		blocked.WithLabelValues(metrics.WithServer(ctx)).Inc()
+		return n.block(state)
+	}
+
+	// A zone file holds records of any type, so all queries are answered from it.
+	if n.Zonefile != nil {
+		requestCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
+		return n.serveZone(ctx, w, state, zone)
+	}
+
+	// check record type here and bail out if it's not one we serve
+	if !n.serves(state.QType()) {
 		// always fallthrough if configured
 		return plugin.NextOrFailure(n.Name(), n.Next, ctx, w, r)
+	}
+
+	// Export metric with the server label set to the current server handling the request.
+	requestCount.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
 
-		// otherwise return SERVFAIL here without fallthrough
-		return dnserror(dns.RcodeServerFailure, state, err)
+	// The zone apex answers SOA queries itself, whatever the store holds.
+	if soa := n.soa(qname); soa != nil && state.QType() == dns.TypeSOA {
+		return n.reply(state, []dns.RR{soa}, nil, false)
 	}
 
-	file, _ := ioutil.ReadFile("dns.json")
+	// The zone's key is served at the apex when DNSSEC is enabled.
+	if n.signer != nil && state.QType() == dns.TypeDNSKEY && qname == n.signer.zone {
+		return n.reply(state, []dns.RR{n.signer.dnskey()}, nil, false)
+	}
 
-	data := DNSRecords{}
+	// Names in a delegated subzone are answered with a referral to its name servers.
+	if d, ok := n.Store.(Delegator); ok {
+		if nss, glue := d.Delegation(zone, qname); len(nss) > 0 {
+			return n.referral(state, nss, glue)
+		}
+	}
 
-	_ = json.Unmarshal([]byte(file), &data)
+	var (
+		answers []dns.RR
+		err     error
 
-	outip := ""
-	for _, record := range data.Records {
-		log.Info(record.Ipaddress)
-		baseName := strings.Split(qname, ".")
-		if record.Name == baseName[0] {
-			log.Info(fmt.Sprintf("Found matching record: %s - %s", baseName, record.Ipaddress))
-			outip = record.Ipaddress
+		// synthesized is set if the answers are made up rather than taken from the records.
+		synthesized bool
+	)
+	// The records of a rewritten name are answered under the name that was queried.
+	name := n.rewrites.apply(qname)
+	// Tailor the answer to the client's region and, if it sent one, its subnet if the store
+	// supports it.
+	var ecs *dns.EDNS0_SUBNET
+	if s, ok := n.Store.(RegionStore); ok && len(n.regions) > 0 {
+		region, ones := n.regions.match(client)
+		var ip net.IP
+		if subnet != nil {
+			ip = subnet.Address
+		}
+		var scope uint8
+		answers, scope, err = s.LookupRegion(name, state.QType(), ip, region)
+		if subnet != nil {
+			e := *subnet
+			e.SourceScope = scope
+			// The region's prefix only scopes the answer if the region was matched on the ECS
+			// address, not on the resolver's.
+			if ones > scope && client.Equal(subnet.Address) {
+				e.SourceScope = ones
+			}
+			ecs = &e
+		}
+	} else if s, ok := n.Store.(SubnetStore); ok && subnet != nil {
+		e := *subnet
+		answers, e.SourceScope, err = s.LookupSubnet(name, state.QType(), e.Address)
+		ecs = &e
+	} else {
+		answers, err = n.Store.Lookup(name, state.QType())
+	}
+	// Names without IPv6 addresses get them synthesized from their IPv4 ones with DNS64.
+	if n.dns64 != nil && err == nil && state.QType() == dns.TypeAAAA && !hasType(answers, dns.TypeAAAA) {
+		if v4, err := n.Store.Lookup(name, dns.TypeA); err == nil && hasType(v4, dns.TypeA) {
+			answers = n.dns64.synthesize(v4)
+			synthesized = true
 		}
 	}
+	restore(answers, name, qname)
+	if err == nil {
+		answers = n.allowedTypes[zone].filter(state.QType(), n.chase(ctx, state, answers))
+	}
+	n.chash.order(answers, client, qname)
+	n.shuffler.shuffle(answers)
+	switch {
+	case err == errDNAMETooLong:
+		// RFC 6672 answers names that can't be redirected with YXDOMAIN.
+		return n.dnserror(dns.RcodeYXDomain, state, nil)
+	case err == ErrNoSuchName:
+		// The name doesn't exist at all, let the next plugin have a go if fallthrough is configured.
+		if n.Fall.Through(qname) {
+			return plugin.NextOrFailure(n.Name(), n.Next, ctx, w, r)
+		}
+		if n.catchAll != nil {
+			return n.reply(state, n.catchAll.answer(qname, state.QType()), nil, true)
+		}
+		return n.nxdomain(state, false)
+	case err != nil:
+		log.Errorf("Failed to look up %s: %v", qname, err)
+		lookupErrors.WithLabelValues(metrics.WithServer(ctx), zone).Inc()
+		return n.dnserror(dns.RcodeServerFailure, state, err)
+	}
 
-	answers = append(answers, &dns.A{
-		Hdr: dns.RR_Header{
-			Name:   qname,
-			Rrtype: dns.TypeA,
-			Class:  dns.ClassINET,
-			Ttl:    30,
-		},
-		A: net.ParseIP(outip),
-	})
-	log.Info(answers)
+	if n.MinimalAny && state.QType() == dns.TypeANY && len(answers) > 0 {
+		answers = []dns.RR{hinfo(qname, answers[0].Header().Ttl, "RFC8482", "")}
+	}
 
-	// Export metric with the server label set to the current server handling the request.
-	requestCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+	return n.reply(state, answers, ecs, synthesized)
+}
+
+// reply writes an authoritative response holding answers to the client. If ecs is set it's echoed
+// back, with the scope of the answer, in the response's OPT RR. Responses that don't fit the
+// client's UDP buffer are truncated and have the TC bit set, so the client retries over TCP.
+// Synthesized answers are made up rather than taken from the records, they aren't authoritative
+// with StrictAA.
+func (n Nightlightdns) reply(state request.Request, answers []dns.RR, ecs *dns.EDNS0_SUBNET, synthesized bool) (int, error) {
+	r := state.Req
 
 	// create DNS response
 	m := new(dns.Msg)
 	m.SetReply(r)
-	m.Authoritative = true
+	m.Authoritative = !(n.StrictAA && synthesized)
 	m.Answer = answers
+	n.jitter.apply(m.Answer)
+
+	// Negative answers carry the zone's SOA, so resolvers can cache them.
+	if len(answers) == 0 {
+		if soa := n.soaFor(state.Name()); soa != nil {
+			m.Ns = []dns.RR{soa}
+		}
+	}
+
+	if ecs != nil {
+		opt := r.IsEdns0()
+		m.SetEdns0(opt.UDPSize(), opt.Do())
+		o := m.IsEdns0()
+		o.Option = append(o.Option, ecs)
+	}
+
+	if err := n.signMsg(state, m); err != nil {
+		log.Errorf("Failed to respond to %s: %v", state.Name(), err)
+		return n.dnserror(dns.RcodeServerFailure, state, err)
+	}
 
 	// send response back to client
-	_ = w.WriteMsg(m)
+	n.writeMsg(state, m)
 
 	// signal response sent back to client
 	return dns.RcodeSuccess, nil
 }
 
+// nxdomain writes an NXDOMAIN response, with the zone's SOA in the authority section if there is one.
+// A synthesized NXDOMAIN isn't authoritative with StrictAA.
+func (n Nightlightdns) nxdomain(state request.Request, synthesized bool) (int, error) {
+	m := new(dns.Msg)
+	m.SetRcode(state.Req, dns.RcodeNameError)
+	m.Authoritative = !(n.StrictAA && synthesized)
+	if soa := n.soaFor(state.Name()); soa != nil {
+		m.Ns = []dns.RR{soa}
+	}
+	if err := n.signMsg(state, m); err != nil {
+		log.Errorf("Failed to respond to %s: %v", state.Name(), err)
+		return n.dnserror(dns.RcodeServerFailure, state, err)
+	}
+
+	n.writeMsg(state, m)
+	return dns.RcodeSuccess, nil
+}
+
+// serves reports whether qtype is answered by the plugin, rather than passed to the next one.
+func (n Nightlightdns) serves(qtype uint16) bool {
+	switch {
+	case supported(qtype):
+		return true
+	case qtype == dns.TypeSOA:
+		return n.SOA != nil
+	case qtype == dns.TypeDNSKEY:
+		return n.signer != nil
+	}
+	return false
+}
+
 // Name implements the Handler interface.
 func (n Nightlightdns) Name() string { return "nightlightdns" }
 
-// ResponsePrinter wrap a dns.ResponseWriter and will write example to standard output when WriteMsg is called.
+// responseCounter wraps a dns.ResponseWriter and counts the responses written through it by rcode.
+type responseCounter struct {
+	dns.ResponseWriter
+	server, zone string
+}
+
+// WriteMsg calls the underlying ResponseWriter's WriteMsg method and counts the response. Empty
+// authoritative NOERROR responses are counted as NODATA, referrals aren't authoritative.
+func (r *responseCounter) WriteMsg(res *dns.Msg) error {
+	rcode := dns.RcodeToString[res.Rcode]
+	if res.Rcode == dns.RcodeSuccess && len(res.Answer) == 0 && res.Authoritative {
+		rcode = "NODATA"
+	}
+	responseCount.WithLabelValues(r.server, r.zone, rcode).Inc()
+	return r.ResponseWriter.WriteMsg(res)
+}
+
+// ResponsePrinter wraps a dns.ResponseWriter and logs every response written through it at debug
+// level, so it's only shown when the debug plugin is loaded.
 type ResponsePrinter struct {
 	dns.ResponseWriter
 }
@@ -120,21 +433,110 @@ func NewResponsePrinter(w dns.ResponseWriter) *ResponsePrinter {
 	return &ResponsePrinter{ResponseWriter: w}
 }
 
-// WriteMsg calls the underlying ResponseWriter's WriteMsg method and prints "example" to standard output.
+// WriteMsg calls the underlying ResponseWriter's WriteMsg method and logs the response at debug level.
 func (r *ResponsePrinter) WriteMsg(res *dns.Msg) error {
-	log.Info("nightlightdns")
-	fmt.Println("nightlightdns")
+	log.Debugf("Writing %s response", dns.RcodeToString[res.Rcode])
 	return r.ResponseWriter.WriteMsg(res)
 }
 
-func dnserror(rcode int, state request.Request, err error) (int, error) {
+// dnserror writes a response with rcode and no records to state. With EDE, a SERVFAIL caused by err
+// carries an Extended DNS Error telling the client why, if it sent an OPT RR.
+func (n Nightlightdns) dnserror(rcode int, state request.Request, err error) (int, error) {
 	m := new(dns.Msg)
 	m.SetRcode(state.Req, rcode)
 	m.Authoritative = true
+	if opt := state.Req.IsEdns0(); n.EDE && rcode == dns.RcodeServerFailure && err != nil && opt != nil {
+		m.SetEdns0(opt.UDPSize(), opt.Do())
+		o := m.IsEdns0()
+		o.Option = append(o.Option, extendedError(err))
+	}
 
 	// send response
-	_ = state.W.WriteMsg(m)
+	n.writeMsg(state, m)
 
 	// return success as the rcode to signal we have written to the client.
 	return dns.RcodeSuccess, err
 }
+
+// writeMsg writes m, the response to state, to the client. If the query has an OPT RR the response
+// gets one too, echoing the client's UDP size and DO bit, or advertising MaxUDPSize if that's set.
+// UDP responses are truncated to the client's UDP size, capped at MaxUDPSize. The AD bit is never
+// set: the plugin is authoritative and doesn't validate anything, signed or not. Names are
+// compressed if Compress is set. With StrictAA the AA bit is cleared unless the response is
+// authoritative.
+func (n Nightlightdns) writeMsg(state request.Request, m *dns.Msg) {
+	m.AuthenticatedData = false
+	if n.StrictAA && !n.authoritative(m) {
+		m.Authoritative = false
+	}
+	if opt := state.Req.IsEdns0(); opt != nil && m.IsEdns0() == nil {
+		m.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+	if n.MaxUDPSize > 0 {
+		if opt := m.IsEdns0(); opt != nil {
+			opt.SetUDPSize(n.MaxUDPSize)
+		}
+		// Scrub truncates to the client's size, so only a smaller cap needs truncating first.
+		if state.Proto() == "udp" && state.Size() > int(n.MaxUDPSize) {
+			m.Truncate(int(n.MaxUDPSize))
+		}
+	}
+	// Truncating compresses the response, so without compression it's cut down to fit uncompressed
+	// first, then Scrub leaves it as it is.
+	if !n.Compress && state.Proto() == "udp" {
+		size := state.Size()
+		if n.MaxUDPSize > 0 && size > int(n.MaxUDPSize) {
+			size = int(n.MaxUDPSize)
+		}
+		m.Compress = false
+		truncate(m, size)
+	}
+	// Scrub turns compression on or off depending on the size of the response, so it's set
+	// afterwards.
+	m = state.Scrub(m)
+	m.Compress = n.Compress
+	_ = state.W.WriteMsg(m)
+}
+
+// truncate removes RRs from the end of m, additional ones first, until it fits in size bytes. The
+// OPT RR is kept, the TC bit is set if answers or authority RRs had to go.
+func truncate(m *dns.Msg, size int) {
+	for m.Len() > size {
+		i := len(m.Extra) - 1
+		for i >= 0 && m.Extra[i].Header().Rrtype == dns.TypeOPT {
+			i--
+		}
+		switch {
+		case i >= 0:
+			m.Extra = append(m.Extra[:i:i], m.Extra[i+1:]...)
+		case len(m.Ns) > 0:
+			m.Ns = m.Ns[:len(m.Ns)-1]
+			m.Truncated = true
+		case len(m.Answer) > 0:
+			m.Answer = m.Answer[:len(m.Answer)-1]
+			m.Truncated = true
+		default:
+			return
+		}
+	}
+}
+
+// authoritative reports whether m answers from the records of the zones: it isn't a refusal or
+// failure, and all of its answers are within the zones. Made up answers are written without the AA
+// bit in the first place.
+func (n Nightlightdns) authoritative(m *dns.Msg) bool {
+	if !m.Authoritative {
+		return false
+	}
+	switch m.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError, dns.RcodeYXDomain:
+	default:
+		return false
+	}
+	for _, rr := range m.Answer {
+		if plugin.Zones(n.Zones).Matches(rr.Header().Name) == "" {
+			return false
+		}
+	}
+	return true
+}
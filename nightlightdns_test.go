@@ -0,0 +1,602 @@
+package nightlightdns
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func init() { clog.Discard() }
+
+// testRecords are the records most tests are answered from, served for example.com.
+const testRecords = `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1", "ipv6address": "2001:db8::1"},
+    {"name": "mail", "type": "MX", "target": "mx", "preference": 10},
+    {"name": "mx", "ipaddress": "192.0.2.25"}
+  ]
+}`
+
+// writeFile writes content to the file name in dir and returns its path.
+func writeFile(t testing.TB, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestPlugin returns the plugin set up from corefile, the nightlightdns directive and its block.
+// The next plugin answers SERVFAIL.
+func newTestPlugin(t testing.TB, corefile string) Nightlightdns {
+	t.Helper()
+	c := caddy.NewTestController("dns", corefile)
+	if err := setup(c); err != nil {
+		t.Fatalf("Expected no errors setting up %q, got: %v", corefile, err)
+	}
+	plugins := dnsserver.GetConfig(c).Plugin
+	return plugins[len(plugins)-1](test.ErrorHandler()).(Nightlightdns)
+}
+
+// newRecordsPlugin returns the plugin serving records for example.com, configured with the
+// directives in options.
+func newRecordsPlugin(t testing.TB, records string, options ...string) Nightlightdns {
+	t.Helper()
+	path := writeFile(t, t.TempDir(), "dns.json", records)
+	return newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com {\n%s\n}", path, strings.Join(options, "\n")))
+}
+
+// exchange returns the response of h to m.
+func exchange(h Nightlightdns, m *dns.Msg) *dns.Msg {
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	h.ServeDNS(context.TODO(), rec, m)
+	return rec.Msg
+}
+
+// checkCases checks the responses of h to the queries of tests.
+func checkCases(t *testing.T, h Nightlightdns, tests []test.Case) {
+	t.Helper()
+	for i, tc := range tests {
+		resp := exchange(h, tc.Msg())
+		if resp == nil {
+			t.Errorf("Test %d: expected a response to %s %s", i, tc.Qname, dns.TypeToString[tc.Qtype])
+			continue
+		}
+		if err := test.SortAndCheck(resp, tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+// benchRecords returns a records file with n address records, www0 to www(n-1).
+func benchRecords(n int) string {
+	records := make([]string, n)
+	for i := range records {
+		records[i] = fmt.Sprintf(`{"name": "www%d", "ipaddress": "10.%d.%d.%d"}`, i, i>>16&255, i>>8&255, i&255)
+	}
+	return `{"origin": "example.com.", "records": [` + strings.Join(records, ",") + `]}`
+}
+
+// BenchmarkServeDNS answers from the records loaded at setup.
+func BenchmarkServeDNS(b *testing.B) {
+	n := newRecordsPlugin(b, benchRecords(100))
+	m := new(dns.Msg)
+	m.SetQuestion("www50.example.com.", dns.TypeA)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		exchange(n, m)
+	}
+}
+
+// BenchmarkReadRecords reads and parses the records file, as every query did before the records
+// were loaded at setup.
+func BenchmarkReadRecords(b *testing.B) {
+	path := writeFile(b, b.TempDir(), "dns.json", benchRecords(100))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseRecords(path, formatJSON, false, envOff); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestServeDNSNXDomain(t *testing.T) {
+	n := newRecordsPlugin(t, testRecords)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			Qname: "nope.www.example.com.", Qtype: dns.TypeAAAA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+}
+
+func TestServeDNSCase(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "Mixed.EXAMPLE.com.", "ipaddress": "192.0.2.2"}
+  ]
+}`)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "WWW.Example.COM.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "mixed.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("mixed.example.com. 30 IN A 192.0.2.2")},
+		},
+		{
+			Qname: "MIXED.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("mixed.example.com. 30 IN A 192.0.2.2")},
+		},
+	})
+}
+
+func TestServeDNSQualifiedNames(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", `{
+  "records": [
+    {"name": "www.example.org.", "ipaddress": "192.0.2.1"},
+    {"name": "app", "ipaddress": "192.0.2.2"}
+  ]
+}`)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com example.org")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.org.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.org. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		// Without an origin relative names are below the plugin's first zone.
+		{
+			Qname: "app.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("app.example.com. 30 IN A 192.0.2.2")},
+		},
+		{
+			Qname: "app.example.org.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+}
+
+func TestFallthrough(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", `{
+  "records": [
+    {"name": "www.example.com.", "ipaddress": "192.0.2.1"},
+    {"name": "www.example.org.", "ipaddress": "192.0.2.2"}
+  ]
+}`)
+	tests := []struct {
+		options string
+		qname   string
+		rcode   int // SERVFAIL is the answer of the next plugin.
+	}{
+		{"", "nope.example.com.", dns.RcodeNameError},
+		{"", "nope.example.org.", dns.RcodeNameError},
+		{"fallthrough", "nope.example.com.", dns.RcodeServerFailure},
+		{"fallthrough", "nope.example.org.", dns.RcodeServerFailure},
+		{"fallthrough example.org", "nope.example.com.", dns.RcodeNameError},
+		{"fallthrough example.org", "nope.example.org.", dns.RcodeServerFailure},
+		{"fallthrough example.org", "www.example.org.", dns.RcodeSuccess},
+	}
+	for i, tc := range tests {
+		n := newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com example.org {\n%s\n}", path, tc.options))
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		if resp := exchange(n, m); resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s for %s with %q, got %s", i, dns.RcodeToString[tc.rcode], tc.qname, tc.options, dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
+
+func TestServeDNSNoStdout(t *testing.T) {
+	n := newRecordsPlugin(t, testRecords)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	for _, name := range []string{"www.example.com.", "nope.example.com.", "www.example.org."} {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		exchange(n, m)
+	}
+	os.Stdout = stdout
+	w.Close()
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > 0 {
+		t.Errorf("Expected no output on stdout, got %q", out)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	addresses := make([]string, 50)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("192.0.2.%d", i+1)
+	}
+	n := newRecordsPlugin(t, `{"origin": "example.com.", "records": [{"name": "big", "ipaddress": "`+strings.Join(addresses, ",")+`"}]}`)
+
+	tests := []struct {
+		tcp       bool
+		truncated bool
+	}{
+		{false, true},
+		{true, false},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("big.example.com.", dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: tc.tcp})
+		n.ServeDNS(context.TODO(), rec, m)
+		resp := rec.Msg
+
+		if resp.Truncated != tc.truncated {
+			t.Errorf("Test %d: expected truncated %t, got %t", i, tc.truncated, resp.Truncated)
+		}
+		if tc.truncated && resp.Len() > dns.MinMsgSize {
+			t.Errorf("Test %d: expected at most %d bytes, got %d", i, dns.MinMsgSize, resp.Len())
+		}
+		if !tc.truncated && len(resp.Answer) != len(addresses) {
+			t.Errorf("Test %d: expected %d answers, got %d", i, len(addresses), len(resp.Answer))
+		}
+	}
+}
+
+func TestZones(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", `{
+  "records": [
+    {"name": "www.example.com.", "ipaddress": "192.0.2.1"},
+    {"name": "www.example.org.", "ipaddress": "192.0.2.2"},
+    {"name": "www.example.net.", "ipaddress": "192.0.2.3"}
+  ]
+}`)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com example.org")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "www.example.org.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.org. 30 IN A 192.0.2.2")},
+		},
+		// Names outside of the zones are passed on, the next plugin answers SERVFAIL.
+		{
+			Qname: "www.example.net.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		},
+		{
+			Qname: "example.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		},
+	})
+}
+
+func TestSetupSlowlog(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nslowlog 100ms\n}", false},
+		{"nightlightdns {\nslowlog\n}", true},
+		{"nightlightdns {\nslowlog 0s\n}", true},
+		{"nightlightdns {\nslowlog slow\n}", true},
+	})
+}
+
+func TestSlowlog(t *testing.T) {
+	s := newMockStore("www.example.com. 60 IN A 192.0.2.1")
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: s, Slowlog: 20 * time.Millisecond}
+	slow := slowQueries.WithLabelValues("")
+
+	tests := []struct {
+		delay time.Duration
+		slow  bool
+	}{
+		{0, false},
+		{50 * time.Millisecond, true},
+	}
+	for i, tc := range tests {
+		s.delay = tc.delay
+		before := testutil.ToFloat64(slow)
+		out := captureLog(func() {
+			m := new(dns.Msg)
+			m.SetQuestion("www.example.com.", dns.TypeA)
+			exchange(n, m)
+		})
+		if logged := strings.Contains(out, "Slow query for A www.example.com."); logged != tc.slow {
+			t.Errorf("Test %d: expected the slow query logged %t, got %q", i, tc.slow, out)
+		}
+		counted := testutil.ToFloat64(slow) - before
+		if (counted == 1) != tc.slow {
+			t.Errorf("Test %d: expected the slow query counted %t, got %v", i, tc.slow, counted)
+		}
+	}
+}
+
+func TestEDNS(t *testing.T) {
+	n := newRecordsPlugin(t, testRecords)
+	tests := []struct {
+		edns bool
+		size uint16
+		do   bool
+	}{
+		{false, 0, false},
+		{true, 1232, false},
+		{true, 4096, true},
+	}
+	for i, tc := range tests {
+		for _, qname := range []string{"www.example.com.", "nope.example.com."} {
+			m := new(dns.Msg)
+			m.SetQuestion(qname, dns.TypeA)
+			m.AuthenticatedData = true
+			if tc.edns {
+				m.SetEdns0(tc.size, tc.do)
+			}
+			resp := exchange(n, m)
+
+			if resp.AuthenticatedData {
+				t.Errorf("Test %d: expected no AD bit for %s without DNSSEC", i, qname)
+			}
+			opt := resp.IsEdns0()
+			if !tc.edns {
+				if opt != nil {
+					t.Errorf("Test %d: expected no OPT RR for %s, got %s", i, qname, opt)
+				}
+				continue
+			}
+			if opt == nil {
+				t.Errorf("Test %d: expected an OPT RR for %s", i, qname)
+				continue
+			}
+			if opt.UDPSize() != tc.size || opt.Do() != tc.do {
+				t.Errorf("Test %d: expected an OPT RR with size %d and DO %t for %s, got %s", i, tc.size, tc.do, qname, opt)
+			}
+		}
+	}
+}
+
+func TestQueryClass(t *testing.T) {
+	tests := []struct {
+		options string
+		qclass  uint16
+		rcode   int
+	}{
+		{"", dns.ClassINET, dns.RcodeSuccess},
+		{"", dns.ClassCHAOS, dns.RcodeRefused},
+		{"", dns.ClassHESIOD, dns.RcodeRefused},
+		{"", dns.ClassANY, dns.RcodeRefused},
+		// Fallthrough passes them on, the next plugin answers SERVFAIL.
+		{"fallthrough", dns.ClassCHAOS, dns.RcodeServerFailure},
+		{"fallthrough", dns.ClassINET, dns.RcodeSuccess},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, testRecords, tc.options)
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		m.Question[0].Qclass = tc.qclass
+		resp := exchange(n, m)
+		if resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s for class %s, got %s", i, dns.RcodeToString[tc.rcode], dns.ClassToString[tc.qclass], dns.RcodeToString[resp.Rcode])
+		}
+		if tc.rcode == dns.RcodeRefused && len(resp.Answer) > 0 {
+			t.Errorf("Test %d: expected no answers for class %s, got %v", i, dns.ClassToString[tc.qclass], resp.Answer)
+		}
+	}
+}
+
+func TestSetupMaxUDPSize(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nmax-udp-size 1232\n}", false},
+		{"nightlightdns {\nmax-udp-size 512\n}", false},
+		{"nightlightdns {\nmax-udp-size 511\n}", true},
+		{"nightlightdns {\nmax-udp-size 65536\n}", true},
+		{"nightlightdns {\nmax-udp-size large\n}", true},
+		{"nightlightdns {\nmax-udp-size\n}", true},
+	})
+}
+
+func TestMaxUDPSize(t *testing.T) {
+	texts := make([]string, 50)
+	for i := range texts {
+		texts[i] = fmt.Sprintf(`{"name": "big", "type": "TXT", "text": "%02d%s"}`, i, strings.Repeat("x", 60))
+	}
+	records := `{"origin": "example.com.", "records": [` + strings.Join(texts, ", ") + `]}`
+
+	tests := []struct {
+		options   string
+		edns      uint16
+		tcp       bool
+		truncated bool
+		size      int
+		opt       uint16
+	}{
+		{"", 4096, false, false, 4096, 4096},
+		{"max-udp-size 1232", 4096, false, true, 1232, 1232},
+		// The client's smaller size still applies.
+		{"max-udp-size 4096", 1232, false, true, 1232, 4096},
+		{"max-udp-size 1232", 0, false, true, dns.MinMsgSize, 0},
+		// TCP responses aren't truncated.
+		{"max-udp-size 1232", 4096, true, false, dns.MaxMsgSize, 1232},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options)
+		m := new(dns.Msg)
+		m.SetQuestion("big.example.com.", dns.TypeTXT)
+		if tc.edns > 0 {
+			m.SetEdns0(tc.edns, false)
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: tc.tcp})
+		n.ServeDNS(context.TODO(), rec, m)
+		resp := rec.Msg
+
+		if resp.Truncated != tc.truncated {
+			t.Errorf("Test %d: expected truncated %v, got %v", i, tc.truncated, resp.Truncated)
+		}
+		if !tc.truncated && len(resp.Answer) != len(texts) {
+			t.Errorf("Test %d: expected %d answers, got %d", i, len(texts), len(resp.Answer))
+		}
+		if resp.Len() > tc.size {
+			t.Errorf("Test %d: expected a response of at most %d bytes, got %d", i, tc.size, resp.Len())
+		}
+		opt := resp.IsEdns0()
+		switch {
+		case tc.opt == 0 && opt != nil:
+			t.Errorf("Test %d: expected no OPT RR, got %s", i, opt)
+		case tc.opt > 0 && (opt == nil || opt.UDPSize() != tc.opt):
+			t.Errorf("Test %d: expected an OPT RR advertising %d, got %v", i, tc.opt, opt)
+		}
+	}
+}
+
+func TestSetupCompress(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\ncompress\n}", false},
+		{"nightlightdns {\ncompress off\n}", false},
+		{"nightlightdns {\ncompress on\n}", true},
+		{"nightlightdns {\ncompress off now\n}", true},
+	})
+}
+
+func TestCompress(t *testing.T) {
+	mxs := make([]string, 20)
+	for i := range mxs {
+		mxs[i] = fmt.Sprintf(`{"name": "mail", "type": "MX", "target": "mx%d.mail.example.com.", "preference": %d}`, i, i)
+	}
+	records := `{"origin": "example.com.", "records": [` + strings.Join(mxs, ", ") + `]}`
+
+	sizes := map[string]int{}
+	for _, options := range []string{"", "compress", "compress off"} {
+		n := newRecordsPlugin(t, records, options)
+		m := new(dns.Msg)
+		m.SetQuestion("mail.example.com.", dns.TypeMX)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: true})
+		n.ServeDNS(context.TODO(), rec, m)
+
+		if want := options != "compress off"; rec.Msg.Compress != want {
+			t.Errorf("Expected compression %v with %q, got %v", want, options, rec.Msg.Compress)
+		}
+		if len(rec.Msg.Answer) != len(mxs) {
+			t.Fatalf("Expected %d answers with %q, got %d", len(mxs), options, len(rec.Msg.Answer))
+		}
+		packed, err := rec.Msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[options] = len(packed)
+	}
+	// Compressed owner names take two bytes instead of 18, the targets shrink too.
+	if sizes[""] != sizes["compress"] || sizes["compress off"]-sizes[""] < len(mxs)*16 {
+		t.Errorf("Expected compression to save at least %d bytes, got sizes %v", len(mxs)*16, sizes)
+	}
+
+	// UDP responses larger than the client's size are scrubbed, which compresses them to fit. With
+	// compression off they are truncated to fit uncompressed instead.
+	for _, options := range []string{"", "compress off"} {
+		n := newRecordsPlugin(t, records, options)
+		m := new(dns.Msg)
+		m.SetQuestion("mail.example.com.", dns.TypeMX)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		n.ServeDNS(context.TODO(), rec, m)
+
+		off := options == "compress off"
+		if rec.Msg.Compress == off {
+			t.Errorf("Expected compression %v over UDP with %q, got %v", !off, options, rec.Msg.Compress)
+		}
+		if rec.Msg.Truncated != off {
+			t.Errorf("Expected truncation %v over UDP with %q, got %v", off, options, rec.Msg.Truncated)
+		}
+		if size := rec.Msg.Len(); size > dns.MinMsgSize {
+			t.Errorf("Expected the UDP response with %q to fit in %d bytes, got %d", options, dns.MinMsgSize, size)
+		}
+	}
+}
+
+func TestSetupStrictAA(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nstrict-aa\n}", false},
+		{"nightlightdns {\nstrict-aa on\n}", true},
+	})
+}
+
+func TestStrictAA(t *testing.T) {
+	dir := t.TempDir()
+	blocklist := writeFile(t, dir, "blocklist", "blocked.example.com\n")
+	upstream := startUpstream(t, map[string][]string{
+		"www.example.net.": {"www.example.net. 300 IN A 203.0.113.1"},
+	}, nil)
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "v4", "ipaddress": "192.0.2.2"},
+    {"name": "ext", "type": "CNAME", "target": "www.example.net."},
+    {"name": "self", "type": "CNAME", "target": "self"}
+  ]
+}`
+	strict := []string{"strict-aa", "default 192.0.2.99", "dns64 64:ff9b::/96", "upstream " + upstream, "blocklist " + blocklist + " 0.0.0.0"}
+	tests := []struct {
+		options []string
+		qname   string
+		qtype   uint16
+		aa      bool
+	}{
+		// Answers from the records of the zones are authoritative.
+		{strict, "www.example.com.", dns.TypeA, true},
+		{strict, "www.example.com.", dns.TypeMX, true},
+		{[]string{"strict-aa"}, "nope.example.com.", dns.TypeA, true},
+		{[]string{"strict-aa"}, "ext.example.com.", dns.TypeA, true},
+		// Made up answers and those from outside the zones aren't.
+		{strict, "nope.example.com.", dns.TypeA, false},
+		{strict, "v4.example.com.", dns.TypeAAAA, false},
+		{strict, "blocked.example.com.", dns.TypeA, false},
+		{strict, "ext.example.com.", dns.TypeA, false},
+		{strict, "self.example.com.", dns.TypeA, false},
+		// Without strict-aa every response is authoritative.
+		{strict[1:], "nope.example.com.", dns.TypeA, true},
+		{strict[1:], "ext.example.com.", dns.TypeA, true},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options...)
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		if resp := exchange(n, m); resp.Authoritative != tc.aa {
+			t.Errorf("Test %d: expected AA %v for %s %s, got %v (%s)", i, tc.aa, tc.qname, dns.TypeToString[tc.qtype], resp.Authoritative, dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
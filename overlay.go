@@ -0,0 +1,74 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"time"
+)
+
+// applyOverlay returns base with the records of overlay applied: the records of a name and type in
+// the overlay replace those of base, records marked delete remove them and anything else is added.
+// The overlay's names are qualified against its own origin, which may differ from that of base.
+func applyOverlay(base, overlay DNSRecords) DNSRecords {
+	replaced := make(map[string]bool, len(overlay.Records))
+	for _, record := range overlay.Records {
+		replaced[record.kind()+" "+qualify(record.Name, overlay.Origin)] = true
+	}
+
+	merged := DNSRecords{Origin: base.Origin, Records: make([]DNSRecord, 0, len(base.Records))}
+	for _, record := range base.Records {
+		if !replaced[record.kind()+" "+qualify(record.Name, base.Origin)] {
+			merged.Records = append(merged.Records, record)
+		}
+	}
+	for _, record := range overlay.Records {
+		if record.Delete {
+			continue
+		}
+		record.Name = qualify(record.Name, overlay.Origin)
+		if record.Target != "" {
+			record.Target = qualify(record.Target, overlay.Origin)
+		}
+		merged.Records = append(merged.Records, record)
+	}
+	return merged
+}
+
+// checkDeletes returns an error for the first record of data, read from path, marked delete. Only
+// overlays can delete records.
+func checkDeletes(data DNSRecords, path string) error {
+	for _, record := range data.Records {
+		if record.Delete {
+			return fmt.Errorf("record %q in %q: delete is only allowed in an overlay", record.Name, path)
+		}
+	}
+	return nil
+}
+
+// reapplyOverlay swaps in the records of the file with its overlay applied again, after the overlay
+// was reloaded. The file itself isn't read again.
+func (f *Recordsfile) reapplyOverlay() {
+	f.reading.Lock()
+	defer f.reading.Unlock()
+
+	f.RLock()
+	base, loaded := f.base, f.loaded
+	f.RUnlock()
+	if !loaded {
+		return
+	}
+
+	records := applyOverlay(base, f.overlay.Records())
+	index := newIndex(records)
+
+	f.Lock()
+	f.records = records
+	f.index = index
+	f.version++
+	f.modified = time.Now()
+	f.Unlock()
+
+	recordCount.WithLabelValues(f.Path).Set(float64(len(records.Records)))
+	if f.onReload != nil {
+		f.onReload()
+	}
+}
@@ -0,0 +1,141 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	overlayBase = `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "www", "type": "TXT", "text": "base"},
+    {"name": "api", "ipaddress": "192.0.2.2"},
+    {"name": "old", "ipaddress": "192.0.2.3"}
+  ]
+}`
+	overlayPatch = `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "10.0.0.1"},
+    {"name": "new", "ipaddress": "10.0.0.2"},
+    {"name": "old", "ipaddress": "192.0.2.3", "delete": true}
+  ]
+}`
+)
+
+func TestSetupOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "dns.json", overlayBase)
+	overlay := writeFile(t, dir, "overlay.json", overlayPatch)
+	testParse(t, []parseTest{
+		{fmt.Sprintf("nightlightdns %s {\noverlay %s\n}", base, overlay), false},
+		{fmt.Sprintf("nightlightdns %s {\noverlay\n}", base), true},
+		{fmt.Sprintf("nightlightdns %s %s {\noverlay %s\n}", base, overlay, overlay), true},
+		{fmt.Sprintf("nightlightdns %s {\noverlay %s\nadmin 127.0.0.1:0 secret\n}", base, overlay), true},
+	})
+
+	// Only overlays delete records.
+	err := setupErr(t, overlayPatch)
+	if err == nil || !strings.Contains(err.Error(), "delete is only allowed in an overlay") {
+		t.Errorf("Expected a delete in the records file to fail, got %v", err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "dns.json", overlayBase)
+	overlay := writeFile(t, dir, "overlay.json", overlayPatch)
+	n := newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com {\noverlay %s\n}", base, overlay))
+
+	checkCases(t, n, []test.Case{
+		// The overlay replaces the records of a name and type, others of the name stay.
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 10.0.0.1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`www.example.com. 30 IN TXT "base"`)},
+		},
+		{
+			Qname: "api.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("api.example.com. 30 IN A 192.0.2.2")},
+		},
+		{
+			Qname: "new.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("new.example.com. 30 IN A 10.0.0.2")},
+		},
+		{
+			Qname: "old.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+
+	// Reloading the base applies the overlay again.
+	f := n.Store.(*JSONStore).Files[0]
+	writeFile(t, dir, "dns.json", strings.Replace(overlayBase, "192.0.2.2", "192.0.2.20", 1))
+	f.update()
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "api.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("api.example.com. 30 IN A 192.0.2.20")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 10.0.0.1")},
+		},
+		{
+			Qname: "old.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+
+	// So does reloading the overlay, without the delete the base record is back.
+	writeFile(t, dir, "overlay.json", `{"origin": "example.com.", "records": [{"name": "www", "ipaddress": "10.0.0.10"}]}`)
+	f.overlay.update()
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 10.0.0.10")},
+		},
+		{
+			Qname: "new.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			Qname: "old.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("old.example.com. 30 IN A 192.0.2.3")},
+		},
+	})
+}
+
+func TestApplyOverlay(t *testing.T) {
+	base := DNSRecords{Origin: "example.com.", Records: []DNSRecord{
+		{Name: "www", Ipaddress: "192.0.2.1"},
+		{Name: "mail", Type: "MX", Target: "mx", Preference: 10},
+	}}
+	// The overlay has an origin of its own.
+	overlay := DNSRecords{Origin: "sub.example.com.", Records: []DNSRecord{
+		{Name: "www", Ipaddress: "10.0.0.1"},
+		{Name: "mail.example.com.", Type: "MX", Target: "mx", Preference: 20},
+		{Name: "www.example.com.", Ipaddress: "192.0.2.1", Delete: true},
+	}}
+	got := []string{}
+	for _, record := range applyOverlay(base, overlay).Records {
+		got = append(got, fmt.Sprintf("%s %s %s%s", record.Name, record.kind(), record.Ipaddress, record.Target))
+	}
+	want := []string{
+		"www.sub.example.com. A 10.0.0.1",
+		"mail.example.com. MX mx.sub.example.com.",
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
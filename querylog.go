@@ -0,0 +1,109 @@
+package nightlightdns
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// Query log formats.
+const (
+	logPlain = "plain"
+	logJSON  = "json"
+)
+
+// queryLog is a single structured query log line.
+type queryLog struct {
+	Name     string  `json:"qname"`
+	Type     string  `json:"qtype"`
+	Client   string  `json:"client"`
+	Rcode    string  `json:"rcode"`
+	Answers  int     `json:"answers"`
+	Duration float64 `json:"duration"`
+}
+
+// newQueryLog returns the query log line of the query of state and the response recorded by rec,
+// or false if the query didn't get a response.
+func newQueryLog(state request.Request, rec *dnstest.Recorder, duration time.Duration) (queryLog, bool) {
+	if rec.Msg == nil {
+		return queryLog{}, false
+	}
+	return queryLog{
+		Name:     state.Name(),
+		Type:     state.Type(),
+		Client:   state.IP(),
+		Rcode:    dns.RcodeToString[rec.Msg.Rcode],
+		Answers:  len(rec.Msg.Answer),
+		Duration: duration.Seconds(),
+	}, true
+}
+
+// logQuery logs the query of state and the response recorded by rec, in the given format. Queries
+// that didn't get a response aren't logged.
+func logQuery(format string, state request.Request, rec *dnstest.Recorder, duration time.Duration) {
+	l, ok := newQueryLog(state, rec, duration)
+	if !ok {
+		return
+	}
+
+	if format == logJSON {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return
+		}
+		log.Info(string(b))
+		return
+	}
+	log.Infof("%s %s %s %s %d %s", l.Client, l.Type, l.Name, l.Rcode, l.Answers, duration)
+}
+
+// recentQuery is a query kept by recentQueries, with the time it was answered.
+type recentQuery struct {
+	Time time.Time `json:"time"`
+	queryLog
+}
+
+// recentQueries keeps the last queries in a ring buffer, for the admin API to list them.
+type recentQueries struct {
+	mu      sync.Mutex
+	entries []recentQuery
+	next    int
+	full    bool
+}
+
+// newRecentQueries returns a recentQueries keeping the last size queries.
+func newRecentQueries(size int) *recentQueries {
+	return &recentQueries{entries: make([]recentQuery, size)}
+}
+
+// add keeps the query of state and the response recorded by rec, evicting the oldest query once
+// the buffer is full.
+func (q *recentQueries) add(state request.Request, rec *dnstest.Recorder, duration time.Duration) {
+	l, ok := newQueryLog(state, rec, duration)
+	if !ok {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries[q.next] = recentQuery{Time: time.Now().UTC(), queryLog: l}
+	q.next++
+	if q.next == len(q.entries) {
+		q.next, q.full = 0, true
+	}
+}
+
+// list returns the kept queries, oldest first.
+func (q *recentQueries) list() []recentQuery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.full {
+		return append([]recentQuery{}, q.entries[:q.next]...)
+	}
+	return append(append([]recentQuery{}, q.entries[q.next:]...), q.entries[:q.next]...)
+}
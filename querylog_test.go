@@ -0,0 +1,132 @@
+package nightlightdns
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	golog "log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// captureLog returns what is logged while f runs.
+func captureLog(f func()) string {
+	var buf bytes.Buffer
+	golog.SetOutput(&buf)
+	defer golog.SetOutput(ioutil.Discard)
+	f()
+	return buf.String()
+}
+
+func TestQueryLogJSON(t *testing.T) {
+	n := newRecordsPlugin(t, testRecords, "log json")
+	tests := []struct {
+		qname string
+		qtype uint16
+		want  queryLog
+	}{
+		{"www.example.com.", dns.TypeA, queryLog{Name: "www.example.com.", Type: "A", Client: "10.240.0.1", Rcode: "NOERROR", Answers: 1}},
+		{"nope.example.com.", dns.TypeAAAA, queryLog{Name: "nope.example.com.", Type: "AAAA", Client: "10.240.0.1", Rcode: "NXDOMAIN"}},
+	}
+	for i, tc := range tests {
+		out := captureLog(func() {
+			m := new(dns.Msg)
+			m.SetQuestion(tc.qname, tc.qtype)
+			exchange(n, m)
+		})
+		start := strings.Index(out, "{")
+		if start < 0 {
+			t.Errorf("Test %d: expected a JSON log line, got %q", i, out)
+			continue
+		}
+		var got queryLog
+		if err := json.Unmarshal([]byte(strings.TrimSpace(out[start:])), &got); err != nil {
+			t.Errorf("Test %d: expected a JSON log line, got %q: %v", i, out, err)
+			continue
+		}
+		if got.Duration <= 0 {
+			t.Errorf("Test %d: expected a duration, got %v", i, got.Duration)
+		}
+		got.Duration = 0
+		if got != tc.want {
+			t.Errorf("Test %d: expected %+v, got %+v", i, tc.want, got)
+		}
+	}
+}
+
+func TestSetupQueryLogSize(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nadmin 127.0.0.1:0 secret\nquery-log-size 100\n}", false},
+		{"nightlightdns {\nquery-log-size 100\n}", true},
+		{"nightlightdns {\nadmin 127.0.0.1:0 secret\nquery-log-size 0\n}", true},
+		{"nightlightdns {\nadmin 127.0.0.1:0 secret\nquery-log-size many\n}", true},
+		{"nightlightdns {\nadmin 127.0.0.1:0 secret\nquery-log-size\n}", true},
+	})
+}
+
+func TestRecentQueries(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", testRecords)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com {\nadmin 127.0.0.1:0 secret\nquery-log-size 3\n}")
+	if err := n.admin.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.admin.Shutdown()
+	url := "http://" + n.admin.ln.Addr().String() + "/queries"
+
+	queries := []struct {
+		qname string
+		qtype uint16
+		want  queryLog
+	}{
+		{"www.example.com.", dns.TypeA, queryLog{Name: "www.example.com.", Type: "A", Client: "10.240.0.1", Rcode: "NOERROR", Answers: 1}},
+		{"nope.example.com.", dns.TypeA, queryLog{Name: "nope.example.com.", Type: "A", Client: "10.240.0.1", Rcode: "NXDOMAIN", Answers: 0}},
+		{"www.example.com.", dns.TypeAAAA, queryLog{Name: "www.example.com.", Type: "AAAA", Client: "10.240.0.1", Rcode: "NOERROR", Answers: 1}},
+		{"mail.example.com.", dns.TypeMX, queryLog{Name: "mail.example.com.", Type: "MX", Client: "10.240.0.1", Rcode: "NOERROR", Answers: 1}},
+		{"www.example.com.", dns.TypeTXT, queryLog{Name: "www.example.com.", Type: "TXT", Client: "10.240.0.1", Rcode: "NOERROR", Answers: 0}},
+	}
+	// After each query the buffer holds the last three queries, oldest first.
+	for i, q := range queries {
+		start := time.Now().UTC()
+		m := new(dns.Msg)
+		m.SetQuestion(q.qname, q.qtype)
+		exchange(n, m)
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []recentQuery{}
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+
+		first := 0
+		if i >= 3 {
+			first = i - 2
+		}
+		if len(got) != i+1-first {
+			t.Fatalf("Test %d: expected %d queries, got %d", i, i+1-first, len(got))
+		}
+		for j, entry := range got {
+			want := queries[first+j].want
+			entry.Duration = 0
+			if entry.queryLog != want {
+				t.Errorf("Test %d: expected query %d to be %+v, got %+v", i, j, want, entry.queryLog)
+			}
+		}
+		if last := got[len(got)-1].Time; last.Before(start.Add(-time.Second)) || last.After(time.Now().Add(time.Second)) {
+			t.Errorf("Test %d: expected the time of the last query around %s, got %s", i, start, last)
+		}
+	}
+}
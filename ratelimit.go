@@ -0,0 +1,79 @@
+package nightlightdns
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// rateLimitClients bounds the number of clients the rate limiter tracks. The least recently seen
+// clients are forgotten first.
+const rateLimitClients = 100000
+
+// rateLimiter limits the queries per second of every client with a token bucket per client address.
+type rateLimiter struct {
+	sync.Mutex
+
+	// rate is the number of tokens added per second, burst the size of a bucket.
+	rate, burst float64
+
+	size    int
+	clients map[string]*list.Element
+	lru     *list.List
+
+	// now is the clock the buckets refill by.
+	now func() time.Time
+}
+
+// bucket is the token bucket of a single client.
+type bucket struct {
+	client string
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a rate limiter allowing qps queries per second per client, with bursts of up
+// to burst queries.
+func newRateLimiter(qps, burst float64) *rateLimiter {
+	return &rateLimiter{
+		rate:    qps,
+		burst:   burst,
+		size:    rateLimitClients,
+		clients: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// allow reports whether client may send another query, taking a token from its bucket if so.
+func (l *rateLimiter) allow(client string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	e, ok := l.clients[client]
+	if !ok {
+		if l.lru.Len() >= l.size {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.clients, oldest.Value.(*bucket).client)
+		}
+		e = l.lru.PushFront(&bucket{client: client, tokens: l.burst, last: now})
+		l.clients[client] = e
+	} else {
+		l.lru.MoveToFront(e)
+	}
+
+	b := e.Value.(*bucket)
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
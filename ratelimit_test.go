@@ -0,0 +1,79 @@
+package nightlightdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupRateLimit(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nratelimit 10\n}", false},
+		{"nightlightdns {\nratelimit 0.5 5\n}", false},
+		{"nightlightdns {\nratelimit\n}", true},
+		{"nightlightdns {\nratelimit 0\n}", true},
+		{"nightlightdns {\nratelimit many\n}", true},
+		{"nightlightdns {\nratelimit 10 0\n}", true},
+		{"nightlightdns {\nratelimit 10 20 30\n}", true},
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	l := newRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		elapsed time.Duration // since the previous query
+		client  string
+		allowed bool
+	}{
+		// The burst is allowed, the query after it isn't.
+		{0, "192.0.2.1", true},
+		{0, "192.0.2.1", true},
+		{0, "192.0.2.1", true},
+		{0, "192.0.2.1", false},
+		// Other clients have buckets of their own.
+		{0, "192.0.2.2", true},
+		// Two queries per second refill the bucket, one after half a second.
+		{500 * time.Millisecond, "192.0.2.1", true},
+		{0, "192.0.2.1", false},
+		// The bucket never holds more than the burst.
+		{time.Minute, "192.0.2.1", true},
+		{0, "192.0.2.1", true},
+		{0, "192.0.2.1", true},
+		{0, "192.0.2.1", false},
+	}
+	for i, tc := range tests {
+		now = now.Add(tc.elapsed)
+		if allowed := l.allow(tc.client); allowed != tc.allowed {
+			t.Errorf("Test %d: expected allowed %t for %s, got %t", i, tc.allowed, tc.client, allowed)
+		}
+	}
+}
+
+func TestRateLimiterSize(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	l.size = 2
+	for _, client := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		l.allow(client)
+	}
+	if len(l.clients) != 2 || l.lru.Len() != 2 {
+		t.Errorf("Expected 2 tracked clients, got %d", len(l.clients))
+	}
+	if _, ok := l.clients["192.0.2.1"]; ok {
+		t.Error("Expected the least recently seen client to be forgotten")
+	}
+}
+
+func TestServeDNSRateLimit(t *testing.T) {
+	n := newRecordsPlugin(t, testRecords, "ratelimit 1 2")
+	for i, rcode := range []int{dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeRefused} {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		if resp := exchange(n, m); resp.Rcode != rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[rcode], dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
@@ -1,5 +1,25 @@
 package nightlightdns
 
-// Ready implements the ready.Readiness interface, once this flips to true CoreDNS
-// assumes this plugin is ready for queries; it is not checked again.
-func (n Nightlightdns) Ready() bool { return true }
+// Ready implements the ready.Readiness interface. The ready plugin asks until this returns true
+// and never again after, so it only tells when the plugin can first answer queries.
+//
+// The records files are loaded during setup, which fails if one can't be, so they are ready once
+// CoreDNS runs. A failed reload keeps the previous records and is reported by the
+// last_reload_error metric instead. The Consul backend is ready once its keys were read, which
+// happens in the background. Other stores are always ready. With stacked backends or views all of
+// them have to be ready.
+func (n Nightlightdns) Ready() bool {
+	for _, store := range n.allStores() {
+		switch s := store.(type) {
+		case *JSONStore:
+			if n.Zonefile == nil && !s.Ready() {
+				return false
+			}
+		case *ConsulBackend:
+			if !s.Ready() {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package nightlightdns
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReady(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dns.json")
+	n := Nightlightdns{Store: &JSONStore{Files: []*Recordsfile{{Path: path, format: formatJSON}}}}
+	f := n.Store.(*JSONStore).Files[0]
+
+	if err := f.readRecords(); err == nil {
+		t.Fatal("Expected an error reading a missing records file")
+	}
+	if n.Ready() {
+		t.Error("Expected not to be ready with a missing records file")
+	}
+
+	writeFile(t, dir, "dns.json", testRecords)
+	if err := f.readRecords(); err != nil {
+		t.Fatal(err)
+	}
+	if !n.Ready() {
+		t.Error("Expected to be ready once the records file was loaded")
+	}
+}
@@ -0,0 +1,654 @@
+package nightlightdns
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+	"sigs.k8s.io/yaml"
+)
+
+// DNSRecords is the top level structure of the records file.
+type DNSRecords struct {
+	// Origin is appended to record names that aren't fully qualified, i.e. don't end in a dot.
+	Origin  string      `json:"origin,omitempty"`
+	Records []DNSRecord `json:"records"`
+}
+
+// DNSRecord is a single named entry in the records file.
+type DNSRecord struct {
+	Name string `json:"name"`
+	// Type is the record type, records without a type are address records.
+	Type string `json:"type,omitempty"`
+	// Ipaddress and Ipv6address hold an address, or a comma separated list of them.
+	Ipaddress   string `json:"ipaddress,omitempty"`
+	Ipv6address string `json:"ipv6address,omitempty"`
+	// Target is the name a CNAME record points to, the mail exchange of an MX record, the host of
+	// an SRV record or the name server of an NS record.
+	Target string `json:"target,omitempty"`
+	// Preference is the preference of an MX record, lower values are preferred.
+	Preference uint16 `json:"preference,omitempty"`
+	// Priority, Weight and Port describe the service of an SRV record. For address records Weight
+	// biases which address is listed first.
+	Priority uint16 `json:"priority,omitempty"`
+	Weight   uint16 `json:"weight,omitempty"`
+	Port     *int64 `json:"port,omitempty"`
+	// Flag, Tag and Value make up a CAA record.
+	Flag  uint8  `json:"flag,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	Value string `json:"value,omitempty"`
+	// CPU and OS describe the host of an HINFO record.
+	CPU string `json:"cpu,omitempty"`
+	OS  string `json:"os,omitempty"`
+	// Usage, Selector, MatchingType and Certificate make up a TLSA record, the certificate
+	// association data is hex encoded.
+	Usage        uint8  `json:"usage,omitempty"`
+	Selector     uint8  `json:"selector,omitempty"`
+	MatchingType uint8  `json:"matching_type,omitempty"`
+	Certificate  string `json:"certificate,omitempty"`
+	// Algorithm, FingerprintType and Fingerprint make up an SSHFP record, the fingerprint is hex
+	// encoded.
+	Algorithm       uint8  `json:"algorithm,omitempty"`
+	FingerprintType uint8  `json:"fp_type,omitempty"`
+	Fingerprint     string `json:"fingerprint,omitempty"`
+	// Text holds the strings of a TXT record, a single string is accepted as well.
+	Text stringList `json:"text,omitempty"`
+	// TTL overrides the default TTL for this record when set.
+	TTL *int64 `json:"ttl,omitempty"`
+	// Subnets limits the record to clients in these CIDR subnets, as sent in the EDNS Client Subnet
+	// option. Records without subnets are served to everyone else.
+	Subnets []string `json:"subnets,omitempty"`
+	// Region tags an address record with the region of the region-map directive it serves. Clients
+	// in that region get the tagged records, others the untagged ones.
+	Region string `json:"region,omitempty"`
+	// ActiveFrom and ActiveTo limit the record to a time window, given as RFC 3339 timestamps or as
+	// daily HH:MM times in local time. Either may be left out.
+	ActiveFrom string `json:"active_from,omitempty"`
+	ActiveTo   string `json:"active_to,omitempty"`
+	// Disabled takes the record out of service without removing it, it's served as if it didn't exist.
+	Disabled bool `json:"disabled,omitempty"`
+	// Delete marks a record of an overlay that removes the records of its name and type from the
+	// records file it's applied to. Only the name and type are needed.
+	Delete bool `json:"delete,omitempty"`
+}
+
+// stringList is a list of strings that can also be written as a single JSON string.
+type stringList []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// kind returns the upper cased record type, address records that don't set a type are "A".
+func (r DNSRecord) kind() string {
+	if r.Type == "" {
+		return "A"
+	}
+	return strings.ToUpper(r.Type)
+}
+
+// isAddress reports whether r is an address record, serving A and AAAA queries.
+func (r DNSRecord) isAddress() bool {
+	k := r.kind()
+	return k == "A" || k == "AAAA"
+}
+
+// validate checks that r is a well formed record, origin is used to qualify its names. It returns
+// the first of its problems.
+func (r DNSRecord) validate(origin string) error {
+	if problems := r.problems(origin); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// problems returns everything that is wrong with r, origin is used to qualify its names.
+func (r DNSRecord) problems(origin string) []error {
+	problems := []error{}
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, a...))
+	}
+	if r.Name == "" {
+		add("record needs a name")
+	} else if _, ok := dns.IsDomainName(qualify(r.Name, origin)); !ok {
+		add("invalid name %q", r.Name)
+	}
+	if r.Delete {
+		return problems
+	}
+	if r.Target != "" {
+		if _, ok := dns.IsDomainName(qualify(r.Target, origin)); !ok {
+			add("invalid target %q", r.Target)
+		}
+	}
+	if r.TTL != nil && (*r.TTL < 0 || *r.TTL > math.MaxUint32) {
+		add("invalid ttl %d", *r.TTL)
+	}
+	for _, s := range r.Subnets {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			add("invalid subnet %q", s)
+		}
+	}
+	if _, err := r.parseWindow(); err != nil {
+		problems = append(problems, err)
+	}
+	switch r.kind() {
+	case "A", "AAAA":
+		if r.Ipaddress == "" && r.Ipv6address == "" {
+			add("address record needs an ipaddress or ipv6address")
+		}
+		for _, addr := range splitAddresses(r.Ipaddress) {
+			if net.ParseIP(addr) == nil {
+				add("invalid ipaddress %q", addr)
+			}
+		}
+		for _, addr := range splitAddresses(r.Ipv6address) {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				add("invalid ipv6address %q", addr)
+				continue
+			}
+			if ip.To4() != nil {
+				problems = append(problems, &FamilyMismatchError{Address: addr, Qtype: dns.TypeAAAA})
+			}
+		}
+	case "CNAME", "DNAME", "MX":
+		if r.Target == "" {
+			add("%s record needs a target", r.kind())
+		}
+	case "NS":
+		if r.Target == "" {
+			add("NS record needs a target")
+		}
+		if !dns.IsSubDomain(dns.Fqdn(origin), qualify(r.Name, origin)) {
+			add("NS record is outside of origin %q", origin)
+		}
+	case "SRV":
+		if r.Target == "" {
+			add("SRV record needs a target")
+		}
+		if r.Port == nil {
+			add("SRV record needs a port")
+		} else if *r.Port < 0 || *r.Port > math.MaxUint16 {
+			add("SRV record has invalid port %d, must be between 0 and 65535", *r.Port)
+		}
+	case "TXT":
+		if len(r.Text) == 0 {
+			add("TXT record needs text")
+		}
+	case "CAA":
+		switch r.Tag {
+		case "issue", "issuewild", "iodef":
+		default:
+			add("CAA record has invalid tag %q, must be issue, issuewild or iodef", r.Tag)
+		}
+	case "HINFO":
+		if r.CPU == "" {
+			add("HINFO record needs a cpu")
+		}
+	case "TLSA":
+		problems = append(problems, r.tlsaProblems()...)
+	case "SSHFP":
+		problems = append(problems, r.sshfpProblems()...)
+	default:
+		add("unsupported record type %q", r.Type)
+	}
+	return problems
+}
+
+// tlsaDigestSizes are the sizes of the certificate association data of the TLSA matching types
+// that hash it, SHA-256 and SHA-512.
+var tlsaDigestSizes = map[uint8]int{1: 32, 2: 64}
+
+// tlsaProblems checks the fields of a TLSA record, as defined in RFC 6698.
+func (r DNSRecord) tlsaProblems() []error {
+	problems := []error{}
+	if r.Usage > 3 {
+		problems = append(problems, fmt.Errorf("TLSA record has invalid usage %d, must be between 0 and 3", r.Usage))
+	}
+	if r.Selector > 1 {
+		problems = append(problems, fmt.Errorf("TLSA record has invalid selector %d, must be 0 or 1", r.Selector))
+	}
+	if r.MatchingType > 2 {
+		problems = append(problems, fmt.Errorf("TLSA record has invalid matching_type %d, must be between 0 and 2", r.MatchingType))
+	}
+	data, err := hex.DecodeString(r.Certificate)
+	switch size, ok := tlsaDigestSizes[r.MatchingType]; {
+	case err != nil:
+		problems = append(problems, fmt.Errorf("TLSA record has invalid certificate, must be hex encoded"))
+	case len(data) == 0:
+		problems = append(problems, fmt.Errorf("TLSA record needs a certificate"))
+	case ok && len(data) != size:
+		problems = append(problems, fmt.Errorf("TLSA record has a certificate of %d bytes, matching_type %d needs %d", len(data), r.MatchingType, size))
+	}
+	return problems
+}
+
+// sshfpAlgorithms are the SSH key algorithms of SSHFP records: RSA, DSA, ECDSA, Ed25519 and Ed448.
+var sshfpAlgorithms = map[uint8]bool{1: true, 2: true, 3: true, 4: true, 6: true}
+
+// sshfpDigestSizes are the sizes of the fingerprints of the SSHFP fingerprint types, SHA-1 and
+// SHA-256.
+var sshfpDigestSizes = map[uint8]int{1: 20, 2: 32}
+
+// sshfpProblems checks the fields of an SSHFP record, as defined in RFC 4255.
+func (r DNSRecord) sshfpProblems() []error {
+	problems := []error{}
+	if !sshfpAlgorithms[r.Algorithm] {
+		problems = append(problems, fmt.Errorf("SSHFP record has invalid algorithm %d, must be 1, 2, 3, 4 or 6", r.Algorithm))
+	}
+	size, ok := sshfpDigestSizes[r.FingerprintType]
+	if !ok {
+		problems = append(problems, fmt.Errorf("SSHFP record has invalid fp_type %d, must be 1 or 2", r.FingerprintType))
+	}
+	data, err := hex.DecodeString(r.Fingerprint)
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Errorf("SSHFP record has invalid fingerprint, must be hex encoded"))
+	case ok && len(data) != size:
+		problems = append(problems, fmt.Errorf("SSHFP record has a fingerprint of %d bytes, fp_type %d needs %d", len(data), r.FingerprintType, size))
+	}
+	return problems
+}
+
+// FamilyMismatchError reports an address of the wrong family for the query type it would answer,
+// such as an IPv6 literal in the ipaddress of a record queried for A.
+type FamilyMismatchError struct {
+	Address string
+	Qtype   uint16
+}
+
+// Error implements error.
+func (e *FamilyMismatchError) Error() string {
+	return fmt.Sprintf("address %q can't answer %s queries", e.Address, dns.TypeToString[e.Qtype])
+}
+
+// mismatches returns an error for every address of the record that is of the wrong family for
+// qtype, and gets skipped by addresses.
+func (r DNSRecord) mismatches(qtype uint16) []error {
+	errs := []error{}
+	switch qtype {
+	case dns.TypeA:
+		for _, addr := range splitAddresses(r.Ipaddress) {
+			if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+				errs = append(errs, &FamilyMismatchError{Address: addr, Qtype: qtype})
+			}
+		}
+	case dns.TypeAAAA:
+		for _, addr := range splitAddresses(r.Ipv6address) {
+			if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+				errs = append(errs, &FamilyMismatchError{Address: addr, Qtype: qtype})
+			}
+		}
+	}
+	return errs
+}
+
+// addresses returns the addresses of the record for the given query type, none when the record has
+// no address of that family. For AAAA queries the ipv6address field is preferred, but IPv6 literals
+// in ipaddress are still honored so older records files keep working.
+func (r DNSRecord) addresses(qtype uint16) []net.IP {
+	ips := []net.IP{}
+	switch qtype {
+	case dns.TypeA:
+		for _, addr := range splitAddresses(r.Ipaddress) {
+			if ip := net.ParseIP(addr); ip.To4() != nil {
+				ips = append(ips, ip.To4())
+			}
+		}
+	case dns.TypeAAAA:
+		for _, addr := range splitAddresses(r.Ipv6address) {
+			if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) > 0 {
+			break
+		}
+		for _, addr := range splitAddresses(r.Ipaddress) {
+			if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// splitAddresses splits an address field holding a comma separated list of addresses.
+func splitAddresses(s string) []string {
+	if s == "" {
+		return nil
+	}
+	addrs := strings.Split(s, ",")
+	for i := range addrs {
+		addrs[i] = strings.TrimSpace(addrs[i])
+	}
+	return addrs
+}
+
+// Records file formats.
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+)
+
+// fileFormat returns the format of the records file at path, guessed from its extension. Anything
+// that isn't YAML is taken to be JSON.
+func fileFormat(path string) string {
+	if gzipped(path) {
+		path = path[:len(path)-len(".gz")]
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	}
+	return formatJSON
+}
+
+// gzipped reports whether the records file at path is taken to be gzip compressed, which is the
+// case for files ending in .gz.
+func gzipped(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".gz")
+}
+
+// loadRecords parses file, the contents of the records file at path. The format is either
+// formatJSON or formatYAML, the YAML form uses the same field names as the JSON one. Files that
+// don't set an origin get origin. Compressed files are decompressed first, and environment
+// variables in the records are handled according to env, see expandEnv. CNAME chains longer than
+// depth are warned about.
+func loadRecords(file []byte, path, format, origin string, compressed bool, env envMode, depth int) (DNSRecords, error) {
+	data, err := unmarshalRecords(file, path, format, compressed, env)
+	if err != nil {
+		return data, err
+	}
+	if data.Origin == "" {
+		data.Origin = origin
+	}
+	data.Origin = toASCII(strings.ToLower(data.Origin))
+	for _, record := range data.Records {
+		if err := record.validate(data.Origin); err != nil {
+			return data, fmt.Errorf("record %q in %q: %v", record.Name, path, err)
+		}
+	}
+	for _, err := range append(duplicates(data), cnameLoops(data, depth)...) {
+		log.Warningf("%s: %v", path, err)
+	}
+	return data, nil
+}
+
+// parseRecords reads and unmarshals the records file at path, without validating the records.
+func parseRecords(path, format string, compressed bool, env envMode) (DNSRecords, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return DNSRecords{}, err
+	}
+	return unmarshalRecords(file, path, format, compressed, env)
+}
+
+// unmarshalRecords unmarshals file, the contents of the records file at path, without validating
+// the records.
+func unmarshalRecords(file []byte, path, format string, compressed bool, env envMode) (DNSRecords, error) {
+	data := DNSRecords{}
+	var err error
+	if compressed {
+		if file, err = gunzip(file); err != nil {
+			return data, fmt.Errorf("unable to decompress records file %q: %v", path, err)
+		}
+	}
+	unmarshal := json.Unmarshal
+	if format == formatYAML {
+		unmarshal = func(b []byte, v interface{}) error { return yaml.Unmarshal(b, v) }
+	}
+	if err := unmarshal(file, &data); err != nil {
+		return data, fmt.Errorf("unable to parse records file %q: %v", path, err)
+	}
+	if err := expandEnv(&data, path, env); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// gunzip returns the decompressed contents of the gzip stream b. A truncated or corrupted stream
+// fails the checksum or length check at its end.
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// envMode is how references to environment variables in the records are handled.
+type envMode int
+
+const (
+	// envOff leaves them as they are, the default.
+	envOff envMode = iota
+	// envExpand replaces them with their values, undefined variables are logged and replaced with
+	// the empty string.
+	envExpand
+	// envStrict replaces them with their values, undefined variables fail the load.
+	envStrict
+)
+
+// envVar matches a reference to an environment variable, ${VAR}.
+var envVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the references to environment variables in the addresses and targets of the
+// records of data, read from path, with their values. Other fields are left alone, so a $ in a TXT
+// or CAA value or a key is never touched.
+func expandEnv(data *DNSRecords, path string, env envMode) error {
+	if env == envOff {
+		return nil
+	}
+	undefined := []string{}
+	expand := func(s string) string {
+		return envVar.ReplaceAllStringFunc(s, func(ref string) string {
+			name := envVar.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				undefined = append(undefined, name)
+			}
+			return value
+		})
+	}
+	for i := range data.Records {
+		record := &data.Records[i]
+		record.Ipaddress = expand(record.Ipaddress)
+		record.Ipv6address = expand(record.Ipv6address)
+		record.Target = expand(record.Target)
+	}
+	if len(undefined) == 0 {
+		return nil
+	}
+	if env == envStrict {
+		return fmt.Errorf("undefined environment variable %q in %q", undefined[0], path)
+	}
+	for _, name := range undefined {
+		log.Warningf("Undefined environment variable %q in %s", name, path)
+	}
+	return nil
+}
+
+// ValidateFile checks the records file at path without serving it, for example in CI before a
+// rollout. A file that doesn't set an origin gets origin, and references to environment variables
+// are only replaced with expandEnv set, like the plugin does with expand-env. It returns every
+// problem found in the records: invalid records, duplicates, CNAMEs next to other records and CNAME
+// loops. The error is set when the file can't be read or parsed at all.
+func ValidateFile(path, origin string, expandEnv bool) ([]error, error) {
+	env := envOff
+	if expandEnv {
+		env = envExpand
+	}
+	data, err := parseRecords(path, fileFormat(path), gzipped(path), env)
+	if err != nil {
+		return nil, err
+	}
+	if data.Origin == "" {
+		data.Origin = origin
+	}
+	data.Origin = toASCII(strings.ToLower(data.Origin))
+
+	problems := []error{}
+	for i, record := range data.Records {
+		for _, err := range record.problems(data.Origin) {
+			problems = append(problems, fmt.Errorf("record %d (%q): %v", i+1, record.Name, err))
+		}
+	}
+	problems = append(problems, duplicates(data)...)
+	return append(problems, cnameLoops(data, defaultCNAMEDepth)...), nil
+}
+
+// duplicates returns an error for records that are listed more than once, and for names that have
+// a CNAME next to other records. Neither is fatal, but both are most likely mistakes.
+func duplicates(data DNSRecords) []error {
+	problems := []error{}
+	seen := make(map[string]bool)
+	kinds := make(map[string]map[string]bool)
+	names := []string{}
+	for _, record := range data.Records {
+		name := qualify(record.Name, data.Origin)
+		record.Name = name
+		b, _ := json.Marshal(record)
+		key := string(b)
+		if seen[key] {
+			problems = append(problems, fmt.Errorf("duplicate %s record for %q", record.kind(), name))
+		}
+		seen[key] = true
+
+		if kinds[name] == nil {
+			kinds[name] = make(map[string]bool)
+			names = append(names, name)
+		}
+		kinds[name][record.kind()] = true
+	}
+	for _, name := range names {
+		if k := kinds[name]; k["CNAME"] && len(k) > 1 {
+			problems = append(problems, fmt.Errorf("name %q has a CNAME and other records, only the CNAME is served", name))
+		}
+	}
+	return problems
+}
+
+// cnameLoops returns an error for every chain of CNAMEs within data that loops back on itself or is
+// more than depth CNAMEs long, defaultCNAMEDepth if zero. Queries for such names are answered with
+// SERVFAIL.
+func cnameLoops(data DNSRecords, depth int) []error {
+	if depth == 0 {
+		depth = defaultCNAMEDepth
+	}
+	targets := make(map[string]string)
+	names := []string{}
+	for _, record := range data.Records {
+		if record.Disabled || record.kind() != "CNAME" {
+			continue
+		}
+		name := qualify(record.Name, data.Origin)
+		if _, ok := targets[name]; !ok {
+			names = append(names, name)
+		}
+		targets[name] = qualify(record.Target, data.Origin)
+	}
+
+	problems := []error{}
+	reported := make(map[string]bool)
+	for _, name := range names {
+		if reported[name] {
+			continue
+		}
+		chain := []string{name}
+		seen := map[string]bool{name: true}
+		for target, ok := targets[name]; ok; target, ok = targets[target] {
+			if seen[target] {
+				problems = append(problems, fmt.Errorf("CNAME loop %s", strings.Join(append(chain, target), " -> ")))
+				for _, n := range chain {
+					reported[n] = true
+				}
+				break
+			}
+			if len(chain) > depth {
+				problems = append(problems, fmt.Errorf("CNAME chain from %q is longer than %d", name, depth))
+				break
+			}
+			chain = append(chain, target)
+			seen[target] = true
+		}
+	}
+	return problems
+}
+
+// qualifyRecords returns the valid records of data with their names and targets qualified against
+// its origin. Invalid records are logged, mentioning source, and left out.
+func qualifyRecords(data DNSRecords, source string) []DNSRecord {
+	records := make([]DNSRecord, 0, len(data.Records))
+	for _, record := range data.Records {
+		if record.Disabled {
+			continue
+		}
+		if err := record.validate(data.Origin); err != nil {
+			log.Warningf("Skipping invalid record %q from %s: %v", record.Name, source, err)
+			continue
+		}
+		record.Name = qualify(record.Name, data.Origin)
+		if record.Target != "" {
+			record.Target = qualify(record.Target, data.Origin)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// qualify returns the lowercased, fully qualified form of name, with internationalized labels in
+// their xn-- form. Names ending in a dot are absolute, "@" stands for the origin itself and any
+// other name is taken relative to origin.
+func qualify(name, origin string) string {
+	origin = dns.Fqdn(origin)
+	switch {
+	case name == "@":
+		name = origin
+	case dns.IsFqdn(name):
+	case origin == ".":
+		name = dns.Fqdn(name)
+	default:
+		name = dns.Fqdn(name) + origin
+	}
+	return toASCII(strings.ToLower(name))
+}
+
+// toASCII returns name with its Unicode labels converted to A-labels (xn--), the form in which they
+// are queried. Names that can't be converted are returned unchanged.
+func toASCII(name string) string {
+	for i := 0; i < len(name); i++ {
+		if name[i] >= utf8.RuneSelf {
+			if ascii, err := idna.ToASCII(name); err == nil {
+				return ascii
+			}
+			return name
+		}
+	}
+	return name
+}
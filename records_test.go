@@ -0,0 +1,429 @@
+package nightlightdns
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		record    DNSRecord
+		shouldErr bool
+	}{
+		{DNSRecord{Name: "www", Ipaddress: "192.0.2.1"}, false},
+		{DNSRecord{Name: "www", Ipaddress: "192.0.2.1, 192.0.2.2"}, false},
+		{DNSRecord{Name: "www", Ipv6address: "2001:db8::1"}, false},
+		{DNSRecord{Name: "www", Ipaddress: "192.0.2.256"}, true},
+		{DNSRecord{Name: "www", Ipaddress: "not-an-ip"}, true},
+		{DNSRecord{Name: "www", Ipaddress: "192.0.2.1, 192.0.2"}, true},
+		{DNSRecord{Name: "www", Ipv6address: "2001:db8::g"}, true},
+		{DNSRecord{Name: "www"}, true},
+		{DNSRecord{Ipaddress: "192.0.2.1"}, true},
+		{DNSRecord{Name: "@", Type: "CAA", Tag: "issue", Value: "letsencrypt.org"}, false},
+		{DNSRecord{Name: "@", Type: "CAA", Tag: "issuewild", Value: ";"}, false},
+		{DNSRecord{Name: "@", Type: "CAA", Tag: "iodef", Value: "mailto:security@example.com"}, false},
+		{DNSRecord{Name: "@", Type: "CAA", Tag: "issuer", Value: "letsencrypt.org"}, true},
+		{DNSRecord{Name: "@", Type: "CAA", Value: "letsencrypt.org"}, true},
+	}
+	for i, tc := range tests {
+		err := tc.record.validate("example.com.")
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error for %+v, got none", i, tc.record)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: expected no error for %+v, got: %v", i, tc.record, err)
+		}
+	}
+}
+
+func TestSetupMalformedAddress(t *testing.T) {
+	err := setupErr(t, `{"origin": "example.com.", "records": [{"name": "www", "ipaddress": "192.0.2.1.5"}]}`)
+	if err == nil {
+		t.Fatal("Expected an error for a malformed address, got none")
+	}
+}
+
+func TestYAML(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.yaml", `origin: example.com.
+records:
+  - name: www
+    ipaddress: 192.0.2.1
+  - name: mail
+    type: MX
+    target: mx
+    preference: 10
+`)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "mail.example.com.", Qtype: dns.TypeMX,
+			Answer: []dns.RR{test.MX("mail.example.com. 30 IN MX 10 mx.example.com.")},
+		},
+	})
+}
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		records   string
+		shouldErr bool
+		problems  []string // a part of each of the problems expected, in order
+	}{
+		{testRecords, false, nil},
+		{`{"origin": "example.com.", "records": [`, true, nil},
+		{`{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "bad", "ipaddress": "192.0.2.256", "ttl": -1},
+    {"name": "bad..name", "ipaddress": "192.0.2.2"},
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "a", "type": "CNAME", "target": "b"},
+    {"name": "b", "type": "CNAME", "target": "a"}
+  ]
+}`, false, []string{
+			`record 2 ("bad"): invalid ttl -1`,
+			`record 2 ("bad"): invalid ipaddress "192.0.2.256"`,
+			`record 3 ("bad..name"): invalid name`,
+			`duplicate A record for "www.example.com."`,
+			"CNAME loop a.example.com. -> b.example.com. -> a.example.com.",
+		}},
+	}
+	for i, tc := range tests {
+		path := writeFile(t, t.TempDir(), "dns.json", tc.records)
+		problems, err := ValidateFile(path, "", false)
+		if tc.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t, got: %v", i, tc.shouldErr, err)
+			continue
+		}
+		if len(problems) != len(tc.problems) {
+			t.Errorf("Test %d: expected %d problems, got %d: %v", i, len(tc.problems), len(problems), problems)
+			continue
+		}
+		for j, want := range tc.problems {
+			if !strings.Contains(problems[j].Error(), want) {
+				t.Errorf("Test %d: expected problem %d to mention %q, got: %v", i, j, want, problems[j])
+			}
+		}
+	}
+}
+
+func TestValidateFileOptions(t *testing.T) {
+	t.Setenv("NIGHTLIGHTDNS_TEST_ADDRESS", "192.0.2.1")
+	records := `{"records": [
+  {"name": "www", "ipaddress": "${NIGHTLIGHTDNS_TEST_ADDRESS}"},
+  {"name": "alias", "type": "CNAME", "target": "www"},
+  {"name": "alias", "ipaddress": "192.0.2.2"}
+]}`
+	tests := []struct {
+		origin   string
+		expand   bool
+		problems []string
+	}{
+		// Without expand-env the reference is taken as the address.
+		{"example.com.", false, []string{
+			`record 1 ("www"): invalid ipaddress "${NIGHTLIGHTDNS_TEST_ADDRESS}"`,
+			`name "alias.example.com." has a CNAME and other records`,
+		}},
+		{"Example.ORG", true, []string{
+			`name "alias.example.org." has a CNAME and other records`,
+		}},
+	}
+	for i, tc := range tests {
+		path := writeFile(t, t.TempDir(), "dns.json", records)
+		problems, err := ValidateFile(path, tc.origin, tc.expand)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		if len(problems) != len(tc.problems) {
+			t.Errorf("Test %d: expected %d problems, got %d: %v", i, len(tc.problems), len(problems), problems)
+			continue
+		}
+		for j, want := range tc.problems {
+			if !strings.Contains(problems[j].Error(), want) {
+				t.Errorf("Test %d: expected problem %d to mention %q, got: %v", i, j, want, problems[j])
+			}
+		}
+	}
+}
+
+func TestSetupOrigin(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\norigin example.com.\n}", false},
+		{"nightlightdns {\norigin example.com\n}", false},
+		{"nightlightdns {\norigin\n}", true},
+		{"nightlightdns {\norigin example..com\n}", true},
+	})
+}
+
+func TestOrigin(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", `{
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "@", "ipaddress": "192.0.2.2"},
+    {"name": "api.other.com.", "ipaddress": "192.0.2.3"}
+  ]
+}`)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com other.com {\norigin example.com.\n}")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("example.com. 30 IN A 192.0.2.2")},
+		},
+		{
+			Qname: "www.other.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		// Names ending in a dot are absolute.
+		{
+			Qname: "api.other.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("api.other.com. 30 IN A 192.0.2.3")},
+		},
+	})
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("APP_IP", "192.0.2.7")
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "app", "ipaddress": "${APP_IP}"},
+    {"name": "www", "type": "TXT", "text": "costs ${APP_IP}"}
+  ]
+}`
+	tests := []struct {
+		options string
+		tc      test.Case
+	}{
+		{"expand-env", test.Case{
+			Qname: "app.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("app.example.com. 30 IN A 192.0.2.7")},
+		}},
+		// Only addresses and targets are expanded.
+		{"expand-env", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`www.example.com. 30 IN TXT "costs ${APP_IP}"`)},
+		}},
+		{"expand-env strict", test.Case{
+			Qname: "app.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("app.example.com. 30 IN A 192.0.2.7")},
+		}},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options)
+		if err := test.SortAndCheck(exchange(n, tc.tc.Msg()), tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestExpandEnvErrors(t *testing.T) {
+	t.Setenv("APP_IP", "192.0.2.7")
+	tests := []struct {
+		address   string
+		options   string
+		shouldErr bool
+	}{
+		// Without expand-env the reference is no address.
+		{"${APP_IP}", "", true},
+		// Undefined variables are replaced with nothing, unless strict.
+		{"192.0.2.${NIGHTLIGHTDNS_UNDEFINED}8", "expand-env", false},
+		{"192.0.2.${NIGHTLIGHTDNS_UNDEFINED}8", "expand-env strict", true},
+		{"${APP_IP}", "expand-env lenient", true},
+	}
+	for i, tc := range tests {
+		path := writeFile(t, t.TempDir(), "dns.json", `{"origin": "example.com.", "records": [{"name": "app", "ipaddress": "`+tc.address+`"}]}`)
+		err := setup(caddy.NewTestController("dns", "nightlightdns "+path+" example.com {\n"+tc.options+"\n}"))
+		if tc.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t for %q with %q, got: %v", i, tc.shouldErr, tc.address, tc.options, err)
+		}
+	}
+}
+
+func TestIDN(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "bücher", "ipaddress": "192.0.2.1"},
+    {"name": "www.münchen.example.com.", "ipaddress": "192.0.2.2"},
+    {"name": "alias", "type": "CNAME", "target": "bücher"}
+  ]
+}`)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "xn--bcher-kva.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("xn--bcher-kva.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "www.xn--mnchen-3ya.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.xn--mnchen-3ya.example.com. 30 IN A 192.0.2.2")},
+		},
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("alias.example.com. 30 IN CNAME xn--bcher-kva.example.com."),
+				test.A("xn--bcher-kva.example.com. 30 IN A 192.0.2.1"),
+			},
+		},
+		{
+			Qname: "bucher.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+}
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		name, ascii string
+	}{
+		{"www.example.com.", "www.example.com."},
+		{"bücher.example.com.", "xn--bcher-kva.example.com."},
+		{"xn--bcher-kva.example.com.", "xn--bcher-kva.example.com."},
+		{"日本.example.", "xn--wgv71a.example."},
+	}
+	for i, tc := range tests {
+		if got := toASCII(tc.name); got != tc.ascii {
+			t.Errorf("Test %d: expected %s for %s, got %s", i, tc.ascii, tc.name, got)
+		}
+	}
+}
+
+// gzipData returns data gzip compressed.
+func gzipData(t *testing.T, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCompressed(t *testing.T) {
+	compressed := gzipData(t, testRecords)
+	tests := []struct {
+		file, content, options string
+		shouldErr              bool
+	}{
+		{"dns.json.gz", compressed, "", false},
+		{"dns.JSON.GZ", compressed, "", false},
+		{"records", compressed, "compressed\nformat json", false},
+		{"dns.json.gz", compressed[:len(compressed)/2], "", true},
+		{"dns.json.gz", testRecords, "", true},
+		{"dns.json", testRecords, "compressed", true},
+	}
+	for i, tc := range tests {
+		path := writeFile(t, t.TempDir(), tc.file, tc.content)
+		corefile := "nightlightdns " + path + " example.com {\n" + tc.options + "\n}"
+		err := setup(caddy.NewTestController("dns", corefile))
+		if tc.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %t for %s, got: %v", i, tc.shouldErr, tc.file, err)
+			continue
+		}
+		if err == nil && !resolves(newTestPlugin(t, corefile), "www.example.com.", "192.0.2.1") {
+			t.Errorf("Test %d: expected www.example.com. to resolve from %s", i, tc.file)
+		}
+	}
+}
+
+func TestCNAMELoops(t *testing.T) {
+	long := `{"name": "c0", "type": "CNAME", "target": "c1"}`
+	for i := 1; i <= defaultCNAMEDepth; i++ {
+		long += fmt.Sprintf(`, {"name": "c%d", "type": "CNAME", "target": "c%d"}`, i, i+1)
+	}
+	tests := []struct {
+		records  string
+		problems []string
+	}{
+		{`{"name": "www", "type": "CNAME", "target": "app"}, {"name": "app", "ipaddress": "192.0.2.1"}`, nil},
+		// Targets outside the local data end the chain.
+		{`{"name": "www", "type": "CNAME", "target": "www.example.net."}`, nil},
+		{`{"name": "self", "type": "CNAME", "target": "self"}`, []string{
+			"CNAME loop self.example.com. -> self.example.com.",
+		}},
+		{`{"name": "a", "type": "CNAME", "target": "b"}, {"name": "b", "type": "CNAME", "target": "c"}, {"name": "c", "type": "CNAME", "target": "a"}`, []string{
+			"CNAME loop a.example.com. -> b.example.com. -> c.example.com. -> a.example.com.",
+		}},
+		// Names leading into a loop report it too.
+		{`{"name": "www", "type": "CNAME", "target": "a"}, {"name": "a", "type": "CNAME", "target": "a"}`, []string{
+			"CNAME loop www.example.com. -> a.example.com. -> a.example.com.",
+		}},
+		{`{"name": "a", "type": "CNAME", "target": "a", "disabled": true}`, nil},
+		{long, []string{
+			fmt.Sprintf("CNAME chain from \"c0.example.com.\" is longer than %d", defaultCNAMEDepth),
+		}},
+	}
+	for i, tc := range tests {
+		var data DNSRecords
+		if err := json.Unmarshal([]byte(`{"origin": "example.com.", "records": [`+tc.records+`]}`), &data); err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		problems := cnameLoops(data, 0)
+		if len(problems) != len(tc.problems) {
+			t.Errorf("Test %d: expected %d problems, got %v", i, len(tc.problems), problems)
+			continue
+		}
+		for j, problem := range problems {
+			if problem.Error() != tc.problems[j] {
+				t.Errorf("Test %d: expected %q, got %q", i, tc.problems[j], problem)
+			}
+		}
+	}
+}
+
+func TestLoadWarnsCNAMELoop(t *testing.T) {
+	var err error
+	out := captureLog(func() {
+		err = setupErr(t, `{"origin": "example.com.", "records": [{"name": "self", "type": "CNAME", "target": "self"}]}`)
+	})
+	if err != nil {
+		t.Fatalf("Expected a loop to still load, got: %v", err)
+	}
+	if !strings.Contains(out, "CNAME loop self.example.com. -> self.example.com.") {
+		t.Errorf("Expected a warning about the loop, got %q", out)
+	}
+}
+
+func TestLoadWarnsCNAMEDepth(t *testing.T) {
+	records := `{"origin": "example.com.", "records": [
+  {"name": "a", "type": "CNAME", "target": "b"},
+  {"name": "b", "type": "CNAME", "target": "c"},
+  {"name": "c", "type": "CNAME", "target": "d"}
+]}`
+	tests := []struct {
+		options string
+		warns   bool
+	}{
+		{"", false},
+		{"cname-depth 3", false},
+		{"cname-depth 2", true},
+	}
+	for i, tc := range tests {
+		path := writeFile(t, t.TempDir(), "dns.json", records)
+		out := captureLog(func() {
+			newTestPlugin(t, "nightlightdns "+path+" example.com {\n"+tc.options+"\n}")
+		})
+		if warns := strings.Contains(out, `CNAME chain from "a.example.com." is longer than 2`); warns != tc.warns {
+			t.Errorf("Test %d: expected a warning %t, got %q", i, tc.warns, out)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package nightlightdns
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/miekg/dns"
+	"sigs.k8s.io/yaml"
+)
+
+// Recordsfile holds the records parsed from the records file and keeps them current when the file
+// changes on disk. The records and their index are only replaced as a whole, under the lock, so
+// concurrent lookups see either the old or the new records.
+type Recordsfile struct {
+	sync.RWMutex
+
+	// reading serializes reloads, so a slow read can't swap in records older than a faster one that
+	// started after it.
+	reading sync.Mutex
+
+	// Path is the path of the records file.
+	Path string
+
+	// format is the format of Path, formatJSON or formatYAML.
+	format string
+
+	// origin is the origin of Path if it doesn't set one itself.
+	origin string
+
+	// zones are the zones of the plugin, NS records outside of them are rejected.
+	zones []string
+
+	// compressed is set if Path is gzip compressed.
+	compressed bool
+
+	// env is how references to environment variables in the records of Path are handled.
+	env envMode
+
+	// depth is the number of CNAMEs followed for the records of Path, defaultCNAMEDepth if zero.
+	depth int
+
+	// overlay, if set, is applied on top of the records of Path. isOverlay is set on the overlay
+	// itself, it may delete records.
+	overlay   *Recordsfile
+	isOverlay bool
+
+	// base are the records of Path before the overlay was applied.
+	base DNSRecords
+
+	// records are the records from the last successful parse of Path.
+	records DNSRecords
+
+	// index holds the lookup tables for records, it is rebuilt together with records.
+	index *index
+
+	// hash is the SHA-256 of the contents of Path the records were last loaded from, or of the JSON
+	// form of the records set with setRecords.
+	hash [sha256.Size]byte
+
+	// version is incremented and modified set whenever records are replaced.
+	version  uint64
+	modified time.Time
+
+	// mtime and size of Path when it was last loaded, used to skip polls of an unchanged file.
+	mtime time.Time
+	size  int64
+
+	// reload is the interval at which Path is polled for changes, zero disables polling.
+	reload time.Duration
+
+	// onReload, if set, is called whenever the records changed, after a reload or through
+	// setRecords.
+	onReload func()
+
+	// loaded is set once Path was read successfully.
+	loaded bool
+
+	watcher *fsnotify.Watcher
+
+	// cancel stops the goroutines started by start, done waits for them to exit.
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// Records returns the currently loaded records. Reloads replace the data as a whole, so the returned
+// value is a consistent snapshot that is safe to use without holding the lock.
+func (f *Recordsfile) Records() DNSRecords {
+	f.RLock()
+	defer f.RUnlock()
+	return f.records
+}
+
+// snapshot returns the currently loaded records, their version and the time they were loaded. The
+// version changes whenever the records do.
+func (f *Recordsfile) snapshot() (DNSRecords, uint64, time.Time) {
+	f.RLock()
+	defer f.RUnlock()
+	return f.records, f.version, f.modified
+}
+
+// LookupName returns the records with the given fully qualified name, or those of a matching
+// wildcard. DNS names are case-insensitive, so the name is compared against the lowercased record
+// names.
+func (f *Recordsfile) LookupName(name string) []DNSRecord {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	f.RLock()
+	defer f.RUnlock()
+	if f.index == nil {
+		return nil
+	}
+	return f.index.lookup(name)
+}
+
+// lookupExact returns the records with the given fully qualified name, ignoring wildcards.
+func (f *Recordsfile) lookupExact(name string) []DNSRecord {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	f.RLock()
+	defer f.RUnlock()
+	if f.index == nil {
+		return nil
+	}
+	return f.index.names[name]
+}
+
+// exists reports whether the fully qualified name exists in the tree formed by the records, as the
+// name of a record or as an empty non-terminal: an ancestor of such a name without records itself.
+func (f *Recordsfile) exists(name string) bool {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	f.RLock()
+	defer f.RUnlock()
+	if f.index == nil {
+		return false
+	}
+	return f.index.nodes[name]
+}
+
+// names returns the names of all records, sorted.
+func (f *Recordsfile) names() []string {
+	f.RLock()
+	defer f.RUnlock()
+	if f.index == nil {
+		return nil
+	}
+	names := make([]string, 0, len(f.index.names))
+	for name := range f.index.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupAddr returns the names that have the address addr.
+func (f *Recordsfile) LookupAddr(addr string) []string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	f.RLock()
+	defer f.RUnlock()
+	if f.index == nil {
+		return nil
+	}
+	return f.index.addrs[ip.String()]
+}
+
+// Ready reports whether the records file was loaded.
+func (f *Recordsfile) Ready() bool {
+	f.RLock()
+	defer f.RUnlock()
+	return f.loaded
+}
+
+// readRecords parses the records file and swaps in the new data. On error the previously loaded
+// records are left untouched.
+func (f *Recordsfile) readRecords() error {
+	f.reading.Lock()
+	defer f.reading.Unlock()
+
+	stat, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return err
+	}
+
+	// Touching the file, or rewriting it with the same contents, doesn't need parsing it again.
+	sum := sha256.Sum256(file)
+	f.Lock()
+	if f.loaded && sum == f.hash {
+		f.mtime = stat.ModTime()
+		f.size = stat.Size()
+		f.Unlock()
+		recordsFileBytes.WithLabelValues(f.Path).Set(float64(stat.Size()))
+		lastReloadError.WithLabelValues(f.Path).Set(0)
+		log.Debugf("Contents of %s unchanged, keeping the loaded records", f.Path)
+		return nil
+	}
+	f.Unlock()
+
+	records, err := loadRecords(file, f.Path, f.format, f.origin, f.compressed, f.env, f.depth)
+	if err != nil {
+		return err
+	}
+	if !f.isOverlay {
+		if err := checkDeletes(records, f.Path); err != nil {
+			return err
+		}
+	}
+	if err := checkNS(records, f.zones, f.Path); err != nil {
+		return err
+	}
+	base := records
+	if f.overlay != nil {
+		records = applyOverlay(records, f.overlay.Records())
+	}
+
+	index := newIndex(records)
+
+	f.Lock()
+	previous := f.hash
+	f.base = base
+	f.records = records
+	f.index = index
+	f.hash = sum
+	f.version++
+	f.modified = time.Now()
+	f.mtime = stat.ModTime()
+	f.size = stat.Size()
+	f.loaded = true
+	f.Unlock()
+
+	recordCount.WithLabelValues(f.Path).Set(float64(len(records.Records)))
+	lastReload.WithLabelValues(f.Path).SetToCurrentTime()
+	recordsFileBytes.WithLabelValues(f.Path).Set(float64(stat.Size()))
+	lastReloadError.WithLabelValues(f.Path).Set(0)
+	dataVersion.DeleteLabelValues(f.Path, hex.EncodeToString(previous[:]))
+	dataVersion.WithLabelValues(f.Path, hex.EncodeToString(sum[:])).Set(1)
+
+	log.Debugf("Loaded %d records from %s", len(records.Records), f.Path)
+	return nil
+}
+
+// setRecords swaps in records that didn't come from the file, such as those changed through the
+// admin API. They replace the records of Path, the overlay is applied on top of them. Their hash is
+// that of their JSON form, which no file is written as, so the next reload parses the file again.
+func (f *Recordsfile) setRecords(records DNSRecords) {
+	base := records
+	if f.overlay != nil {
+		records = applyOverlay(records, f.overlay.Records())
+	}
+	index := newIndex(records)
+	b, _ := json.Marshal(base)
+	sum := sha256.Sum256(b)
+
+	f.Lock()
+	previous := f.hash
+	f.base = base
+	f.records = records
+	f.index = index
+	f.hash = sum
+	f.version++
+	f.modified = time.Now()
+	f.Unlock()
+
+	recordCount.WithLabelValues(f.Path).Set(float64(len(records.Records)))
+	lastReload.WithLabelValues(f.Path).SetToCurrentTime()
+	dataVersion.DeleteLabelValues(f.Path, hex.EncodeToString(previous[:]))
+	dataVersion.WithLabelValues(f.Path, hex.EncodeToString(sum[:])).Set(1)
+	if f.onReload != nil {
+		f.onReload()
+	}
+}
+
+// writeRecords writes records to the records file, in its format and compressed if it was. The file
+// is replaced atomically via a rename, so a concurrent reload never sees a partial file.
+func (f *Recordsfile) writeRecords(records DNSRecords) error {
+	var (
+		b   []byte
+		err error
+	)
+	if f.format == formatYAML {
+		b, err = yaml.Marshal(records)
+	} else {
+		b, err = json.MarshalIndent(records, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	if f.compressed {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(b); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		b = buf.Bytes()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), filepath.Base(f.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if stat, err := os.Stat(f.Path); err == nil {
+		if err := tmp.Chmod(stat.Mode()); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// update reloads the records file, logging and counting failures.
+func (f *Recordsfile) update() {
+	f.RLock()
+	version := f.version
+	f.RUnlock()
+
+	if err := f.readRecords(); err != nil {
+		reloadFailures.WithLabelValues(f.Path).Inc()
+		lastReloadError.WithLabelValues(f.Path).Set(1)
+		lastReloadErrorTime.WithLabelValues(f.Path).SetToCurrentTime()
+		log.Warningf("Failed to reload %s, keeping previous records: %v", f.Path, err)
+		return
+	}
+	f.RLock()
+	changed := f.version != version
+	f.RUnlock()
+	if changed && f.onReload != nil {
+		f.onReload()
+	}
+}
+
+// changed reports whether the modification time or size of the records file differ from when it
+// was last loaded.
+func (f *Recordsfile) changed() bool {
+	stat, err := os.Stat(f.Path)
+	if err != nil {
+		return false
+	}
+
+	f.RLock()
+	defer f.RUnlock()
+	return !f.mtime.Equal(stat.ModTime()) || f.size != stat.Size()
+}
+
+// start starts keeping the records current: by watching the records file for changes and, if
+// enabled, by polling it. The goroutines run until stop is called.
+func (f *Recordsfile) start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.poll(ctx)
+	return f.watch(ctx)
+}
+
+// stop stops watching and polling the records file, and waits for the goroutines doing so to exit.
+// It's a no-op if start wasn't called.
+func (f *Recordsfile) stop() error {
+	if f.cancel == nil {
+		return nil
+	}
+	f.cancel()
+	f.done.Wait()
+	if f.watcher == nil {
+		return nil
+	}
+	return f.watcher.Close()
+}
+
+// poll starts re-reading the records file every reload interval until ctx is done, for file systems
+// where change notifications aren't reliable. Nothing is started when polling is disabled.
+func (f *Recordsfile) poll(ctx context.Context) {
+	if f.reload == 0 {
+		return
+	}
+
+	f.done.Add(1)
+	go func() {
+		defer f.done.Done()
+		ticker := time.NewTicker(f.reload)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !f.changed() {
+					continue
+				}
+				f.update()
+			}
+		}
+	}()
+}
+
+// watch starts reloading the records file whenever it changes, until ctx is done. The directory is
+// watched rather than the file itself, so that editors and config management replacing the file via
+// a rename are noticed.
+func (f *Recordsfile) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(f.Path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	f.watcher = watcher
+
+	f.done.Add(1)
+	go func() {
+		defer f.done.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(f.Path) {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				f.update()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warningf("Error watching %s: %v", f.Path, err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,280 @@
+package nightlightdns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// waitFor polls cond until it's true, failing the test if that takes longer than a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+// resolves reports whether name has an A record with address.
+func resolves(n Nightlightdns, name, address string) bool {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	resp := exchange(n, m)
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok && a.A.String() == address {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetupReload(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nreload 30s\n}", false},
+		{"nightlightdns {\nreload 0s\n}", false},
+		{"nightlightdns {\nreload\n}", true},
+		{"nightlightdns {\nreload soon\n}", true},
+		{"nightlightdns {\nreload -1s\n}", true},
+	})
+}
+
+func TestReloadPoll(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "dns.json", testRecords)
+	n := newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com {\nreload 20ms\n}", path))
+
+	// Only poll, without watching, so the change is picked up by the polling alone.
+	f := n.Store.(*JSONStore).Files[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	f.poll(ctx)
+	defer func() {
+		cancel()
+		f.done.Wait()
+	}()
+
+	if resolves(n, "new.example.com.", "192.0.2.9") {
+		t.Fatal("Expected new.example.com. not to resolve before the change")
+	}
+	writeFile(t, dir, "dns.json", `{"origin": "example.com.", "records": [{"name": "new", "ipaddress": "192.0.2.9"}]}`)
+	waitFor(t, "new.example.com. to resolve", func() bool { return resolves(n, "new.example.com.", "192.0.2.9") })
+}
+
+// TestConcurrentReload queries while the records are reloaded, run it with -race to check the
+// records are swapped safely.
+func TestConcurrentReload(t *testing.T) {
+	dir := t.TempDir()
+	versions := []string{
+		`{"origin": "example.com.", "records": [{"name": "www", "ipaddress": "192.0.2.1"}]}`,
+		`{"origin": "example.com.", "records": [{"name": "www", "ipaddress": "192.0.2.2"}, {"name": "app", "ipaddress": "192.0.2.3"}]}`,
+	}
+	path := writeFile(t, dir, "dns.json", versions[0])
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com")
+	f := n.Store.(*JSONStore).Files[0]
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := new(dns.Msg)
+			m.SetQuestion("www.example.com.", dns.TypeA)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				resp := exchange(n, m)
+				if len(resp.Answer) != 1 {
+					t.Errorf("Expected 1 answer during reloads, got %d", len(resp.Answer))
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		writeFile(t, dir, "dns.json", versions[i%2])
+		f.update()
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestDisabled(t *testing.T) {
+	dir := t.TempDir()
+	records := func(disabled bool) string {
+		return fmt.Sprintf(`{"origin": "example.com.", "records": [
+  {"name": "www", "ipaddress": "192.0.2.1", "disabled": %t},
+  {"name": "both", "ipaddress": "192.0.2.2"},
+  {"name": "both", "ipaddress": "192.0.2.3", "disabled": %t}
+]}`, disabled, disabled)
+	}
+	path := writeFile(t, dir, "dns.json", records(true))
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com")
+	f := n.Store.(*JSONStore).Files[0]
+
+	tests := []struct {
+		disabled bool
+		rcode    int // of www
+		both     int // the number of addresses of both
+	}{
+		{true, dns.RcodeNameError, 1},
+		{false, dns.RcodeSuccess, 2},
+		{true, dns.RcodeNameError, 1},
+	}
+	for i, tc := range tests {
+		writeFile(t, dir, "dns.json", records(tc.disabled))
+		f.update()
+
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		if resp := exchange(n, m); resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s for www, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+		}
+		m.SetQuestion("both.example.com.", dns.TypeA)
+		if resp := exchange(n, m); len(resp.Answer) != tc.both {
+			t.Errorf("Test %d: expected %d addresses for both, got %d", i, tc.both, len(resp.Answer))
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "dns.json", testRecords)
+	n := newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com {\nreload 10ms\nadmin 127.0.0.1:0 secret\n}", path))
+	f := n.Store.(*JSONStore).Files[0]
+
+	// Stopping what was never started is fine.
+	if err := n.shutdown(); err != nil {
+		t.Fatalf("Expected no error shutting down before starting, got: %v", err)
+	}
+
+	before := runtime.NumGoroutine()
+	if err := f.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.admin.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.shutdown(); err != nil {
+		t.Fatalf("Expected no error shutting down, got: %v", err)
+	}
+	waitFor(t, "the goroutines to exit", func() bool { return runtime.NumGoroutine() <= before })
+
+	// Changes aren't picked up anymore.
+	writeFile(t, dir, "dns.json", `{"origin": "example.com.", "records": [{"name": "new", "ipaddress": "192.0.2.9"}]}`)
+	time.Sleep(50 * time.Millisecond)
+	if resolves(n, "new.example.com.", "192.0.2.9") {
+		t.Error("Expected the records not to be reloaded after shutdown")
+	}
+}
+
+func TestUnchangedReload(t *testing.T) {
+	dir := t.TempDir()
+	records := func(address string) string {
+		return `{"origin": "example.com.", "records": [{"name": "www", "ipaddress": "` + address + `"}]}`
+	}
+	path := writeFile(t, dir, "dns.json", records("192.0.2.1"))
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com")
+	f := n.Store.(*JSONStore).Files[0]
+	reloads := 0
+	f.onReload = func() { reloads++ }
+
+	tests := []struct {
+		records string
+		set     bool // replace the records through setRecords first
+		parsed  bool
+		address string
+	}{
+		{records("192.0.2.1"), false, false, "192.0.2.1"},
+		{records("192.0.2.2"), false, true, "192.0.2.2"},
+		{records("192.0.2.2"), false, false, "192.0.2.2"},
+		// After setRecords the file is parsed again, even if it didn't change.
+		{records("192.0.2.2"), true, true, "192.0.2.2"},
+	}
+	for i, tc := range tests {
+		if tc.set {
+			f.setRecords(f.Records())
+		}
+		f.RLock()
+		version, hash := f.version, f.hash
+		f.RUnlock()
+		before := reloads
+		reloaded := testutil.ToFloat64(lastReload.WithLabelValues(path))
+
+		// Rewriting the file changes its modification time, even with the same contents.
+		time.Sleep(10 * time.Millisecond)
+		writeFile(t, dir, "dns.json", tc.records)
+		if !f.changed() {
+			t.Fatalf("Test %d: expected the rewritten file to have changed", i)
+		}
+		f.update()
+		if f.changed() {
+			t.Errorf("Test %d: expected the modification time to be recorded", i)
+		}
+
+		f.RLock()
+		parsed := f.version != version
+		f.RUnlock()
+		if parsed != tc.parsed || (reloads != before) != tc.parsed {
+			t.Errorf("Test %d: expected parsed %v, got version %d -> %d and %d reloads", i, tc.parsed, version, f.version, reloads-before)
+		}
+		if updated := testutil.ToFloat64(lastReload.WithLabelValues(path)) != reloaded; updated != tc.parsed {
+			t.Errorf("Test %d: expected the reload timestamp updated %v, got %v", i, tc.parsed, updated)
+		}
+		if !resolves(n, "www.example.com.", tc.address) {
+			t.Errorf("Test %d: expected www to resolve to %s", i, tc.address)
+		}
+
+		sum := hex.EncodeToString(f.hash[:])
+		if got := testutil.ToFloat64(dataVersion.WithLabelValues(path, sum)); got != 1 {
+			t.Errorf("Test %d: expected data_version 1 for hash %s, got %v", i, sum, got)
+		}
+		// DeleteLabelValues reports whether the series existed.
+		if previous := hex.EncodeToString(hash[:]); previous != sum && dataVersion.DeleteLabelValues(path, previous) {
+			t.Errorf("Test %d: expected data_version for the previous hash %s to be removed", i, previous)
+		}
+	}
+}
+
+func TestSetRecordsMetrics(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", `{"origin": "example.com.", "records": [{"name": "www", "ipaddress": "192.0.2.1"}]}`)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com")
+	f := n.Store.(*JSONStore).Files[0]
+	loaded := hex.EncodeToString(f.hash[:])
+	reloaded := testutil.ToFloat64(lastReload.WithLabelValues(path))
+
+	time.Sleep(10 * time.Millisecond)
+	records := f.Records()
+	records.Records = append(records.Records, DNSRecord{Name: "app", Ipaddress: "192.0.2.2"})
+	f.setRecords(records)
+
+	b, _ := json.Marshal(records)
+	want := sha256.Sum256(b)
+	if f.hash != want {
+		t.Errorf("Expected the hash of the JSON form of the records, got %x", f.hash)
+	}
+	sum := hex.EncodeToString(want[:])
+	if got := testutil.ToFloat64(dataVersion.WithLabelValues(path, sum)); got != 1 {
+		t.Errorf("Expected data_version 1 for hash %s, got %v", sum, got)
+	}
+	if dataVersion.DeleteLabelValues(path, loaded) {
+		t.Errorf("Expected data_version for the loaded hash %s to be removed", loaded)
+	}
+	if got := testutil.ToFloat64(lastReload.WithLabelValues(path)); got <= reloaded {
+		t.Errorf("Expected the reload timestamp to move past %v, got %v", reloaded, got)
+	}
+}
@@ -0,0 +1,123 @@
+package nightlightdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/miekg/dns"
+)
+
+// redisPrefix is the prefix of the keys the Redis backend reads.
+const redisPrefix = "nightlight"
+
+// redisTypes are the record types the Redis backend reads, each from its own key.
+var redisTypes = []string{"A", "AAAA", "CNAME", "TXT"}
+
+// RedisBackend is a RecordStore that reads records from Redis. The records of a name are stored
+// per type under keys like "nightlight:A:www.example.com.", holding a JSON array of addresses, or
+// of targets and texts for CNAME and TXT records.
+type RedisBackend struct {
+	builder
+
+	pool  *redis.Pool
+	cache *recordCache
+}
+
+// NewRedisBackend returns a RedisBackend connecting to the server at rawurl, a redis:// URL, with
+// a connection pool. Dialing gives up after timeout. Records are answered with ttl.
+func NewRedisBackend(rawurl string, timeout time.Duration, ttl uint32) (*RedisBackend, error) {
+	if !strings.HasPrefix(rawurl, "redis://") && !strings.HasPrefix(rawurl, "rediss://") {
+		return nil, fmt.Errorf("invalid Redis backend URL %q", rawurl)
+	}
+	pool := &redis.Pool{
+		MaxIdle:     8,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(rawurl,
+				redis.DialConnectTimeout(timeout),
+				redis.DialReadTimeout(timeout),
+				redis.DialWriteTimeout(timeout),
+			)
+		},
+	}
+	return &RedisBackend{
+		builder: builder{ttl: ttl, rr: newRotator()},
+		pool:    pool,
+		cache:   newRecordCache("redis", ttl, backendCacheSize),
+	}, nil
+}
+
+// Lookup implements RecordStore.
+func (r *RedisBackend) Lookup(name string, qtype uint16) ([]dns.RR, error) {
+	return r.answer(name, qtype, r.records)
+}
+
+// records returns the records of name, from the cache if they were read recently.
+func (r *RedisBackend) records(name string) ([]DNSRecord, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+	if records, ok := r.cache.get(name); ok {
+		return records, nil
+	}
+
+	records, err := r.read(name)
+	if err != nil {
+		backendFailures.WithLabelValues("redis").Inc()
+		return nil, err
+	}
+	r.cache.set(name, records)
+	return records, nil
+}
+
+// read fetches the keys of all types for name in a single round trip.
+func (r *RedisBackend) read(name string) ([]DNSRecord, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	keys := make([]interface{}, len(redisTypes))
+	for i, t := range redisTypes {
+		keys[i] = redisPrefix + ":" + t + ":" + name
+	}
+	values, err := redis.ByteSlices(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, err
+	}
+
+	records := []DNSRecord{}
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		list := []string{}
+		if err := json.Unmarshal(value, &list); err != nil {
+			log.Warningf("Skipping invalid value of %s: %v", keys[i], err)
+			continue
+		}
+		for _, v := range list {
+			record := DNSRecord{Name: name, Type: redisTypes[i]}
+			switch redisTypes[i] {
+			case "A":
+				record.Ipaddress = v
+			case "AAAA":
+				record.Ipv6address = v
+			case "CNAME":
+				record.Target = qualify(v, ".")
+			case "TXT":
+				record.Text = stringList{v}
+			}
+			if err := record.validate("."); err != nil {
+				log.Warningf("Skipping invalid value of %s: %v", keys[i], err)
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Close closes the connection pool.
+func (r *RedisBackend) Close() error {
+	return r.pool.Close()
+}
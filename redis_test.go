@@ -0,0 +1,87 @@
+package nightlightdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestRedisBackend(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Set("nightlight:A:www.example.com.", `["192.0.2.1", "192.0.2.2"]`)
+	mr.Set("nightlight:AAAA:www.example.com.", `["2001:db8::1"]`)
+	mr.Set("nightlight:TXT:www.example.com.", `["hello"]`)
+	mr.Set("nightlight:CNAME:alias.example.com.", `["www.example.com"]`)
+	mr.Set("nightlight:A:bad.example.com.", `["not-an-ip"]`)
+	mr.Set("nightlight:A:garbage.example.com.", `{`)
+
+	r, err := NewRedisBackend("redis://"+mr.Addr(), time.Second, defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: r}
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("www.example.com. 30 IN A 192.0.2.1"),
+				test.A("www.example.com. 30 IN A 192.0.2.2"),
+			},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("www.example.com. 30 IN AAAA 2001:db8::1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`www.example.com. 30 IN TXT "hello"`)},
+		},
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("alias.example.com. 30 IN CNAME www.example.com."),
+				test.A("www.example.com. 30 IN A 192.0.2.1"),
+				test.A("www.example.com. 30 IN A 192.0.2.2"),
+			},
+		},
+		// Invalid values are skipped.
+		{
+			Qname: "bad.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			Qname: "garbage.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+
+	// The records were cached, a server that went away doesn't fail queries for them.
+	mr.Close()
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("www.example.com. 30 IN AAAA 2001:db8::1")},
+		},
+		{
+			Qname: "other.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		},
+	})
+}
+
+func TestNewRedisBackend(t *testing.T) {
+	for i, url := range []string{"localhost:6379", "http://localhost:6379"} {
+		if _, err := NewRedisBackend(url, time.Second, defaultTTL); err == nil {
+			t.Errorf("Test %d: expected an error for %q, got none", i, url)
+		}
+	}
+}
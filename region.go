@@ -0,0 +1,92 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// RegionStore is a RecordStore that can prefer the records tagged with the region of the client.
+type RegionStore interface {
+	RecordStore
+
+	// LookupRegion is like LookupSubnet, but prefers the address records tagged with region, the
+	// region of the client. region is empty for clients outside all regions.
+	LookupRegion(name string, qtype uint16, client net.IP, region string) ([]dns.RR, uint8, error)
+}
+
+// regionMap maps the subnets of the region-map directive to their regions.
+type regionMap []regionSubnet
+
+type regionSubnet struct {
+	subnet *net.IPNet
+	region string
+}
+
+// add adds the CIDR=REGION pairs of args.
+func (m *regionMap) add(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("region-map needs at least one CIDR=REGION pair")
+	}
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i < 0 || i == len(arg)-1 {
+			return fmt.Errorf("invalid region-map pair '%s', expected CIDR=REGION", arg)
+		}
+		_, subnet, err := net.ParseCIDR(arg[:i])
+		if err != nil {
+			return fmt.Errorf("invalid region-map subnet '%s'", arg[:i])
+		}
+		*m = append(*m, regionSubnet{subnet: subnet, region: arg[i+1:]})
+	}
+	return nil
+}
+
+// match returns the region of ip and the prefix length of the subnet it was found in. The subnet
+// with the longest prefix containing ip wins. The region is empty if no subnet contains ip.
+func (m regionMap) match(ip net.IP) (string, uint8) {
+	region, best := "", -1
+	for _, r := range m {
+		if ones, _ := r.subnet.Mask.Size(); ones > best && r.subnet.Contains(ip) {
+			region, best = r.region, ones
+		}
+	}
+	if best < 0 {
+		return "", 0
+	}
+	return region, uint8(best)
+}
+
+// selectRegion returns the records of a name to answer a client in region with. Of the address
+// records, those tagged with region are used; if there are none, the untagged ones, and if all of
+// them are tagged with other regions, all of them. Other records are always kept.
+func selectRegion(records []DNSRecord, region string) []DNSRecord {
+	tagged, untagged := 0, 0
+	for _, record := range records {
+		switch {
+		case !record.isAddress():
+		case region != "" && record.Region == region:
+			tagged++
+		case record.Region == "":
+			untagged++
+		}
+	}
+	if tagged == 0 && untagged == 0 {
+		return records
+	}
+
+	selected := make([]DNSRecord, 0, len(records))
+	for _, record := range records {
+		switch {
+		case !record.isAddress():
+		case tagged > 0 && record.Region != region:
+			continue
+		case tagged == 0 && record.Region != "":
+			continue
+		}
+		selected = append(selected, record)
+	}
+	return selected
+}
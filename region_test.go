@@ -0,0 +1,91 @@
+package nightlightdns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupRegionMap(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nregion-map 10.0.0.0/8=eu 2001:db8::/32=us\n}", false},
+		{"nightlightdns {\nregion-map\n}", true},
+		{"nightlightdns {\nregion-map 10.0.0.0/8\n}", true},
+		{"nightlightdns {\nregion-map 10.0.0.0/8=\n}", true},
+		{"nightlightdns {\nregion-map 10.0.0.0/33=eu\n}", true},
+	})
+}
+
+func TestRegion(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1", "region": "eu"},
+    {"name": "www", "ipaddress": "192.0.2.2", "region": "us"},
+    {"name": "www", "ipaddress": "192.0.2.3"},
+    {"name": "api", "ipaddress": "198.51.100.1", "region": "eu"},
+    {"name": "api", "ipaddress": "198.51.100.2", "region": "us"}
+  ]
+}`
+	n := newRecordsPlugin(t, records, "region-map 10.1.0.0/16=eu 10.2.0.0/16=us 10.2.3.0/24=eu")
+	tests := []struct {
+		client, qname string
+		addresses     []string
+	}{
+		{"10.1.2.3", "www.example.com.", []string{"192.0.2.1"}},
+		{"10.2.1.1", "www.example.com.", []string{"192.0.2.2"}},
+		// The region of the longest matching subnet wins.
+		{"10.2.3.4", "www.example.com.", []string{"192.0.2.1"}},
+		// Clients outside all regions get the untagged records.
+		{"10.3.0.1", "www.example.com.", []string{"192.0.2.3"}},
+		{"10.1.2.3", "api.example.com.", []string{"198.51.100.1"}},
+		// Without untagged records they get all of them.
+		{"10.3.0.1", "api.example.com.", []string{"198.51.100.1", "198.51.100.2"}},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: tc.client})
+		n.ServeDNS(context.TODO(), rec, m)
+
+		answer := make([]dns.RR, len(tc.addresses))
+		for j, address := range tc.addresses {
+			answer[j] = test.A(tc.qname + " 30 IN A " + address)
+		}
+		if err := test.SortAndCheck(rec.Msg, test.Case{Qname: tc.qname, Qtype: dns.TypeA, Answer: answer}); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestRegionScope(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1", "region": "eu"},
+    {"name": "www", "ipaddress": "192.0.2.2", "region": "us"}
+  ]
+}`
+	tests := []struct {
+		options string
+		scope   int
+	}{
+		// The region is matched on the resolver, the ECS address doesn't decide the answer.
+		{"region-map 10.240.0.0/16=eu 198.51.100.0/24=us", 0},
+		// Behind a trusted proxy the region is matched on the ECS address.
+		{"region-map 10.240.0.0/16=eu 198.51.100.0/24=us\ntrusted-proxies 10.240.0.0/16", 24},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{RemoteIP: "10.240.0.1"})
+		n.ServeDNS(context.TODO(), rec, ecsQuery("www.example.com.", "198.51.100.0/24"))
+
+		if scope := responseScope(rec.Msg); scope != tc.scope {
+			t.Errorf("Test %d: expected scope %d, got %d", i, tc.scope, scope)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package nightlightdns
+
+import (
+	"fmt"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+// rewrite maps a query name to the name whose records answer it. An exact rewrite maps the name
+// from to to, a suffix rewrite maps every name ending in from to the same name ending in to.
+type rewrite struct {
+	suffix   bool
+	from, to string
+}
+
+// rewrites is the list of rewrites of the rewrite directives, the first one that matches applies.
+type rewrites []rewrite
+
+// newRewrite returns the rewrite for the arguments of a rewrite directive: [exact|suffix] FROM TO.
+func newRewrite(args []string) (rewrite, error) {
+	r := rewrite{}
+	if len(args) == 3 {
+		switch args[0] {
+		case "exact":
+		case "suffix":
+			r.suffix = true
+		default:
+			return r, fmt.Errorf("unknown rewrite type '%s', expected exact or suffix", args[0])
+		}
+		args = args[1:]
+	}
+	if len(args) != 2 {
+		return r, fmt.Errorf("rewrite needs a name to rewrite and its replacement")
+	}
+	for _, name := range args {
+		if _, ok := dns.IsDomainName(name); !ok {
+			return r, fmt.Errorf("invalid rewrite name '%s'", name)
+		}
+	}
+	r.from, r.to = plugin.Name(args[0]).Normalize(), plugin.Name(args[1]).Normalize()
+	return r, nil
+}
+
+// apply returns the name to look up for the query name name, which is name itself if no rewrite
+// matches.
+func (rs rewrites) apply(name string) string {
+	for _, r := range rs {
+		switch {
+		case name == r.from:
+			return r.to
+		case r.suffix && dns.IsSubDomain(r.from, name):
+			// The prefix keeps its trailing dot, unless from is the root.
+			prefix := name[:len(name)-len(r.from)]
+			if r.from == "." {
+				prefix = name
+			}
+			if r.to == "." {
+				return prefix
+			}
+			return prefix + r.to
+		}
+	}
+	return name
+}
+
+// restore renames the RRs of answers owned by name, the rewritten query name, back to qname.
+func restore(answers []dns.RR, name, qname string) {
+	if name == qname {
+		return
+	}
+	for _, rr := range answers {
+		if rr.Header().Name == name {
+			rr.Header().Name = qname
+		}
+	}
+}
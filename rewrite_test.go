@@ -0,0 +1,76 @@
+package nightlightdns
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupRewrite(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nrewrite api.example.com www.example.com\n}", false},
+		{"nightlightdns {\nrewrite exact api.example.com www.example.com\n}", false},
+		{"nightlightdns {\nrewrite suffix example.net example.com\n}", false},
+		{"nightlightdns {\nrewrite api.example.com\n}", true},
+		{"nightlightdns {\nrewrite prefix api.example.com www.example.com\n}", true},
+		{"nightlightdns {\nrewrite api..example.com www.example.com\n}", true},
+	})
+}
+
+func TestRewritesApply(t *testing.T) {
+	rs := rewrites{
+		{from: "api.example.com.", to: "www.example.com."},
+		{suffix: true, from: "example.net.", to: "example.com."},
+	}
+	tests := []struct {
+		name, rewritten string
+	}{
+		{"api.example.com.", "www.example.com."},
+		{"v1.api.example.com.", "v1.api.example.com."},
+		{"www.example.net.", "www.example.com."},
+		{"a.b.example.net.", "a.b.example.com."},
+		{"example.net.", "example.com."},
+		{"badexample.net.", "badexample.net."},
+		{"www.example.org.", "www.example.org."},
+	}
+	for i, tc := range tests {
+		if got := rs.apply(tc.name); got != tc.rewritten {
+			t.Errorf("Test %d: expected %s to be rewritten to %s, got %s", i, tc.name, tc.rewritten, got)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "dns.json", `{
+  "records": [
+    {"name": "www.example.com.", "ipaddress": "192.0.2.1"},
+    {"name": "mail.example.com.", "type": "MX", "target": "mx.example.com.", "preference": 10}
+  ]
+}`)
+	n := newTestPlugin(t, "nightlightdns "+path+" example.com example.net {\nrewrite api.example.com www.example.com\nrewrite suffix example.net example.com\n}")
+	checkCases(t, n, []test.Case{
+		// Answers are owned by the name queried.
+		{
+			Qname: "api.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("api.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "www.example.net.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.net. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "mail.example.net.", Qtype: dns.TypeMX,
+			Answer: []dns.RR{test.MX("mail.example.net. 30 IN MX 10 mx.example.com.")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "nope.example.net.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+}
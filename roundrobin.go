@@ -0,0 +1,95 @@
+package nightlightdns
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// rotator hands out a counter that is used to rotate the order of multi-address answers, so that
+// successive queries for a name see a different first address. It also picks the first address of
+// weighted answers.
+type rotator struct {
+	sync.Mutex
+	count uint64
+
+	// rnd picks the first address of weighted answers.
+	rnd *rand.Rand
+}
+
+// newRotator returns a rotator starting at the first address.
+func newRotator() *rotator {
+	return &rotator{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// next returns the current counter value and advances it.
+func (r *rotator) next() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	c := r.count
+	r.count++
+	return c
+}
+
+// rotate returns rrs rotated left by offset positions.
+func rotate(rrs []dns.RR, offset uint64) []dns.RR {
+	if len(rrs) < 2 {
+		return rrs
+	}
+	start := int(offset % uint64(len(rrs)))
+	rotated := make([]dns.RR, 0, len(rrs))
+	rotated = append(rotated, rrs[start:]...)
+	return append(rotated, rrs[:start]...)
+}
+
+// sortAddresses sorts A or AAAA RRs by their address.
+func sortAddresses(rrs []dns.RR) {
+	sort.SliceStable(rrs, func(i, j int) bool {
+		return bytes.Compare(address(rrs[i]), address(rrs[j])) < 0
+	})
+}
+
+// address returns the address of an A or AAAA RR in its 16 byte form.
+func address(rr dns.RR) net.IP {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.To16()
+	case *dns.AAAA:
+		return rr.AAAA.To16()
+	}
+	return nil
+}
+
+// pick returns an index into weights, chosen with a probability proportional to its weight. The
+// weights must add up to more than zero.
+func (r *rotator) pick(weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	r.Lock()
+	n := r.rnd.Intn(total)
+	r.Unlock()
+
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(weights) - 1
+}
+
+// weighted returns rrs with the RR picked by weight moved to the front, the others keep their order.
+func weighted(rrs []dns.RR, i int) []dns.RR {
+	ordered := make([]dns.RR, 0, len(rrs))
+	ordered = append(ordered, rrs[i])
+	ordered = append(ordered, rrs[:i]...)
+	return append(ordered, rrs[i+1:]...)
+}
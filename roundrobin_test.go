@@ -0,0 +1,146 @@
+package nightlightdns
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// threeAddresses are the records of a name with three addresses.
+const threeAddresses = `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "www", "ipaddress": "192.0.2.2"},
+    {"name": "www", "ipaddress": "192.0.2.3"}
+  ]
+}`
+
+// firstAddress returns the address of the first A RR of the response to a query for name.
+func firstAddress(t *testing.T, n Nightlightdns, name string, count int) string {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	resp := exchange(n, m)
+	if len(resp.Answer) != count {
+		t.Fatalf("Expected %d answers for %s, got %d", count, name, len(resp.Answer))
+	}
+	return resp.Answer[0].(*dns.A).A.String()
+}
+
+func TestRoundRobin(t *testing.T) {
+	n := newRecordsPlugin(t, threeAddresses, "round-robin")
+
+	expected := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.1"}
+	for i, want := range expected {
+		if got := firstAddress(t, n, "www.example.com.", 3); got != want {
+			t.Errorf("Test %d: expected %s first, got %s", i, want, got)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	rrs := []dns.RR{
+		a("www.example.com.", 30, []byte{192, 0, 2, 1}),
+		a("www.example.com.", 30, []byte{192, 0, 2, 2}),
+		a("www.example.com.", 30, []byte{192, 0, 2, 3}),
+	}
+	tests := []struct {
+		offset uint64
+		first  string
+	}{
+		{0, "192.0.2.1"},
+		{1, "192.0.2.2"},
+		{2, "192.0.2.3"},
+		{3, "192.0.2.1"},
+		{7, "192.0.2.2"},
+	}
+	for i, tc := range tests {
+		rotated := rotate(rrs, tc.offset)
+		if len(rotated) != len(rrs) {
+			t.Fatalf("Test %d: expected %d RRs, got %d", i, len(rrs), len(rotated))
+		}
+		if got := rotated[0].(*dns.A).A.String(); got != tc.first {
+			t.Errorf("Test %d: expected %s first, got %s", i, tc.first, got)
+		}
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1", "weight": 70},
+    {"name": "www", "ipaddress": "192.0.2.2", "weight": 30},
+    {"name": "www", "ipaddress": "192.0.2.3"}
+  ]
+}`)
+	n.Store.(*JSONStore).rr.rnd = rand.New(rand.NewSource(1))
+
+	const queries = 10000
+	first := map[string]int{}
+	for i := 0; i < queries; i++ {
+		first[firstAddress(t, n, "www.example.com.", 3)]++
+	}
+	for address, weight := range map[string]float64{"192.0.2.1": 0.7, "192.0.2.2": 0.3, "192.0.2.3": 0} {
+		if share := float64(first[address]) / queries; math.Abs(share-weight) > 0.02 {
+			t.Errorf("Expected %s first in %.0f%% of the answers, got %.1f%%", address, weight*100, share*100)
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	r := newRotator()
+	tests := []struct {
+		weights []int
+		picked  int
+	}{
+		{[]int{1}, 0},
+		{[]int{0, 5}, 1},
+		{[]int{5, 0}, 0},
+		{[]int{0, 0, 1, 0}, 2},
+	}
+	for i, tc := range tests {
+		for j := 0; j < 100; j++ {
+			if picked := r.pick(tc.weights); picked != tc.picked {
+				t.Fatalf("Test %d: expected %d to be picked from %v, got %d", i, tc.picked, tc.weights, picked)
+			}
+		}
+	}
+}
+
+func TestStableOrder(t *testing.T) {
+	// The addresses are listed out of order and spread over several records.
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.3, 192.0.2.10"},
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "www", "ipaddress": "192.0.2.2", "ipv6address": "2001:db8::2, 2001:db8::1"}
+  ]
+}`)
+	tests := []struct {
+		qtype uint16
+		order []string
+	}{
+		{dns.TypeA, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.10"}},
+		{dns.TypeAAAA, []string{"2001:db8::1", "2001:db8::2"}},
+	}
+	for i, tc := range tests {
+		for q := 0; q < 10; q++ {
+			m := new(dns.Msg)
+			m.SetQuestion("www.example.com.", tc.qtype)
+			resp := exchange(n, m)
+			if len(resp.Answer) != len(tc.order) {
+				t.Fatalf("Test %d: expected %d answers, got %d", i, len(tc.order), len(resp.Answer))
+			}
+			for j, want := range tc.order {
+				if got := address(resp.Answer[j]).String(); got != want {
+					t.Errorf("Test %d: expected %s at position %d of query %d, got %s", i, want, j, q, got)
+				}
+			}
+		}
+	}
+}
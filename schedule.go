@@ -0,0 +1,108 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"time"
+)
+
+// clockLayout is the layout of the daily form of the active_from and active_to fields.
+const clockLayout = "15:04"
+
+// window is the time a record is active in, parsed from its active_from and active_to fields.
+// Either end may be open. Absolute windows hold instants, daily ones the minutes since midnight
+// in local time, and may wrap around midnight.
+type window struct {
+	daily bool
+
+	from, to           time.Time
+	fromClock, toClock int
+	hasFrom, hasTo     bool
+}
+
+// parseWindow parses the active_from and active_to fields of r. Both must use the same form,
+// either RFC 3339 timestamps or HH:MM times of day.
+func (r DNSRecord) parseWindow() (window, error) {
+	w := window{}
+	if r.ActiveFrom == "" && r.ActiveTo == "" {
+		return w, nil
+	}
+
+	forms := 0
+	for _, f := range []struct {
+		value string
+		at    *time.Time
+		clock *int
+		has   *bool
+	}{
+		{r.ActiveFrom, &w.from, &w.fromClock, &w.hasFrom},
+		{r.ActiveTo, &w.to, &w.toClock, &w.hasTo},
+	} {
+		if f.value == "" {
+			continue
+		}
+		*f.has = true
+		if t, err := time.Parse(clockLayout, f.value); err == nil {
+			*f.clock = t.Hour()*60 + t.Minute()
+			w.daily = true
+			forms |= 1
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, f.value)
+		if err != nil {
+			return w, fmt.Errorf("invalid active time %q, expected RFC 3339 or HH:MM", f.value)
+		}
+		*f.at = t
+		forms |= 2
+	}
+	if forms == 3 {
+		return w, fmt.Errorf("active_from and active_to must both be RFC 3339 or HH:MM")
+	}
+	return w, nil
+}
+
+// contains reports whether t falls within w, including its start but not its end.
+func (w window) contains(t time.Time) bool {
+	if !w.daily {
+		return (!w.hasFrom || !t.Before(w.from)) && (!w.hasTo || t.Before(w.to))
+	}
+
+	t = t.Local()
+	clock := t.Hour()*60 + t.Minute()
+	switch {
+	case !w.hasFrom:
+		return clock < w.toClock
+	case !w.hasTo:
+		return clock >= w.fromClock
+	case w.fromClock <= w.toClock:
+		return clock >= w.fromClock && clock < w.toClock
+	}
+	// The window wraps around midnight, e.g. from 22:00 to 02:00.
+	return clock >= w.fromClock || clock < w.toClock
+}
+
+// active returns the records that are active at the current time.
+func (b builder) active(records []DNSRecord) []DNSRecord {
+	scheduled := false
+	for _, record := range records {
+		if record.ActiveFrom != "" || record.ActiveTo != "" {
+			scheduled = true
+			break
+		}
+	}
+	if !scheduled {
+		return records
+	}
+
+	now := time.Now()
+	if b.now != nil {
+		now = b.now()
+	}
+	active := make([]DNSRecord, 0, len(records))
+	for _, record := range records {
+		// Records are validated when they're loaded, so the window parses.
+		if w, err := record.parseWindow(); err == nil && w.contains(now) {
+			active = append(active, record)
+		}
+	}
+	return active
+}
@@ -0,0 +1,124 @@
+package nightlightdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestWindow(t *testing.T) {
+	day := func(hour, min int) time.Time { return time.Date(2021, 6, 1, hour, min, 0, 0, time.Local) }
+	tests := []struct {
+		from, to string
+		at       time.Time
+		active   bool
+	}{
+		{"", "", day(12, 0), true},
+		{"2021-06-01T00:00:00Z", "2021-06-02T00:00:00Z", time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2021-06-01T00:00:00Z", "2021-06-02T00:00:00Z", time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC), false},
+		{"2021-06-01T00:00:00Z", "2021-06-02T00:00:00Z", time.Date(2021, 5, 31, 23, 59, 0, 0, time.UTC), false},
+		{"2021-06-01T00:00:00Z", "", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"", "2021-06-01T00:00:00Z", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), false},
+		{"09:00", "17:00", day(9, 0), true},
+		{"09:00", "17:00", day(16, 59), true},
+		{"09:00", "17:00", day(17, 0), false},
+		{"09:00", "17:00", day(8, 59), false},
+		// Windows wrapping around midnight.
+		{"22:00", "02:00", day(23, 0), true},
+		{"22:00", "02:00", day(1, 0), true},
+		{"22:00", "02:00", day(12, 0), false},
+		{"22:00", "", day(23, 0), true},
+		{"", "02:00", day(3, 0), false},
+	}
+	for i, tc := range tests {
+		w, err := DNSRecord{ActiveFrom: tc.from, ActiveTo: tc.to}.parseWindow()
+		if err != nil {
+			t.Fatalf("Test %d: %v", i, err)
+		}
+		if got := w.contains(tc.at); got != tc.active {
+			t.Errorf("Test %d: expected %v for %s within [%s, %s), got %v", i, tc.active, tc.at, tc.from, tc.to, got)
+		}
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	tests := []struct {
+		from, to string
+	}{
+		{"tomorrow", ""},
+		{"", "25:00"},
+		{"09:00", "2021-06-01T00:00:00Z"},
+	}
+	for i, tc := range tests {
+		if _, err := (DNSRecord{ActiveFrom: tc.from, ActiveTo: tc.to}).parseWindow(); err == nil {
+			t.Errorf("Test %d: expected an error for [%s, %s), got none", i, tc.from, tc.to)
+		}
+	}
+}
+
+func TestActive(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1", "active_to": "2021-06-01T00:00:00Z"},
+    {"name": "www", "ipaddress": "192.0.2.2", "active_from": "2021-06-01T00:00:00Z"},
+    {"name": "maint", "ipaddress": "192.0.2.3", "active_from": "02:00", "active_to": "04:00"},
+    {"name": "alias", "type": "CNAME", "target": "www"}
+  ]
+}`)
+	var now time.Time
+	n.Store.(*JSONStore).builder.now = func() time.Time { return now }
+
+	tests := []struct {
+		now   time.Time
+		cases []test.Case
+	}{
+		{
+			now: time.Date(2021, 5, 31, 3, 0, 0, 0, time.Local),
+			cases: []test.Case{
+				{
+					Qname: "www.example.com.", Qtype: dns.TypeA,
+					Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+				},
+				{
+					Qname: "maint.example.com.", Qtype: dns.TypeA,
+					Answer: []dns.RR{test.A("maint.example.com. 30 IN A 192.0.2.3")},
+				},
+				{
+					Qname: "alias.example.com.", Qtype: dns.TypeA,
+					Answer: []dns.RR{
+						test.CNAME("alias.example.com. 30 IN CNAME www.example.com."),
+						test.A("www.example.com. 30 IN A 192.0.2.1"),
+					},
+				},
+			},
+		},
+		{
+			now: time.Date(2021, 6, 1, 12, 0, 0, 0, time.Local),
+			cases: []test.Case{
+				{
+					Qname: "www.example.com.", Qtype: dns.TypeA,
+					Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.2")},
+				},
+				// Outside its window the name still exists, it has no data.
+				{
+					Qname: "maint.example.com.", Qtype: dns.TypeA,
+				},
+				{
+					Qname: "alias.example.com.", Qtype: dns.TypeA,
+					Answer: []dns.RR{
+						test.CNAME("alias.example.com. 30 IN CNAME www.example.com."),
+						test.A("www.example.com. 30 IN A 192.0.2.2"),
+					},
+				},
+			},
+		},
+	}
+	for _, tc := range tests {
+		now = tc.now
+		checkCases(t, n, tc.cases)
+	}
+}
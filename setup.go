@@ -1,9 +1,25 @@
 package nightlightdns
 
 import (
+	"io"
+	"math"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultPath is the records file used when none is given in the Corefile.
+	defaultPath = "dns.json"
+	// defaultTTL is the TTL of answers for records that don't set their own.
+	defaultTTL = 30
 )
 
 // init registers this plugin.
@@ -12,19 +28,835 @@ func init() { plugin.Register("nightlightdns", setup) }
 // setup is the function that gets called when the config parser see the token "nightlightdns". Setup is responsible
 // for parsing any extra options the nightlightdns plugin may have. The first token this function sees is "nightlightdns".
 func setup(c *caddy.Controller) error {
-	c.Next() // Ignore "nightlightdns" and give us the next token.
-	if c.NextArg() {
-		// If there was another token, return an error, because we don't have any configuration.
+	n, err := parse(c)
+	if err != nil {
 		// Any errors returned from this setup function should be wrapped with plugin.Error, so we
 		// can present a slightly nicer error message to the user.
-		return plugin.Error("nightlightdns", c.ArgErr())
+		return plugin.Error("nightlightdns", err)
+	}
+
+	// Read the records once so queries are answered from memory. A missing or broken file is a
+	// configuration error and should stop CoreDNS from starting.
+	if n.Zonefile != nil {
+		z, err := loadZonefile(n.Zonefile.Path, n.Zonefile.Origin, n.Zonefile.TTL)
+		if err != nil {
+			return plugin.Error("nightlightdns", err)
+		}
+		n.Zonefile = z
 	}
 
+	for _, store := range n.allStores() {
+		switch s := store.(type) {
+		case *JSONStore:
+			if n.Zonefile != nil {
+				break
+			}
+			if err := s.readRecords(); err != nil {
+				return plugin.Error("nightlightdns", err)
+			}
+			// Changes made before the start are only known from the modification times, in date
+			// mode the serial starts no lower than the day of the latest one.
+			if n.serial != nil && n.serial.mode == serialDate {
+				for _, f := range s.Files {
+					n.serial.atLeast(dateSerial(f.mtime))
+				}
+			}
+
+			// Pick up changes to the records files without a restart, following them with the SOA
+			// serial if configured.
+			for _, f := range s.Files {
+				if n.serial != nil {
+					onReload := f.onReload
+					f.onReload = func() {
+						onReload()
+						n.serial.bump()
+					}
+				}
+				c.OnStartup(f.start)
+				if f.overlay != nil {
+					c.OnStartup(f.overlay.start)
+				}
+			}
+		case *ConsulBackend:
+			c.OnStartup(s.start)
+		}
+	}
+
+	if n.admin != nil {
+		c.OnStartup(n.admin.Startup)
+	}
+	if n.export != nil {
+		c.OnStartup(n.export.Startup)
+	}
+
+	// Stop the goroutines and release the connections when the server stops, or its configuration
+	// is reloaded and a new instance takes over.
+	c.OnShutdown(n.shutdown)
+
 	// Add the Plugin to CoreDNS, so Servers can use it in their plugin chain.
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-		return Nightlightdns{Next: next}
+		n.Next = next
+		return n
 	})
 
 	// All OK, return a nil error.
 	return nil
 }
+
+// isRecordsPath reports whether the directive argument arg is a records file rather than a zone: it
+// has the extension of a records file or of a compressed one, or contains a path separator or glob
+// pattern.
+func isRecordsPath(arg string) bool {
+	if gzipped(arg) {
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(arg)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return strings.ContainsAny(arg, "/*?[")
+}
+
+// parse parses the nightlightdns directive and its block.
+func parse(c *caddy.Controller) (Nightlightdns, error) {
+	n := Nightlightdns{Log: logPlain, Compress: true, views: map[string]*JSONStore{}}
+	b := builder{ttl: defaultTTL, rr: newRotator()}
+	backends := []backendConfig{}
+	timeout := defaultTimeout
+	var negativeTTL, cacheTTL time.Duration
+	format, keyfile, origin := "", "", ""
+	compressed := false
+	env := envOff
+	var reload time.Duration
+	version := ""
+	viewPaths := map[string][]string{}
+	overlayPath := ""
+	serialMode := ""
+	serialPath := ""
+
+	c.Next() // Ignore "nightlightdns" and give us the next token.
+
+	// The arguments are records files and the zones to answer for, the zones default to those of
+	// the server block.
+	paths, zones := []string{}, []string{}
+	for _, arg := range c.RemainingArgs() {
+		if isRecordsPath(arg) {
+			paths = append(paths, arg)
+		} else {
+			zones = append(zones, arg)
+		}
+	}
+	n.Zones = plugin.OriginsFromArgsOrServerBlock(zones, c.ServerBlockKeys)
+	if len(n.Zones) == 0 {
+		n.Zones = []string{"."}
+	}
+
+	config := dnsserver.GetConfig(c)
+	for c.NextBlock() {
+		switch c.Val() {
+		case "format":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.ArgErr()
+			}
+			switch remaining[0] {
+			case formatJSON, formatYAML:
+				format = remaining[0]
+			default:
+				return n, c.Errf("unknown format '%s', expected json or yaml", remaining[0])
+			}
+		case "origin":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.ArgErr()
+			}
+			if _, ok := dns.IsDomainName(remaining[0]); !ok {
+				return n, c.Errf("invalid origin '%s'", remaining[0])
+			}
+			origin = dns.Fqdn(remaining[0])
+		case "file":
+			remaining := c.RemainingArgs()
+			if len(remaining) == 0 {
+				return n, c.ArgErr()
+			}
+			paths = append(paths, remaining...)
+		case "overlay":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("overlay needs a path")
+			}
+			overlayPath = remaining[0]
+		case "soa-serial":
+			remaining := c.RemainingArgs()
+			if len(remaining) < 1 || len(remaining) > 2 || (remaining[0] != serialAuto && remaining[0] != serialDate) {
+				return n, c.Errf("soa-serial needs a mode, auto or date, and an optional path")
+			}
+			serialMode = remaining[0]
+			if len(remaining) == 2 {
+				serialPath = remaining[1]
+				if !filepath.IsAbs(serialPath) && config.Root != "" {
+					serialPath = filepath.Join(config.Root, serialPath)
+				}
+			}
+		case "zonefile":
+			remaining := c.RemainingArgs()
+			if len(remaining) < 1 || len(remaining) > 2 {
+				return n, c.Errf("zonefile needs a path and an optional origin")
+			}
+			z := &Zonefile{Path: remaining[0], Origin: n.Zones[0]}
+			if !filepath.IsAbs(z.Path) && config.Root != "" {
+				z.Path = filepath.Join(config.Root, z.Path)
+			}
+			if len(remaining) == 2 {
+				z.Origin = remaining[1]
+			}
+			n.Zonefile = z
+		case "backend":
+			remaining := c.RemainingArgs()
+			bc := backendConfig{prefix: defaultConsulPrefix}
+			if len(remaining) == 1 && remaining[0] == "records" {
+				for _, bc := range backends {
+					if bc.kind == "records" {
+						return n, c.Errf("backend records is only allowed once")
+					}
+				}
+				backends = append(backends, backendConfig{kind: "records"})
+				break
+			}
+			if len(remaining) == 3 && remaining[0] == "grpc" && remaining[2] == "insecure" {
+				bc.plaintext = true
+				remaining = remaining[:2]
+			}
+			if len(remaining) > 2 && remaining[0] == "consul" {
+				// The Consul backend takes a prefix and watch after its address.
+				options := remaining[2:]
+				remaining = remaining[:2]
+				for len(options) > 0 {
+					switch {
+					case options[0] == "prefix" && len(options) > 1:
+						bc.prefix = options[1]
+						options = options[2:]
+					case options[0] == "watch":
+						bc.watch = true
+						options = options[1:]
+					default:
+						return n, c.Errf("unknown consul backend option '%s'", options[0])
+					}
+				}
+			}
+			if len(remaining) != 2 {
+				return n, c.Errf("backend needs a type and a location")
+			}
+			switch remaining[0] {
+			case "sqlite":
+				bc.location = remaining[1]
+				if !filepath.IsAbs(bc.location) && config.Root != "" {
+					bc.location = filepath.Join(config.Root, bc.location)
+				}
+			case "http", "redis", "grpc", "consul":
+				bc.location = remaining[1]
+			default:
+				return n, c.Errf("unknown backend '%s'", remaining[0])
+			}
+			bc.kind = remaining[0]
+			backends = append(backends, bc)
+		case "cache-ttl":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("cache-ttl needs a duration")
+			}
+			d, err := time.ParseDuration(remaining[0])
+			if err != nil || d <= 0 {
+				return n, c.Errf("invalid duration for cache-ttl '%s'", remaining[0])
+			}
+			cacheTTL = d
+		case "negcache-ttl":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("negcache-ttl needs a duration")
+			}
+			d, err := time.ParseDuration(remaining[0])
+			if err != nil || d <= 0 {
+				return n, c.Errf("invalid duration for negcache-ttl '%s'", remaining[0])
+			}
+			negativeTTL = d
+		case "timeout":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("timeout needs a duration")
+			}
+			d, err := time.ParseDuration(remaining[0])
+			if err != nil || d <= 0 {
+				return n, c.Errf("invalid duration for timeout '%s'", remaining[0])
+			}
+			timeout = d
+		case "slowlog":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("slowlog needs a duration")
+			}
+			d, err := time.ParseDuration(remaining[0])
+			if err != nil || d <= 0 {
+				return n, c.Errf("invalid duration for slowlog '%s'", remaining[0])
+			}
+			n.Slowlog = d
+		case "log":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.ArgErr()
+			}
+			switch remaining[0] {
+			case logPlain, logJSON:
+				n.Log = remaining[0]
+			default:
+				return n, c.Errf("unknown log format '%s', expected plain or json", remaining[0])
+			}
+		case "view":
+			remaining := c.RemainingArgs()
+			if len(remaining) < 2 {
+				return n, c.Errf("view needs a server and records files")
+			}
+			if _, ok := viewPaths[remaining[0]]; ok {
+				return n, c.Errf("duplicate view for server '%s'", remaining[0])
+			}
+			viewPaths[remaining[0]] = remaining[1:]
+		case "query-log-size":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("query-log-size needs a number of queries")
+			}
+			size, err := strconv.Atoi(remaining[0])
+			if err != nil || size <= 0 {
+				return n, c.Errf("invalid query-log-size '%s'", remaining[0])
+			}
+			n.recent = newRecentQueries(size)
+		case "soa":
+			soa, err := newSOA(c.RemainingArgs(), n.Zones)
+			if err != nil {
+				return n, c.Err(err.Error())
+			}
+			n.SOA = soa
+		case "admin":
+			remaining := c.RemainingArgs()
+			if len(remaining) < 2 || len(remaining) > 3 || (len(remaining) == 3 && remaining[2] != "persist") {
+				return n, c.Errf("admin needs an address, a token and optionally persist")
+			}
+			n.admin = newAdmin(remaining[0], remaining[1], len(remaining) == 3)
+		case "export":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("export needs an address")
+			}
+			e, err := newExport(remaining[0])
+			if err != nil {
+				return n, c.Err(err.Error())
+			}
+			n.export = e
+		case "blocklist":
+			remaining := c.RemainingArgs()
+			if len(remaining) == 0 {
+				return n, c.Errf("blocklist needs a path and optionally the response")
+			}
+			path := remaining[0]
+			if !filepath.IsAbs(path) && config.Root != "" {
+				path = filepath.Join(config.Root, path)
+			}
+			bl, err := newBlocklist(path, remaining[1:])
+			if err != nil {
+				return n, c.Errf("unable to load blocklist: %v", err)
+			}
+			n.blocklist = bl
+		case "default":
+			remaining := c.RemainingArgs()
+			if len(remaining) == 0 {
+				return n, c.Errf("default needs at least one address")
+			}
+			n.catchAll = &catchAll{}
+			for _, addr := range remaining {
+				ip := net.ParseIP(addr)
+				if ip == nil {
+					return n, c.Errf("invalid default address '%s'", addr)
+				}
+				n.catchAll.ips = append(n.catchAll.ips, ip)
+			}
+		case "ratelimit":
+			remaining := c.RemainingArgs()
+			if len(remaining) < 1 || len(remaining) > 2 {
+				return n, c.Errf("ratelimit needs queries per second and an optional burst")
+			}
+			qps, err := strconv.ParseFloat(remaining[0], 64)
+			if err != nil || qps <= 0 {
+				return n, c.Errf("invalid queries per second for ratelimit '%s'", remaining[0])
+			}
+			burst := math.Max(qps, 1)
+			if len(remaining) == 2 {
+				burst, err = strconv.ParseFloat(remaining[1], 64)
+				if err != nil || burst < 1 {
+					return n, c.Errf("invalid burst for ratelimit '%s'", remaining[1])
+				}
+			}
+			n.limiter = newRateLimiter(qps, burst)
+		case "dnssec":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 2 || remaining[0] != "keyfile" {
+				return n, c.Errf("dnssec needs keyfile and the path of the key, without extension")
+			}
+			keyfile = remaining[1]
+			if !filepath.IsAbs(keyfile) && config.Root != "" {
+				keyfile = filepath.Join(config.Root, keyfile)
+			}
+		case "compressed":
+			if len(c.RemainingArgs()) != 0 {
+				return n, c.ArgErr()
+			}
+			compressed = true
+		case "expand-env":
+			remaining := c.RemainingArgs()
+			switch {
+			case len(remaining) == 0:
+				env = envExpand
+			case len(remaining) == 1 && remaining[0] == "strict":
+				env = envStrict
+			default:
+				return n, c.Errf("expand-env takes no argument or strict")
+			}
+		case "dns64":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("dns64 needs a prefix")
+			}
+			d, err := newDNS64(remaining[0])
+			if err != nil {
+				return n, c.Err(err.Error())
+			}
+			n.dns64 = d
+		case "max-udp-size":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("max-udp-size needs a size in bytes")
+			}
+			size, err := strconv.ParseUint(remaining[0], 10, 16)
+			if err != nil || size < dns.MinMsgSize {
+				return n, c.Errf("invalid max-udp-size '%s', must be between %d and %d", remaining[0], dns.MinMsgSize, dns.MaxMsgSize)
+			}
+			n.MaxUDPSize = uint16(size)
+		case "compress":
+			remaining := c.RemainingArgs()
+			switch {
+			case len(remaining) == 0:
+				n.Compress = true
+			case len(remaining) == 1 && remaining[0] == "off":
+				n.Compress = false
+			default:
+				return n, c.Errf("compress takes no argument or off")
+			}
+		case "ede":
+			if len(c.RemainingArgs()) != 0 {
+				return n, c.ArgErr()
+			}
+			n.EDE = true
+		case "strict-aa":
+			if len(c.RemainingArgs()) != 0 {
+				return n, c.ArgErr()
+			}
+			n.StrictAA = true
+		case "minimal-responses":
+			if len(c.RemainingArgs()) != 0 {
+				return n, c.ArgErr()
+			}
+			n.MinimalResponses = true
+		case "chaos":
+			n.chaos = newChaos(c.RemainingArgs())
+		case "version":
+			remaining := c.RemainingArgs()
+			if len(remaining) == 0 {
+				return n, c.Errf("version needs a string")
+			}
+			version = strings.Join(remaining, " ")
+		case "upstream":
+			addrs, err := upstreamAddrs(c.RemainingArgs())
+			if err != nil {
+				return n, c.Err(err.Error())
+			}
+			n.upstream = newUpstreamResolver(addrs, timeout)
+		case "minimal-any":
+			if len(c.RemainingArgs()) != 0 {
+				return n, c.ArgErr()
+			}
+			n.MinimalAny = true
+		case "acl":
+			if err := n.ACL.add(c.RemainingArgs()); err != nil {
+				return n, c.Err(err.Error())
+			}
+		case "trusted-proxies":
+			remaining := c.RemainingArgs()
+			if len(remaining) == 0 {
+				return n, c.ArgErr()
+			}
+			if n.TrustedProxies == nil {
+				n.TrustedProxies = &ACL{deny: true}
+			}
+			if err := n.TrustedProxies.add(append([]string{"allow"}, remaining...)); err != nil {
+				return n, c.Err(err.Error())
+			}
+		case "allow-transfer":
+			remaining := c.RemainingArgs()
+			if len(remaining) == 0 {
+				return n, c.ArgErr()
+			}
+			if n.AllowTransfer == nil {
+				n.AllowTransfer = &ACL{deny: true}
+			}
+			if err := n.AllowTransfer.add(append([]string{"allow"}, remaining...)); err != nil {
+				return n, c.Err(err.Error())
+			}
+		case "fallthrough":
+			n.Fall.SetZonesFromArgs(c.RemainingArgs())
+		case "cname-depth":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.ArgErr()
+			}
+			depth, err := strconv.Atoi(remaining[0])
+			if err != nil || depth < 1 {
+				return n, c.Errf("invalid cname-depth '%s'", remaining[0])
+			}
+			b.depth = depth
+		case "ttl":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("ttl needs a time in seconds")
+			}
+			ttl, err := strconv.ParseUint(remaining[0], 10, 32)
+			if err != nil {
+				return n, c.Errf("ttl must be a number of seconds between 0 and 4294967295, got '%s'", remaining[0])
+			}
+			b.ttl = uint32(ttl)
+		case "rewrite":
+			r, err := newRewrite(c.RemainingArgs())
+			if err != nil {
+				return n, c.Err(err.Error())
+			}
+			n.rewrites = append(n.rewrites, r)
+		case "region-map":
+			if err := n.regions.add(c.RemainingArgs()); err != nil {
+				return n, c.Err(err.Error())
+			}
+		case "select":
+			remaining := c.RemainingArgs()
+			if len(remaining) < 1 || len(remaining) > 2 || (len(remaining) == 2 && remaining[1] != "qname") {
+				return n, c.Errf("select needs a mode and optionally qname")
+			}
+			if remaining[0] != "chash" {
+				return n, c.Errf("unknown select mode '%s', expected chash", remaining[0])
+			}
+			n.chash = &consistentHash{qname: len(remaining) == 2}
+		case "round-robin":
+			if len(c.RemainingArgs()) != 0 {
+				return n, c.ArgErr()
+			}
+			b.roundRobin = true
+		case "allowed-types":
+			if n.allowedTypes == nil {
+				n.allowedTypes = make(map[string]allowedTypes)
+			}
+			if err := newAllowedTypes(n.allowedTypes, c.RemainingArgs(), n.Zones); err != nil {
+				return n, c.Err(err.Error())
+			}
+		case "shuffle":
+			if len(c.RemainingArgs()) != 0 {
+				return n, c.ArgErr()
+			}
+			n.shuffler = newShuffler()
+		case "ttl-jitter":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("ttl-jitter needs a time in seconds")
+			}
+			max, err := strconv.ParseUint(remaining[0], 10, 32)
+			if err != nil {
+				return n, c.Errf("ttl-jitter must be a number of seconds, got '%s'", remaining[0])
+			}
+			n.jitter = newJitter(uint32(max))
+		case "reload":
+			remaining := c.RemainingArgs()
+			if len(remaining) != 1 {
+				return n, c.Errf("reload needs a duration (zero seconds to disable)")
+			}
+			d, err := time.ParseDuration(remaining[0])
+			if err != nil {
+				return n, c.Errf("invalid duration for reload '%s'", remaining[0])
+			}
+			if d < 0 {
+				return n, c.Errf("invalid negative duration for reload '%s'", remaining[0])
+			}
+			reload = d
+		default:
+			return n, c.Errf("unknown property '%s'", c.Val())
+		}
+	}
+
+	// Records files without an origin of their own have names relative to the plugin's zone, like a
+	// zone file, so "www" served for example.com is www.example.com.
+	if origin == "" {
+		origin = n.Zones[0]
+	}
+
+	// Relative paths are resolved against the root directory, if one is configured, and globs are
+	// expanded. A pattern matching nothing is kept, so loading reports the missing file.
+	if len(paths) == 0 {
+		paths = []string{defaultPath}
+	}
+	newFiles := func(paths []string) ([]*Recordsfile, error) {
+		files := []*Recordsfile{}
+		for _, path := range paths {
+			if !filepath.IsAbs(path) && config.Root != "" {
+				path = filepath.Join(config.Root, path)
+			}
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, c.Errf("invalid records file pattern '%s'", path)
+			}
+			if len(matches) == 0 {
+				matches = []string{path}
+			}
+			for _, match := range matches {
+				f := &Recordsfile{
+					Path:       match,
+					format:     format,
+					origin:     origin,
+					zones:      n.Zones,
+					compressed: compressed || gzipped(match),
+					env:        env,
+					depth:      b.depth,
+					reload:     reload,
+				}
+				if f.format == "" {
+					f.format = fileFormat(match)
+				}
+				files = append(files, f)
+			}
+		}
+		return files, nil
+	}
+	files, err := newFiles(paths)
+	if err != nil {
+		return n, err
+	}
+	if len(viewPaths) > 0 && n.Zonefile != nil {
+		return n, c.Errf("view isn't supported with zonefile")
+	}
+	for server, paths := range viewPaths {
+		viewFiles, err := newFiles(paths)
+		if err != nil {
+			return n, err
+		}
+		n.views[server] = &JSONStore{Files: viewFiles, builder: b}
+	}
+
+	if n.shuffler != nil && (b.roundRobin || n.chash != nil) {
+		return n, c.Errf("shuffle can't be combined with round-robin or select")
+	}
+
+	// The version directive answers version queries only, unless chaos answers the host name too.
+	if version != "" {
+		if n.chaos == nil {
+			n.chaos = &chaos{}
+		}
+		n.chaos.version = version
+	}
+
+	// Upstream lookups are bounded by the timeout, wherever it's set in the block.
+	if n.upstream != nil {
+		n.upstream.timeout = timeout
+	}
+
+	if n.Zonefile != nil {
+		n.Zonefile.TTL = b.ttl
+	}
+	if n.catchAll != nil {
+		n.catchAll.ttl = b.ttl
+	}
+	if n.blocklist != nil && n.blocklist.sinkhole != nil {
+		n.blocklist.sinkhole.ttl = b.ttl
+	}
+	if keyfile != "" {
+		if n.Zonefile != nil {
+			return n, c.Errf("dnssec isn't supported with zonefile")
+		}
+		s, err := newSigner(keyfile, b.ttl)
+		if err != nil {
+			return n, c.Errf("unable to load DNSSEC key '%s': %v", keyfile, err)
+		}
+		n.signer = s
+	}
+
+	if serialMode != "" {
+		if len(n.SOA) == 0 {
+			return n, c.Errf("soa-serial needs soa")
+		}
+		for _, soa := range n.SOA {
+			serial, err := newSOASerial(serialMode, soa.Serial, serialPath)
+			if err != nil {
+				return n, c.Errf("unable to read the SOA serial: %v", err)
+			}
+			n.serial = serial
+			break
+		}
+	}
+	if overlayPath != "" {
+		if len(files) > 1 || n.Zonefile != nil || n.admin != nil {
+			return n, c.Errf("overlay is only supported for a single records file, without admin")
+		}
+		overlays, err := newFiles([]string{overlayPath})
+		if err != nil {
+			return n, err
+		}
+		if len(overlays) != 1 {
+			return n, c.Errf("overlay needs a single file, '%s' matches %d", overlayPath, len(overlays))
+		}
+		overlays[0].isOverlay = true
+		files[0].overlay = overlays[0]
+	}
+	if n.admin != nil {
+		if !recordsOnly(backends) || n.Zonefile != nil || len(files) > 1 {
+			return n, c.Errf("admin is only supported for a single records file")
+		}
+		n.admin.file = files[0]
+		n.admin.recent = n.recent
+	}
+	if n.recent != nil && n.admin == nil {
+		return n, c.Errf("query-log-size needs admin to list the queries")
+	}
+	if n.export != nil {
+		if !recordsOnly(backends) || n.Zonefile != nil || len(files) > 1 {
+			return n, c.Errf("export is only supported for a single records file")
+		}
+		n.export.file = files[0]
+	}
+
+	// Negative results of the backends are cached for the SOA minimum, unless set explicitly.
+	if negativeTTL == 0 {
+		for _, soa := range n.SOA {
+			negativeTTL = time.Duration(soa.Minttl) * time.Second
+		}
+	}
+
+	// Without a backend the records files are served, several backends are asked in order.
+	if len(backends) == 0 {
+		backends = []backendConfig{{kind: "records"}}
+	}
+	stack := []RecordStore{}
+	for _, bc := range backends {
+		store, cache, err := newBackend(bc, b, timeout, files)
+		if err != nil {
+			return n, err
+		}
+		if cache != nil {
+			if cacheTTL > 0 {
+				cache.setTTL(cacheTTL)
+			}
+			if negativeTTL > 0 {
+				cache.setNegativeTTL(negativeTTL)
+			}
+		}
+		stack = append(stack, store)
+	}
+	n.Store = stack[0]
+	if len(stack) > 1 {
+		n.Store = &StackedStore{Stores: stack}
+	}
+
+	return n, nil
+}
+
+// backendConfig is a backend of the directive: its type, location and options.
+type backendConfig struct {
+	kind, location string
+	plaintext      bool
+	prefix         string
+	watch          bool
+}
+
+// recordsOnly reports whether backends serve nothing but the records files.
+func recordsOnly(backends []backendConfig) bool {
+	for _, bc := range backends {
+		if bc.kind != "records" {
+			return false
+		}
+	}
+	return true
+}
+
+// newBackend returns the store of the backend bc, and its cache if it has one. The records backend
+// serves files.
+func newBackend(bc backendConfig, b builder, timeout time.Duration, files []*Recordsfile) (RecordStore, *recordCache, error) {
+	switch bc.kind {
+	case "sqlite":
+		s, err := NewSQLiteBackend(bc.location, b.ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.builder = b
+		return s, s.cache, nil
+	case "http":
+		h, err := NewHTTPBackend(bc.location, timeout, b.ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.builder = b
+		return h, h.cache, nil
+	case "redis":
+		r, err := NewRedisBackend(bc.location, timeout, b.ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.builder = b
+		return r, r.cache, nil
+	case "grpc":
+		g, err := NewGRPCBackend(bc.location, bc.plaintext, timeout, b.ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		g.builder = b
+		return g, g.cache, nil
+	case "consul":
+		k, err := NewConsulBackend(bc.location, bc.prefix, bc.watch, timeout, b.ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+		k.builder = b
+		return k, nil, nil
+	}
+	return &JSONStore{Files: files, builder: b}, nil, nil
+}
+
+// shutdown stops watching the records files and closes the admin API and the backend's connections.
+func (n Nightlightdns) shutdown() error {
+	var errs []error
+	for _, store := range n.allStores() {
+		if s, ok := store.(*JSONStore); ok {
+			for _, f := range s.Files {
+				errs = append(errs, f.stop())
+				if f.overlay != nil {
+					errs = append(errs, f.overlay.stop())
+				}
+			}
+		}
+	}
+	if n.admin != nil {
+		errs = append(errs, n.admin.Shutdown())
+	}
+	if n.export != nil {
+		errs = append(errs, n.export.Shutdown())
+	}
+	if c, ok := n.Store.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
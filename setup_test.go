@@ -0,0 +1,62 @@
+package nightlightdns
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/coredns/caddy"
+)
+
+// parseTest is the input of a nightlightdns directive and whether parsing it should fail.
+type parseTest struct {
+	input     string
+	shouldErr bool
+}
+
+// testParse checks that parse fails exactly for the inputs of tests that should.
+func testParse(t *testing.T, tests []parseTest) {
+	t.Helper()
+	for i, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		_, err := parse(c)
+		if tc.shouldErr && err == nil {
+			t.Errorf("Test %d: expected error for %q, got none", i, tc.input)
+		}
+		if !tc.shouldErr && err != nil {
+			t.Errorf("Test %d: expected no error for %q, got: %v", i, tc.input, err)
+		}
+	}
+}
+
+func TestSetupTTL(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nttl 60\n}", false},
+		{"nightlightdns {\nttl 0\n}", false},
+		{"nightlightdns {\nttl\n}", true},
+		{"nightlightdns {\nttl -1\n}", true},
+		{"nightlightdns {\nttl 4294967296\n}", true},
+		{"nightlightdns {\nttl 1m\n}", true},
+	})
+}
+
+func TestSetupRecordsFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		path    string
+		records string // Not written if empty.
+	}{
+		{"missing file", filepath.Join(dir, "missing.json"), ""},
+		{"malformed JSON", filepath.Join(dir, "malformed.json"), `{"records": [`},
+		{"wrong types", filepath.Join(dir, "types.json"), `{"records": {"name": "www"}}`},
+		{"malformed YAML", filepath.Join(dir, "malformed.yaml"), "records:\n  - name: [\n"},
+	}
+	for _, tc := range tests {
+		if tc.records != "" {
+			writeFile(t, dir, filepath.Base(tc.path), tc.records)
+		}
+		if err := setup(caddy.NewTestController("dns", "nightlightdns "+tc.path)); err == nil {
+			t.Errorf("Expected an error for a %s, got none", tc.name)
+		}
+	}
+}
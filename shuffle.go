@@ -0,0 +1,46 @@
+package nightlightdns
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// shuffler randomizes the order of the RRs in every answer, so caches and clients can't pin or
+// fingerprint an order, unlike round-robin which cycles through a predictable one.
+type shuffler struct {
+	sync.Mutex
+	rnd *rand.Rand
+}
+
+// newShuffler returns a shuffler with its own source, seeded from the clock.
+func newShuffler() *shuffler {
+	return &shuffler{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// shuffle reorders the RRs of every RRset in answers randomly, in place. The RRsets keep their
+// position, so CNAMEs still come before the records of their targets.
+func (s *shuffler) shuffle(answers []dns.RR) {
+	if s == nil || len(answers) < 2 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for start := 0; start < len(answers); {
+		end := start + 1
+		for end < len(answers) && sameRRset(answers[start], answers[end]) {
+			end++
+		}
+		rrset := answers[start:end]
+		s.rnd.Shuffle(len(rrset), func(i, j int) { rrset[i], rrset[j] = rrset[j], rrset[i] })
+		start = end
+	}
+}
+
+// sameRRset reports whether a and b belong to the same RRset.
+func sameRRset(a, b dns.RR) bool {
+	return a.Header().Rrtype == b.Header().Rrtype && a.Header().Name == b.Header().Name
+}
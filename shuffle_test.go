@@ -0,0 +1,94 @@
+package nightlightdns
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupShuffle(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nshuffle\n}", false},
+		{"nightlightdns {\nshuffle always\n}", true},
+		{"nightlightdns {\nshuffle\nround-robin\n}", true},
+		{"nightlightdns {\nselect chash\nshuffle\n}", true},
+	})
+}
+
+func TestShuffle(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1, 192.0.2.2, 192.0.2.3, 192.0.2.4, 192.0.2.5"},
+    {"name": "alias", "type": "CNAME", "target": "www"}
+  ]
+}`, "shuffle")
+	n.shuffler.rnd = rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		qname string
+		// cnames is the number of CNAMEs expected before the addresses.
+		cnames int
+	}{
+		{"www.example.com.", 0},
+		{"alias.example.com.", 1},
+	}
+	for i, tc := range tests {
+		orders := map[string]int{}
+		for q := 0; q < 50; q++ {
+			m := new(dns.Msg)
+			m.SetQuestion(tc.qname, dns.TypeA)
+			resp := exchange(n, m)
+			if err := test.CNAMEOrder(resp); err != nil {
+				t.Fatalf("Test %d: %v", i, err)
+			}
+			if len(resp.Answer) != tc.cnames+5 {
+				t.Fatalf("Test %d: expected %d answers, got %d", i, tc.cnames+5, len(resp.Answer))
+			}
+			order := []string{}
+			for _, rr := range resp.Answer[tc.cnames:] {
+				order = append(order, address(rr).String())
+			}
+			orders[strings.Join(order, " ")]++
+
+			// Only the order changes, never the addresses.
+			sort.Strings(order)
+			if got := strings.Join(order, " "); got != "192.0.2.1 192.0.2.2 192.0.2.3 192.0.2.4 192.0.2.5" {
+				t.Errorf("Test %d: expected all five addresses, got %s", i, got)
+			}
+		}
+		// There are 120 orders, 50 queries hit many of them.
+		if len(orders) < 20 {
+			t.Errorf("Test %d: expected the order to vary between queries, got %d orders", i, len(orders))
+		}
+	}
+}
+
+func TestShuffleRRsets(t *testing.T) {
+	s := &shuffler{rnd: rand.New(rand.NewSource(1))}
+	answers := []dns.RR{
+		test.CNAME("www.example.com. 30 IN CNAME app.example.com."),
+		test.A("app.example.com. 30 IN A 192.0.2.1"),
+		test.A("app.example.com. 30 IN A 192.0.2.2"),
+		test.A("app.example.com. 30 IN A 192.0.2.3"),
+		test.AAAA("app.example.com. 30 IN AAAA 2001:db8::1"),
+		test.AAAA("app.example.com. 30 IN AAAA 2001:db8::2"),
+	}
+	types := []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeA, dns.TypeA, dns.TypeAAAA, dns.TypeAAAA}
+	for q := 0; q < 20; q++ {
+		s.shuffle(answers)
+		for i, rr := range answers {
+			if rr.Header().Rrtype != types[i] {
+				t.Fatalf("Expected the RRsets to keep their position, got %v", answers)
+			}
+		}
+	}
+	// A nil shuffler leaves the order alone.
+	var none *shuffler
+	none.shuffle(answers)
+}
@@ -0,0 +1,163 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/miekg/dns"
+)
+
+// newSOA returns the SOA records for zones from the arguments of the soa directive: mname, rname,
+// serial, refresh, retry, expire and minttl. The minttl is also used as the TTL of the SOA itself,
+// so negative answers are cached for at most that long.
+func newSOA(args []string, zones []string) (map[string]*dns.SOA, error) {
+	if len(args) != 7 {
+		return nil, fmt.Errorf("soa needs mname, rname, serial, refresh, retry, expire and minttl")
+	}
+	for _, name := range args[:2] {
+		if _, ok := dns.IsDomainName(name); !ok {
+			return nil, fmt.Errorf("invalid soa name '%s'", name)
+		}
+	}
+	values := make([]uint32, 5)
+	for i, v := range args[2:] {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid soa number '%s'", v)
+		}
+		values[i] = uint32(n)
+	}
+
+	soa := make(map[string]*dns.SOA, len(zones))
+	for _, zone := range zones {
+		soa[zone] = &dns.SOA{
+			Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: values[4]},
+			Ns:      dns.Fqdn(args[0]),
+			Mbox:    dns.Fqdn(args[1]),
+			Serial:  values[0],
+			Refresh: values[1],
+			Retry:   values[2],
+			Expire:  values[3],
+			Minttl:  values[4],
+		}
+	}
+	return soa, nil
+}
+
+// Modes of soa-serial.
+const (
+	serialAuto = "auto"
+	serialDate = "date"
+)
+
+// soaSerial is the serial of the SOA records when it follows changes to the records, so secondaries
+// notice them. Every change increments it, or in date mode sets it to the current date in
+// YYYYMMDDnn form, counting up nn for changes on the same day. The serial never decreases, also not
+// over restarts if it's kept in a file.
+type soaSerial struct {
+	sync.Mutex
+	mode   string
+	serial uint32
+
+	// path, if set, is the file the serial is kept in.
+	path string
+
+	// now returns the current time, it's time.Now unless replaced.
+	now func() time.Time
+}
+
+// newSOASerial returns a soaSerial in mode starting at serial, or at the serial kept in path if that
+// is larger.
+func newSOASerial(mode string, serial uint32, path string) (*soaSerial, error) {
+	s := &soaSerial{mode: mode, serial: serial, path: path, now: time.Now}
+	if path == "" {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	kept, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serial in %s: %v", path, err)
+	}
+	s.atLeast(uint32(kept))
+	return s, nil
+}
+
+// atLeast raises the serial to serial, if it's lower.
+func (s *soaSerial) atLeast(serial uint32) {
+	s.Lock()
+	defer s.Unlock()
+	if serial > s.serial {
+		s.serial = serial
+	}
+}
+
+// bump advances the serial after the records changed.
+func (s *soaSerial) bump() {
+	s.Lock()
+	defer s.Unlock()
+	next := s.serial + 1
+	if s.mode == serialDate {
+		if today := dateSerial(s.now()); today > s.serial {
+			next = today
+		}
+	}
+	s.serial = next
+	if s.path == "" {
+		return
+	}
+	if err := ioutil.WriteFile(s.path, []byte(strconv.FormatUint(uint64(next), 10)+"\n"), 0644); err != nil {
+		log.Warningf("Failed to keep the SOA serial in %s: %v", s.path, err)
+	}
+}
+
+// get returns the current serial.
+func (s *soaSerial) get() uint32 {
+	s.Lock()
+	defer s.Unlock()
+	return s.serial
+}
+
+// dateSerial returns the first serial of the day of t in YYYYMMDDnn form.
+func dateSerial(t time.Time) uint32 {
+	y, m, d := t.Date()
+	return uint32(y*1000000 + int(m)*10000 + d*100)
+}
+
+// soa returns a copy of the SOA of zone, with the current serial if it follows the records, or nil
+// if there is none.
+func (n Nightlightdns) soa(zone string) dns.RR {
+	soa, ok := n.SOA[zone]
+	if !ok {
+		return nil
+	}
+	r := dns.Copy(soa).(*dns.SOA)
+	if n.serial != nil {
+		r.Serial = n.serial.get()
+	}
+	return r
+}
+
+// soaFor returns a copy of the SOA of the zone containing name, or nil if there is none.
+func (n Nightlightdns) soaFor(name string) dns.RR {
+	zones := make(plugin.Zones, 0, len(n.SOA))
+	for zone := range n.SOA {
+		zones = append(zones, zone)
+	}
+	zone := zones.Matches(name)
+	if zone == "" {
+		return nil
+	}
+	return n.soa(zone)
+}
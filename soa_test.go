@@ -0,0 +1,171 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// testSOA is the soa directive the tests configure.
+const testSOA = "soa ns1.example.com. hostmaster.example.com. 2021010101 7200 3600 1209600 300"
+
+func TestSetupSOA(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns example.com {\n" + testSOA + "\n}", false},
+		{"nightlightdns example.com {\nsoa ns1.example.com. hostmaster.example.com. 1 2 3 4\n}", true},
+		{"nightlightdns example.com {\nsoa ns1..example.com. hostmaster.example.com. 1 2 3 4 5\n}", true},
+		{"nightlightdns example.com {\nsoa ns1.example.com. hostmaster.example.com. 1 2 3 4 -5\n}", true},
+		{"nightlightdns example.com {\nsoa ns1.example.com. hostmaster.example.com. 4294967296 2 3 4 5\n}", true},
+	})
+}
+
+func TestSOA(t *testing.T) {
+	n := newRecordsPlugin(t, testRecords, testSOA)
+	soa := test.SOA("example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 2021010101 7200 3600 1209600 300")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "example.com.", Qtype: dns.TypeSOA,
+			Answer: []dns.RR{soa},
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{soa},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeTXT,
+			Ns: []dns.RR{soa},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+	})
+}
+
+func TestSetupSOASerial(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns example.com {\n" + testSOA + "\nsoa-serial auto\n}", false},
+		{"nightlightdns example.com {\n" + testSOA + "\nsoa-serial date /tmp/serial\n}", false},
+		{"nightlightdns example.com {\n" + testSOA + "\nsoa-serial\n}", true},
+		{"nightlightdns example.com {\n" + testSOA + "\nsoa-serial mtime\n}", true},
+		{"nightlightdns example.com {\nsoa-serial auto\n}", true},
+	})
+}
+
+// servedSerial returns the serial of the SOA n answers with.
+func servedSerial(t *testing.T, n Nightlightdns) uint32 {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeSOA)
+	resp := exchange(n, m)
+	if len(resp.Answer) != 1 {
+		t.Fatalf("Expected an SOA, got %v", resp.Answer)
+	}
+	return resp.Answer[0].(*dns.SOA).Serial
+}
+
+func TestSOASerial(t *testing.T) {
+	changed := strings.Replace(testRecords, "192.0.2.1", "192.0.2.100", 1)
+	tests := []struct {
+		records string
+		// bumped is set if the serial is expected to increase.
+		bumped bool
+	}{
+		{testRecords, false},
+		{changed, true},
+		{changed, false},
+		{`{"records": [`, false},
+		{testRecords, true},
+	}
+	for _, mode := range []string{serialAuto, serialDate} {
+		dir := t.TempDir()
+		path := writeFile(t, dir, "dns.json", testRecords)
+		kept := filepath.Join(dir, "serial")
+		n := newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com {\n%s\nsoa-serial %s %s\n}", path, testSOA, mode, kept))
+		f := n.Store.(*JSONStore).Files[0]
+
+		serial := servedSerial(t, n)
+		// The file was just written, in date mode the serial starts no lower than today. Otherwise
+		// it starts at the serial of the soa directive.
+		if today := dateSerial(time.Now()); mode == serialDate && serial < today {
+			t.Errorf("Mode %s: expected a serial of at least %d, got %d", mode, today, serial)
+		}
+		if mode == serialAuto && serial != 2021010101 {
+			t.Errorf("Mode %s: expected serial 2021010101, got %d", mode, serial)
+		}
+		for i, tc := range tests {
+			writeFile(t, dir, "dns.json", tc.records)
+			f.update()
+			next := servedSerial(t, n)
+			switch {
+			case tc.bumped && next != serial+1:
+				t.Errorf("Mode %s, test %d: expected serial %d after a change, got %d", mode, i, serial+1, next)
+			case !tc.bumped && next != serial:
+				t.Errorf("Mode %s, test %d: expected serial %d to stay, got %d", mode, i, serial, next)
+			}
+			serial = next
+		}
+
+		// The serial is kept over restarts.
+		n = newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com {\n%s\nsoa-serial %s %s\n}", path, testSOA, mode, kept))
+		if got := servedSerial(t, n); got != serial {
+			t.Errorf("Mode %s: expected the kept serial %d after a restart, got %d", mode, serial, got)
+		}
+	}
+}
+
+func TestSOASerialRoot(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "dns.json", testRecords)
+	writeFile(t, dir, "serial", "2021020304\n")
+
+	// The serial file is relative to the root, like the records file.
+	c := caddy.NewTestController("dns", fmt.Sprintf("nightlightdns %s example.com {\n%s\nsoa-serial auto serial\n}", path, testSOA))
+	dnsserver.GetConfig(c).Root = dir
+	if err := setup(c); err != nil {
+		t.Fatal(err)
+	}
+	plugins := dnsserver.GetConfig(c).Plugin
+	n := plugins[len(plugins)-1](test.ErrorHandler()).(Nightlightdns)
+	if got := servedSerial(t, n); got != 2021020304 {
+		t.Errorf("Expected the serial kept in the root, 2021020304, got %d", got)
+	}
+}
+
+func TestSOASerialDate(t *testing.T) {
+	s, err := newSOASerial(serialDate, 2021010101, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	tests := []struct {
+		now    time.Time
+		serial uint32
+	}{
+		// Changes on the same day count up nn.
+		{now, 2021010102},
+		{now, 2021010103},
+		{time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), 2021010200},
+		{time.Date(2021, 1, 2, 1, 0, 0, 0, time.UTC), 2021010201},
+		// A clock going backwards doesn't decrease the serial.
+		{time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC), 2021010202},
+	}
+	for i, tc := range tests {
+		now = tc.now
+		s.bump()
+		if got := s.get(); got != tc.serial {
+			t.Errorf("Test %d: expected serial %d, got %d", i, tc.serial, got)
+		}
+	}
+}
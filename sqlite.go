@@ -0,0 +1,120 @@
+package nightlightdns
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	// Register the pure Go "sqlite" database/sql driver.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteQuery selects the records of a name from the records table.
+const sqliteQuery = `SELECT name, type, ipaddress, ipv6address, target, text, preference, priority, weight, port, ttl
+FROM records WHERE name = ?`
+
+// SQLiteBackend is a RecordStore that reads records from the records table of an SQLite database.
+type SQLiteBackend struct {
+	builder
+
+	db    *sql.DB
+	stmt  *sql.Stmt
+	cache *recordCache
+}
+
+// NewSQLiteBackend opens the SQLite database at path read-only and prepares the lookup query. Records
+// without a TTL are answered with ttl.
+func NewSQLiteBackend(path string, ttl uint32) (*SQLiteBackend, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := db.Prepare(sqliteQuery)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to prepare query on %q: %v", path, err)
+	}
+	return &SQLiteBackend{
+		builder: builder{ttl: ttl, rr: newRotator()},
+		db:      db,
+		stmt:    stmt,
+		cache:   newRecordCache("sqlite", ttl, backendCacheSize),
+	}, nil
+}
+
+// Lookup implements RecordStore.
+func (s *SQLiteBackend) Lookup(name string, qtype uint16) ([]dns.RR, error) {
+	return s.answer(name, qtype, s.records)
+}
+
+// records returns the records of name, from the cache if they were queried recently.
+func (s *SQLiteBackend) records(name string) ([]DNSRecord, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+
+	if records, ok := s.cache.get(name); ok {
+		return records, nil
+	}
+
+	records, err := s.query(name)
+	if err != nil {
+		backendFailures.WithLabelValues("sqlite").Inc()
+		return nil, err
+	}
+	s.cache.set(name, records)
+
+	return records, nil
+}
+
+// query reads the records of name from the database.
+func (s *SQLiteBackend) query(name string) ([]DNSRecord, error) {
+	rows, err := s.stmt.Query(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []DNSRecord{}
+	for rows.Next() {
+		var (
+			record                            DNSRecord
+			rtype, ip, ip6, target, text      sql.NullString
+			preference, priority, weight, ttl sql.NullInt64
+			port                              sql.NullInt64
+		)
+		if err := rows.Scan(&record.Name, &rtype, &ip, &ip6, &target, &text, &preference, &priority, &weight, &port, &ttl); err != nil {
+			return nil, err
+		}
+		record.Type = rtype.String
+		record.Ipaddress = ip.String
+		record.Ipv6address = ip6.String
+		if target.Valid && target.String != "" {
+			record.Target = qualify(target.String, ".")
+		}
+		if text.Valid {
+			record.Text = stringList{text.String}
+		}
+		record.Preference = uint16(preference.Int64)
+		record.Priority = uint16(priority.Int64)
+		record.Weight = uint16(weight.Int64)
+		if port.Valid {
+			record.Port = &port.Int64
+		}
+		if ttl.Valid {
+			record.TTL = &ttl.Int64
+		}
+		if err := record.validate("."); err != nil {
+			log.Warningf("Skipping invalid record %q from database: %v", record.Name, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// Close closes the database.
+func (s *SQLiteBackend) Close() error {
+	s.stmt.Close()
+	return s.db.Close()
+}
@@ -0,0 +1,78 @@
+package nightlightdns
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// newMemorySQLiteBackend returns an SQLiteBackend on an in-memory database holding the records
+// inserted by the statements of inserts.
+func newMemorySQLiteBackend(t *testing.T, inserts ...string) *SQLiteBackend {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: has a database of its own.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	statements := append([]string{`CREATE TABLE records (name TEXT, type TEXT, ipaddress TEXT, ipv6address TEXT,
+target TEXT, text TEXT, preference INTEGER, priority INTEGER, weight INTEGER, port INTEGER, ttl INTEGER)`}, inserts...)
+	for _, s := range statements {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatalf("Failed to execute %q: %v", s, err)
+		}
+	}
+	stmt, err := db.Prepare(sqliteQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SQLiteBackend{
+		builder: builder{ttl: defaultTTL, rr: newRotator()},
+		db:      db,
+		stmt:    stmt,
+		cache:   newRecordCache("sqlite", defaultTTL, backendCacheSize),
+	}
+}
+
+func TestSQLiteBackend(t *testing.T) {
+	s := newMemorySQLiteBackend(t,
+		`INSERT INTO records (name, ipaddress, ipv6address) VALUES ('www.example.com.', '192.0.2.1', '2001:db8::1')`,
+		`INSERT INTO records (name, type, target, preference, ttl) VALUES ('example.com.', 'MX', 'mail.example.com', 10, 300)`,
+		`INSERT INTO records (name, type, text) VALUES ('www.example.com.', 'TXT', 'hello')`,
+		`INSERT INTO records (name, ipaddress) VALUES ('bad.example.com.', 'not-an-ip')`,
+	)
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: s, Compress: true}
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "WWW.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("www.example.com. 30 IN AAAA 2001:db8::1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`www.example.com. 30 IN TXT "hello"`)},
+		},
+		{
+			Qname: "example.com.", Qtype: dns.TypeMX,
+			Answer: []dns.RR{test.MX("example.com. 300 IN MX 10 mail.example.com.")},
+		},
+		// Invalid rows are skipped.
+		{
+			Qname: "bad.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+}
@@ -0,0 +1,122 @@
+package nightlightdns
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// StackedStore is the RecordStore of several backends, asked in order until one of them answers.
+// A backend that fails is skipped, so a fallback keeps answering while the primary is down.
+type StackedStore struct {
+	Stores []RecordStore
+}
+
+// Lookup implements RecordStore. The first non-empty answer wins. Without one the result is NODATA
+// if any backend knows the name and NXDOMAIN otherwise, only when every backend failed the query
+// fails too.
+func (s *StackedStore) Lookup(name string, qtype uint16) ([]dns.RR, error) {
+	answers, _, err := s.lookup(name, func(store RecordStore) ([]dns.RR, uint8, error) {
+		answers, err := store.Lookup(name, qtype)
+		return answers, 0, err
+	})
+	return answers, err
+}
+
+// LookupSubnet implements SubnetStore, asking the backends that don't tailor their answers to the
+// client with Lookup.
+func (s *StackedStore) LookupSubnet(name string, qtype uint16, client net.IP) ([]dns.RR, uint8, error) {
+	return s.lookup(name, func(store RecordStore) ([]dns.RR, uint8, error) {
+		if ss, ok := store.(SubnetStore); ok {
+			return ss.LookupSubnet(name, qtype, client)
+		}
+		answers, err := store.Lookup(name, qtype)
+		return answers, 0, err
+	})
+}
+
+// LookupRegion implements RegionStore, asking the backends without regions with LookupSubnet or
+// Lookup.
+func (s *StackedStore) LookupRegion(name string, qtype uint16, client net.IP, region string) ([]dns.RR, uint8, error) {
+	return s.lookup(name, func(store RecordStore) ([]dns.RR, uint8, error) {
+		switch rs := store.(type) {
+		case RegionStore:
+			return rs.LookupRegion(name, qtype, client, region)
+		case SubnetStore:
+			return rs.LookupSubnet(name, qtype, client)
+		}
+		answers, err := store.Lookup(name, qtype)
+		return answers, 0, err
+	})
+}
+
+// lookup asks the backends in order with ask, as described for Lookup. The scope is that of the
+// answer that won, or the largest one of the backends that know the name without an answer.
+func (s *StackedStore) lookup(name string, ask func(RecordStore) ([]dns.RR, uint8, error)) ([]dns.RR, uint8, error) {
+	exists, failed := false, 0
+	var (
+		lastErr error
+		scope   uint8
+	)
+	for i, store := range s.Stores {
+		answers, sc, err := ask(store)
+		switch {
+		case err == nil && len(answers) > 0:
+			return answers, sc, nil
+		case err == nil:
+			exists = true
+			if sc > scope {
+				scope = sc
+			}
+		case errors.Is(err, ErrNoSuchName):
+		case err == errDNAMETooLong:
+			return nil, 0, err
+		default:
+			log.Warningf("Backend %d failed to look up %s, trying the next one: %v", i+1, name, err)
+			failed++
+			lastErr = err
+		}
+	}
+	switch {
+	case exists:
+		return nil, scope, nil
+	case failed == len(s.Stores):
+		return nil, 0, lastErr
+	}
+	return nil, 0, ErrNoSuchName
+}
+
+// Delegation implements Delegator. The first backend delegating name wins.
+func (s *StackedStore) Delegation(zone, name string) (nss, glue []dns.RR) {
+	for _, store := range s.Stores {
+		if d, ok := store.(Delegator); ok {
+			if nss, glue := d.Delegation(zone, name); len(nss) > 0 {
+				return nss, glue
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Close closes the connections of all backends.
+func (s *StackedStore) Close() error {
+	var first error
+	for _, store := range s.Stores {
+		if c, ok := store.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// stores returns the backends of store, or store itself if it isn't stacked.
+func stores(store RecordStore) []RecordStore {
+	if s, ok := store.(*StackedStore); ok {
+		return s.Stores
+	}
+	return []RecordStore{store}
+}
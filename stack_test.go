@@ -0,0 +1,120 @@
+package nightlightdns
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestStackedStore(t *testing.T) {
+	down := &mockStore{err: errors.New("backend down")}
+	primary := newMockStore(
+		"www.example.com. 60 IN A 192.0.2.1",
+		"app.example.com. 60 IN AAAA 2001:db8::2",
+	)
+	fallback := newMockStore(
+		"www.example.com. 60 IN A 192.0.2.10",
+		"app.example.com. 60 IN A 192.0.2.20",
+		"old.example.com. 60 IN A 192.0.2.30",
+	)
+	tests := []struct {
+		stores []RecordStore
+		tc     test.Case
+	}{
+		// The first answer wins.
+		{[]RecordStore{primary, fallback}, test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 60 IN A 192.0.2.1")},
+		}},
+		// The fallback answers when the primary has nothing, or doesn't know the name at all.
+		{[]RecordStore{primary, fallback}, test.Case{
+			Qname: "app.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("app.example.com. 60 IN A 192.0.2.20")},
+		}},
+		{[]RecordStore{primary, fallback}, test.Case{
+			Qname: "old.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("old.example.com. 60 IN A 192.0.2.30")},
+		}},
+		{[]RecordStore{down, fallback}, test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 60 IN A 192.0.2.10")},
+		}},
+		{[]RecordStore{primary, fallback}, test.Case{
+			Qname: "old.example.com.", Qtype: dns.TypeAAAA,
+		}},
+		{[]RecordStore{primary, fallback}, test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		}},
+		// A failing backend doesn't fail the query, unless all of them fail.
+		{[]RecordStore{down, fallback}, test.Case{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		}},
+		{[]RecordStore{primary, down}, test.Case{
+			Qname: "app.example.com.", Qtype: dns.TypeA,
+		}},
+		{[]RecordStore{down, down}, test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		}},
+	}
+	for i, tc := range tests {
+		n := Nightlightdns{Zones: []string{"example.com."}, Store: &StackedStore{Stores: tc.stores}}
+		if err := test.SortAndCheck(exchange(n, tc.tc.Msg()), tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
+
+func TestSetupStackedBackends(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("name") {
+		case "www.example.com.":
+			w.Write([]byte(`{"records": [{"name": "www.example.com.", "ipaddress": "192.0.2.1"}]}`))
+		case "fail.example.com.":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"records": []}`))
+		}
+	}))
+	defer primary.Close()
+
+	path := writeFile(t, t.TempDir(), "dns.json", `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.10"},
+    {"name": "static", "ipaddress": "192.0.2.20"},
+    {"name": "fail", "ipaddress": "192.0.2.30"}
+  ]
+}`)
+	n := newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com {\nbackend http %s\nbackend records\n}", path, primary.URL))
+	if s, ok := n.Store.(*StackedStore); !ok || len(s.Stores) != 2 {
+		t.Fatalf("Expected two stacked backends, got %T", n.Store)
+	}
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "static.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("static.example.com. 30 IN A 192.0.2.20")},
+		},
+		{
+			Qname: "fail.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("fail.example.com. 30 IN A 192.0.2.30")},
+		},
+	})
+
+	testParse(t, []parseTest{
+		{fmt.Sprintf("nightlightdns %s {\nbackend records\nbackend http %s\n}", path, primary.URL), false},
+		{fmt.Sprintf("nightlightdns %s {\nbackend records\nbackend records\n}", path), true},
+	})
+}
@@ -0,0 +1,236 @@
+package nightlightdns
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+
+	"github.com/miekg/dns"
+)
+
+// defaultTimeout bounds how long a remote backend may take to answer.
+const defaultTimeout = 2 * time.Second
+
+// ErrNoSuchName is returned by a RecordStore when the queried name doesn't exist. The plugin answers
+// NXDOMAIN, or falls through if configured.
+var ErrNoSuchName = errors.New("no such name")
+
+// RecordStore is a source of records, such as the records file or a database.
+type RecordStore interface {
+	// Lookup returns the RRs answering a query for name and qtype. The name is lowercased and fully
+	// qualified. An empty result for a name that exists gets a NODATA response, a name that doesn't
+	// exist should be reported with ErrNoSuchName. Any other error results in SERVFAIL.
+	Lookup(name string, qtype uint16) ([]dns.RR, error)
+}
+
+// JSONStore is the RecordStore serving the records files. The records of all files are served
+// together.
+type JSONStore struct {
+	Files []*Recordsfile
+	builder
+}
+
+// readRecords loads all records files and their overlays, failing on the first one that can't be
+// loaded.
+func (s *JSONStore) readRecords() error {
+	for _, f := range s.Files {
+		if f.overlay != nil {
+			if err := f.overlay.readRecords(); err != nil {
+				return err
+			}
+			f.overlay.onReload = f.reapplyOverlay
+		}
+		if err := f.readRecords(); err != nil {
+			return err
+		}
+		f.onReload = s.warnCollisions
+	}
+	s.warnCollisions()
+	return nil
+}
+
+// warnCollisions logs a warning for every name and type that is found in more than one records file.
+func (s *JSONStore) warnCollisions() {
+	if len(s.Files) < 2 {
+		return
+	}
+	seen := make(map[string]string)
+	for _, f := range s.Files {
+		data := f.Records()
+		for _, record := range data.Records {
+			if record.Disabled {
+				continue
+			}
+			name := qualify(record.Name, data.Origin)
+			key := record.kind() + " " + name
+			if path, ok := seen[key]; ok && path != f.Path {
+				log.Warningf("%s record for %q in %s is also in %s", record.kind(), name, f.Path, path)
+				continue
+			}
+			seen[key] = f.Path
+		}
+	}
+}
+
+// LookupName returns the records with the given name from all records files. Wildcards are only
+// used if the name doesn't exist in any of the files, not even as an empty non-terminal.
+func (s *JSONStore) LookupName(name string) []DNSRecord {
+	records := []DNSRecord{}
+	for _, f := range s.Files {
+		records = append(records, f.lookupExact(name)...)
+	}
+	if len(records) > 0 || s.exists(name) {
+		return records
+	}
+	for _, f := range s.Files {
+		records = append(records, f.LookupName(name)...)
+	}
+	return records
+}
+
+// exists reports whether name exists in any of the records files, possibly as an empty
+// non-terminal.
+func (s *JSONStore) exists(name string) bool {
+	for _, f := range s.Files {
+		if f.exists(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupAddr returns the names that have the address addr, in any of the records files.
+func (s *JSONStore) LookupAddr(addr string) []string {
+	names := []string{}
+	for _, f := range s.Files {
+		for _, name := range f.LookupAddr(addr) {
+			names = appendUnique(names, name)
+		}
+	}
+	return names
+}
+
+// Ready reports whether all records files are ready.
+func (s *JSONStore) Ready() bool {
+	for _, f := range s.Files {
+		if !f.Ready() || (f.overlay != nil && !f.overlay.Ready()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup implements RecordStore.
+func (s *JSONStore) Lookup(name string, qtype uint16) ([]dns.RR, error) {
+	answers, _, err := s.LookupSubnet(name, qtype, nil)
+	return answers, err
+}
+
+// LookupSubnet implements SubnetStore.
+func (s *JSONStore) LookupSubnet(name string, qtype uint16, client net.IP) ([]dns.RR, uint8, error) {
+	return s.LookupRegion(name, qtype, client, "")
+}
+
+// LookupRegion implements RegionStore.
+func (s *JSONStore) LookupRegion(name string, qtype uint16, client net.IP, region string) ([]dns.RR, uint8, error) {
+	return s.lookup(name, qtype, client, region, 0)
+}
+
+// lookup answers LookupRegion, depth is the number of DNAMEs followed to get to name.
+func (s *JSONStore) lookup(name string, qtype uint16, client net.IP, region string, depth int) ([]dns.RR, uint8, error) {
+	// Names below a DNAME are redirected to the same name below its target.
+	if owner, record := s.dname(name); record != nil {
+		return s.redirect(name, qtype, client, region, depth, owner, *record)
+	}
+
+	// Reverse lookups are answered from the addresses of the records.
+	if qtype == dns.TypePTR {
+		names := s.LookupAddr(dnsutil.ExtractAddressFromReverse(name))
+		if len(names) == 0 {
+			return nil, 0, ErrNoSuchName
+		}
+		answers := make([]dns.RR, len(names))
+		for i, n := range names {
+			answers[i] = ptr(name, s.ttl, n)
+		}
+		return answers, 0, nil
+	}
+
+	if len(s.LookupName(name)) == 0 {
+		// Empty non-terminals exist, they get NODATA rather than NXDOMAIN so resolvers minimizing
+		// query names, as described in RFC 7816, continue below them.
+		if s.exists(name) {
+			return nil, 0, nil
+		}
+		return nil, 0, ErrNoSuchName
+	}
+
+	var scope uint8
+	answers, err := s.answer(name, qtype, func(name string) ([]DNSRecord, error) {
+		records, sc := selectSubnet(selectRegion(s.LookupName(name), region), client)
+		if sc > scope {
+			scope = sc
+		}
+		return records, nil
+	})
+	if err == ErrNoSuchName {
+		// The name exists, but has no records for this client.
+		return nil, scope, nil
+	}
+	return answers, scope, err
+}
+
+// dname returns the DNAME record of the closest ancestor of name that has one, and that ancestor.
+// The record is nil if name isn't below a DNAME.
+func (s *JSONStore) dname(name string) (string, *DNSRecord) {
+	name = strings.ToLower(dns.Fqdn(name))
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		owner := name[off:]
+		for _, f := range s.Files {
+			records := s.active(f.lookupExact(owner))
+			for i := range records {
+				if records[i].kind() == "DNAME" {
+					return owner, &records[i]
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// redirect answers a query for name below owner, which has the DNAME record, as described in RFC
+// 6672: with the DNAME, a CNAME synthesized from it and whatever the CNAME's target resolves to
+// locally. A synthesized name that is too long results in errDNAMETooLong.
+func (s *JSONStore) redirect(name string, qtype uint16, client net.IP, region string, depth int, owner string, record DNSRecord) ([]dns.RR, uint8, error) {
+	limit := s.depth
+	if limit == 0 {
+		limit = defaultCNAMEDepth
+	}
+	if depth == limit {
+		return nil, 0, errCNAMEDepth
+	}
+
+	name = strings.ToLower(dns.Fqdn(name))
+	target := strings.TrimSuffix(name, owner) + record.Target
+	if len(target) > 255 {
+		return nil, 0, errDNAMETooLong
+	}
+	ttl := s.recordTTL(record)
+	answers := []dns.RR{dname(owner, ttl, record.Target), cname(name, ttl, target)}
+	if qtype == dns.TypeCNAME || qtype == dns.TypeDNAME {
+		return answers, 0, nil
+	}
+
+	rest, scope, err := s.lookup(target, qtype, client, region, depth+1)
+	if err == ErrNoSuchName {
+		// The target isn't ours, the resolver continues from the CNAME.
+		return answers, scope, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(answers, rest...), scope, nil
+}
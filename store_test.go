@@ -0,0 +1,203 @@
+package nightlightdns
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// mockStore is a RecordStore answering from rrs, keyed by name and type. Names in rrs exist even
+// without RRs of the queried type. If err is set every lookup fails with it, after delay.
+type mockStore struct {
+	rrs   map[string]map[uint16][]dns.RR
+	err   error
+	delay time.Duration
+}
+
+// newMockStore returns a mockStore holding the RRs parsed from the records in zone file format.
+func newMockStore(records ...string) *mockStore {
+	s := &mockStore{rrs: map[string]map[uint16][]dns.RR{}}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			panic(err)
+		}
+		name := rr.Header().Name
+		if s.rrs[name] == nil {
+			s.rrs[name] = map[uint16][]dns.RR{}
+		}
+		s.rrs[name][rr.Header().Rrtype] = append(s.rrs[name][rr.Header().Rrtype], rr)
+	}
+	return s
+}
+
+// Lookup implements RecordStore.
+func (s *mockStore) Lookup(name string, qtype uint16) ([]dns.RR, error) {
+	time.Sleep(s.delay)
+	if s.err != nil {
+		return nil, s.err
+	}
+	types, ok := s.rrs[name]
+	if !ok {
+		return nil, ErrNoSuchName
+	}
+	return types[qtype], nil
+}
+
+func TestMockStore(t *testing.T) {
+	s := newMockStore("www.example.com. 60 IN A 192.0.2.1")
+	n := Nightlightdns{Zones: []string{"example.com."}, Store: s}
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 60 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	})
+
+	s.err = errors.New("backend down")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeServerFailure,
+		},
+	})
+}
+
+func TestMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.json", `{"records": [
+  {"name": "www", "ipaddress": "192.0.2.1"},
+  {"name": "shared", "ipaddress": "192.0.2.10"}
+]}`)
+	b := writeFile(t, dir, "b.json", `{"origin": "example.com.", "records": [
+  {"name": "app", "ipaddress": "192.0.2.2"},
+  {"name": "shared", "ipaddress": "192.0.2.20"}
+]}`)
+	cases := []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "app.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("app.example.com. 30 IN A 192.0.2.2")},
+		},
+		// Names in both files are answered from both.
+		{
+			Qname: "shared.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("shared.example.com. 30 IN A 192.0.2.10"),
+				test.A("shared.example.com. 30 IN A 192.0.2.20"),
+			},
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		},
+	}
+	for _, corefile := range []string{
+		fmt.Sprintf("nightlightdns example.com {\nfile %s\nfile %s\n}", a, b),
+		fmt.Sprintf("nightlightdns example.com {\nfile %s %s\n}", a, b),
+		fmt.Sprintf("nightlightdns example.com {\nfile %s\n}", filepath.Join(dir, "*.json")),
+	} {
+		n := newTestPlugin(t, corefile)
+		if files := len(n.Store.(*JSONStore).Files); files != 2 {
+			t.Errorf("Expected 2 records files for %q, got %d", corefile, files)
+		}
+		checkCases(t, n, cases)
+	}
+}
+
+func TestDNAME(t *testing.T) {
+	long := strings.Repeat("a", 60) + "." + strings.Repeat("b", 60) + "." + strings.Repeat("c", 60) + ".example.com."
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "old", "type": "DNAME", "target": "new.example.com.", "ttl": 60},
+    {"name": "www.new", "ipaddress": "192.0.2.1"},
+    {"name": "ext", "type": "DNAME", "target": "example.net."},
+    {"name": "long", "type": "DNAME", "target": "`+long+`"},
+    {"name": "one", "type": "DNAME", "target": "two.example.com."},
+    {"name": "two", "type": "DNAME", "target": "new.example.com."}
+  ]
+}`)
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.old.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.DNAME("old.example.com. 60 IN DNAME new.example.com."),
+				test.A("www.new.example.com. 30 IN A 192.0.2.1"),
+				test.CNAME("www.old.example.com. 60 IN CNAME www.new.example.com."),
+			},
+		},
+		// The DNAME owner itself isn't redirected.
+		{
+			Qname: "old.example.com.", Qtype: dns.TypeDNAME,
+			Answer: []dns.RR{test.DNAME("old.example.com. 60 IN DNAME new.example.com.")},
+		},
+		{
+			Qname: "old.example.com.", Qtype: dns.TypeA,
+		},
+		{
+			Qname: "www.old.example.com.", Qtype: dns.TypeCNAME,
+			Answer: []dns.RR{
+				test.DNAME("old.example.com. 60 IN DNAME new.example.com."),
+				test.CNAME("www.old.example.com. 60 IN CNAME www.new.example.com."),
+			},
+		},
+		// Targets that don't exist, or aren't ours, end the answer at the CNAME.
+		{
+			Qname: "nope.old.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("nope.old.example.com. 60 IN CNAME nope.new.example.com."),
+				test.DNAME("old.example.com. 60 IN DNAME new.example.com."),
+			},
+		},
+		{
+			Qname: "www.ext.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.DNAME("ext.example.com. 30 IN DNAME example.net."),
+				test.CNAME("www.ext.example.com. 30 IN CNAME www.example.net."),
+			},
+		},
+		// DNAMEs are followed through to others.
+		{
+			Qname: "www.one.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.DNAME("one.example.com. 30 IN DNAME two.example.com."),
+				test.DNAME("two.example.com. 30 IN DNAME new.example.com."),
+				test.A("www.new.example.com. 30 IN A 192.0.2.1"),
+				test.CNAME("www.one.example.com. 30 IN CNAME www.two.example.com."),
+				test.CNAME("www.two.example.com. 30 IN CNAME www.new.example.com."),
+			},
+		},
+		{
+			Qname: strings.Repeat("d", 60) + ".long.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeYXDomain,
+		},
+	})
+
+	// SortAndCheck sorts the answers, the DNAME comes before the CNAME synthesized from it.
+	m := new(dns.Msg)
+	m.SetQuestion("www.old.example.com.", dns.TypeA)
+	resp := exchange(n, m)
+	for i, rrtype := range []uint16{dns.TypeDNAME, dns.TypeCNAME, dns.TypeA} {
+		if i >= len(resp.Answer) || resp.Answer[i].Header().Rrtype != rrtype {
+			t.Fatalf("Expected the answer in DNAME, CNAME, A order, got %v", resp.Answer)
+		}
+	}
+}
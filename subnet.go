@@ -0,0 +1,105 @@
+package nightlightdns
+
+import (
+	"net"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// SubnetStore is a RecordStore that can tailor its answers to the subnet of the client, as sent in
+// the EDNS Client Subnet (ECS) option.
+type SubnetStore interface {
+	RecordStore
+
+	// LookupSubnet is like Lookup, but answers with the records for client, which may be nil. The
+	// returned scope is the prefix length the answer is valid for, to be echoed back in the ECS
+	// option.
+	LookupSubnet(name string, qtype uint16, client net.IP) ([]dns.RR, uint8, error)
+}
+
+// clientSubnet returns the ECS option of r, or nil if it has none.
+func clientSubnet(r *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// client returns the address of the client that sent the query of state, and its ECS option, if
+// any. Without trusted proxies that's the source address of the query and any ECS option is used.
+// With them, the ECS option of queries from a trusted proxy gives the client the proxy forwarded
+// the query for, and the ECS options of other sources are ignored.
+func (n Nightlightdns) client(state request.Request) (net.IP, *dns.EDNS0_SUBNET) {
+	src := net.ParseIP(state.IP())
+	ecs := clientSubnet(state.Req)
+	if n.TrustedProxies == nil {
+		return src, ecs
+	}
+	if ecs == nil || !n.TrustedProxies.Allowed(src) {
+		return src, nil
+	}
+	return ecs.Address, ecs
+}
+
+// selectSubnet returns the records of a name that apply to client. Records whose subnets contain
+// client are used, those with the longest matching prefix win. Without a match the records that
+// don't list subnets are the default. The returned scope is the matching prefix length; for default
+// answers it's the longest prefix among the subnets of client's family, so resolvers don't cache the
+// default for clients that would get a different answer.
+func selectSubnet(records []DNSRecord, client net.IP) ([]DNSRecord, uint8) {
+	best, longest := -1, 0
+	for _, record := range records {
+		for _, s := range record.Subnets {
+			_, subnet, err := net.ParseCIDR(s)
+			if err != nil || (subnet.IP.To4() == nil) != (client.To4() == nil) {
+				continue
+			}
+			ones, _ := subnet.Mask.Size()
+			if ones > longest {
+				longest = ones
+			}
+			if client != nil && subnet.Contains(client) && ones > best {
+				best = ones
+			}
+		}
+	}
+
+	selected := []DNSRecord{}
+	for _, record := range records {
+		if best < 0 && len(record.Subnets) == 0 {
+			selected = append(selected, record)
+			continue
+		}
+		if best >= 0 && record.matches(client, best) {
+			selected = append(selected, record)
+		}
+	}
+	if best < 0 {
+		if client == nil {
+			return selected, 0
+		}
+		return selected, uint8(longest)
+	}
+	return selected, uint8(best)
+}
+
+// matches reports whether one of the subnets of r has a prefix of length ones containing client.
+func (r DNSRecord) matches(client net.IP, ones int) bool {
+	for _, s := range r.Subnets {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			continue
+		}
+		if n, _ := subnet.Mask.Size(); n == ones && subnet.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
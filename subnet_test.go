@@ -0,0 +1,156 @@
+package nightlightdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// ecsQuery returns an A query for name carrying an ECS option for subnet, a CIDR.
+func ecsQuery(name, subnet string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	m.SetEdns0(4096, false)
+	ip, ipnet, _ := net.ParseCIDR(subnet)
+	ones, _ := ipnet.Mask.Size()
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: uint8(ones), Address: ip.Mask(ipnet.Mask)}
+	if ip.To4() == nil {
+		ecs.Family = 2
+	}
+	o := m.IsEdns0()
+	o.Option = append(o.Option, ecs)
+	return m
+}
+
+// responseScope returns the scope of the ECS option of resp, -1 if it has none.
+func responseScope(resp *dns.Msg) int {
+	if opt := resp.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				return int(ecs.SourceScope)
+			}
+		}
+	}
+	return -1
+}
+
+func TestClientSubnet(t *testing.T) {
+	n := newRecordsPlugin(t, `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "www", "ipaddress": "10.0.0.1", "subnets": ["10.0.0.0/8"]},
+    {"name": "www", "ipaddress": "10.1.0.1", "subnets": ["10.1.0.0/16"]}
+  ]
+}`)
+	tests := []struct {
+		subnet  string // Empty for a query without ECS.
+		address string
+		scope   int
+	}{
+		{"10.1.2.0/24", "10.1.0.1", 16},
+		{"10.2.3.0/24", "10.0.0.1", 8},
+		{"172.16.1.0/24", "192.0.2.1", 16},
+		{"2001:db8::/56", "192.0.2.1", 0},
+		{"", "192.0.2.1", -1},
+	}
+	for i, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		if tc.subnet != "" {
+			m = ecsQuery("www.example.com.", tc.subnet)
+		}
+		resp := exchange(n, m)
+		if len(resp.Answer) != 1 {
+			t.Errorf("Test %d: expected 1 answer, got %d", i, len(resp.Answer))
+			continue
+		}
+		if got := resp.Answer[0].(*dns.A).A.String(); got != tc.address {
+			t.Errorf("Test %d: expected %s for %q, got %s", i, tc.address, tc.subnet, got)
+		}
+		if got := responseScope(resp); got != tc.scope {
+			t.Errorf("Test %d: expected scope %d for %q, got %d", i, tc.scope, tc.subnet, got)
+		}
+	}
+}
+
+func TestSetupTrustedProxies(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\ntrusted-proxies 10.0.0.0/8 2001:db8::/32\n}", false},
+		{"nightlightdns {\ntrusted-proxies\n}", true},
+		{"nightlightdns {\ntrusted-proxies load-balancer\n}", true},
+	})
+}
+
+func TestTrustedProxies(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "192.0.2.1"},
+    {"name": "www", "ipaddress": "10.0.0.1", "subnets": ["198.51.100.0/24"]}
+  ]
+}`
+	// The test client, the proxy, is 10.240.0.1. Only clients in 198.51.100.0/24 are allowed.
+	tests := []struct {
+		options string
+		subnet  string
+		rcode   int
+		address string
+	}{
+		{"", "198.51.100.0/24", dns.RcodeRefused, ""},
+		{"trusted-proxies 10.240.0.0/16", "198.51.100.0/24", dns.RcodeSuccess, "10.0.0.1"},
+		{"trusted-proxies 10.240.0.0/16", "203.0.113.0/24", dns.RcodeRefused, ""},
+		{"trusted-proxies 10.240.0.0/16", "", dns.RcodeRefused, ""},
+		{"trusted-proxies 172.16.0.0/12", "198.51.100.0/24", dns.RcodeRefused, ""},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, "acl allow 198.51.100.0/24\nacl default deny", tc.options)
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		if tc.subnet != "" {
+			m = ecsQuery("www.example.com.", tc.subnet)
+		}
+		resp := exchange(n, m)
+		if resp.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		if tc.address != "" && (len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != tc.address) {
+			t.Errorf("Test %d: expected %s, got %v", i, tc.address, resp.Answer)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	proxies := &ACL{deny: true}
+	if err := proxies.add([]string{"allow", "10.240.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		proxies *ACL
+		subnet  string
+		client  string
+	}{
+		{nil, "", "10.240.0.1"},
+		{nil, "198.51.100.0/24", "10.240.0.1"},
+		{proxies, "", "10.240.0.1"},
+		{proxies, "198.51.100.0/24", "198.51.100.0"},
+		{proxies, "2001:db8::/56", "2001:db8::"},
+	}
+	for i, tc := range tests {
+		n := Nightlightdns{TrustedProxies: tc.proxies}
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		if tc.subnet != "" {
+			m = ecsQuery("www.example.com.", tc.subnet)
+		}
+		client, _ := n.client(request.Request{W: &test.ResponseWriter{}, Req: m})
+		if client.String() != tc.client {
+			t.Errorf("Test %d: expected client %s, got %s", i, tc.client, client)
+		}
+	}
+}
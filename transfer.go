@@ -0,0 +1,108 @@
+package nightlightdns
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// Transferer is a RecordStore whose zones can be copied by secondaries with AXFR.
+type Transferer interface {
+	RecordStore
+
+	// Transfer returns the RRs of all names in zone, without the zone's SOA.
+	Transfer(zone string) []dns.RR
+}
+
+// transferBatch is the number of RRs sent per message of a zone transfer.
+const transferBatch = 500
+
+// Transfer implements Transferer. The records of each name are returned the way an ANY query, or a
+// CNAME query for aliases, would be answered.
+func (s *JSONStore) Transfer(zone string) []dns.RR {
+	rrs := []dns.RR{}
+	for _, f := range s.Files {
+		for _, name := range f.names() {
+			if !dns.IsSubDomain(zone, name) {
+				continue
+			}
+			rrs = append(rrs, s.records(name, dns.TypeANY, f.lookupExact(name))...)
+		}
+	}
+	return rrs
+}
+
+// Transfer returns copies of the RRs of all names in zone, without SOA records.
+func (z *Zonefile) Transfer(zone string) []dns.RR {
+	names := make([]string, 0, len(z.rrs))
+	for name := range z.rrs {
+		if dns.IsSubDomain(zone, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	rrs := []dns.RR{}
+	for _, name := range names {
+		types := make([]int, 0, len(z.rrs[name]))
+		for t := range z.rrs[name] {
+			if t != dns.TypeSOA {
+				types = append(types, int(t))
+			}
+		}
+		sort.Ints(types)
+		for _, t := range types {
+			for _, rr := range z.rrs[name][uint16(t)] {
+				rrs = append(rrs, dns.Copy(rr))
+			}
+		}
+	}
+	return rrs
+}
+
+// transfer answers an AXFR query with the SOA of the zone, all of its records and the SOA again,
+// spread over as many messages as needed. Transfers are only done over TCP, to the clients that
+// AllowTransfer permits.
+func (n Nightlightdns) transfer(state request.Request) (int, error) {
+	if n.AllowTransfer == nil || !n.AllowTransfer.Allowed(net.ParseIP(state.IP())) || state.Proto() != "tcp" {
+		return n.dnserror(dns.RcodeRefused, state, nil)
+	}
+
+	zone := state.Name()
+	var soa dns.RR
+	rrs := []dns.RR{}
+	if n.Zonefile != nil {
+		if z := n.Zonefile; z.soa != nil && strings.EqualFold(z.soa.Header().Name, zone) {
+			soa = dns.Copy(z.soa)
+			rrs = z.Transfer(zone)
+		}
+	} else if t, ok := n.Store.(Transferer); ok && n.SOA[zone] != nil {
+		soa = n.soa(zone)
+		rrs = t.Transfer(zone)
+	}
+	if soa == nil {
+		return n.dnserror(dns.RcodeNotAuth, state, nil)
+	}
+
+	rrs = append(append([]dns.RR{soa}, rrs...), soa)
+	for len(rrs) > 0 {
+		batch := rrs
+		if len(batch) > transferBatch {
+			batch = batch[:transferBatch]
+		}
+		rrs = rrs[len(batch):]
+
+		m := new(dns.Msg)
+		m.SetReply(state.Req)
+		m.Authoritative = true
+		m.Answer = batch
+		m.Compress = n.Compress
+		if err := state.W.WriteMsg(m); err != nil {
+			return dns.RcodeSuccess, err
+		}
+	}
+	return dns.RcodeSuccess, nil
+}
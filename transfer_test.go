@@ -0,0 +1,135 @@
+package nightlightdns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// serveTCP serves n over TCP on a local port and returns its address. The server is shut down when
+// the test ends.
+func serveTCP(t *testing.T, n Nightlightdns) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		Listener:          ln,
+		Handler:           dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) { n.ServeDNS(context.TODO(), w, r) }),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return ln.Addr().String()
+}
+
+func TestTransfer(t *testing.T) {
+	n := newRecordsPlugin(t, benchRecords(transferBatch+100), testSOA, "allow-transfer 127.0.0.1/32")
+	addr := serveTCP(t, n)
+
+	m := new(dns.Msg)
+	m.SetAxfr("example.com.")
+	env, err := new(dns.Transfer).In(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrs, messages := []dns.RR{}, 0
+	for e := range env {
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		rrs = append(rrs, e.RR...)
+		messages++
+	}
+
+	if messages < 2 {
+		t.Errorf("Expected the transfer to take more than one message, got %d", messages)
+	}
+	if want := transferBatch + 100 + 2; len(rrs) != want {
+		t.Fatalf("Expected %d RRs, got %d", want, len(rrs))
+	}
+	for _, i := range []int{0, len(rrs) - 1} {
+		if _, ok := rrs[i].(*dns.SOA); !ok {
+			t.Errorf("Expected RR %d to be the SOA, got %s", i, rrs[i])
+		}
+	}
+	names := map[string]bool{}
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, ok := rr.(*dns.A); !ok {
+			t.Errorf("Expected only A RRs between the SOAs, got %s", rr)
+		}
+		names[rr.Header().Name] = true
+	}
+	if len(names) != transferBatch+100 {
+		t.Errorf("Expected all %d names, got %d", transferBatch+100, len(names))
+	}
+}
+
+func TestTransferCNAME(t *testing.T) {
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "app", "ipaddress": "192.0.2.1"},
+    {"name": "www", "type": "CNAME", "target": "app"},
+    {"name": "www", "ipaddress": "192.0.2.7"}
+  ]
+}`
+	n := newRecordsPlugin(t, records, testSOA, "allow-transfer 127.0.0.1/32")
+	addr := serveTCP(t, n)
+
+	m := new(dns.Msg)
+	m.SetAxfr("example.com.")
+	env, err := new(dns.Transfer).In(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrs := []dns.RR{}
+	for e := range env {
+		if e.Error != nil {
+			t.Fatal(e.Error)
+		}
+		rrs = append(rrs, e.RR...)
+	}
+
+	www := []dns.RR{}
+	for _, rr := range rrs {
+		if rr.Header().Name == "www.example.com." {
+			www = append(www, rr)
+		}
+	}
+	if len(www) != 1 || www[0].String() != "www.example.com.\t30\tIN\tCNAME\tapp.example.com." {
+		t.Errorf("Expected only the CNAME of www.example.com., got %v", www)
+	}
+}
+
+func TestTransferRefused(t *testing.T) {
+	tests := []struct {
+		options string
+		tcp     bool
+		rcode   int
+	}{
+		// The test client is 10.240.0.1.
+		{"allow-transfer 10.240.0.0/16", true, dns.RcodeSuccess},
+		{"allow-transfer 10.240.0.0/16", false, dns.RcodeRefused},
+		{"allow-transfer 192.0.2.0/24", true, dns.RcodeRefused},
+		{"", true, dns.RcodeRefused},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, testRecords, testSOA, tc.options)
+		m := new(dns.Msg)
+		m.SetAxfr("example.com.")
+		rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: tc.tcp})
+		n.ServeDNS(context.TODO(), rec, m)
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("Test %d: expected rcode %s, got %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rec.Msg.Rcode])
+		}
+	}
+}
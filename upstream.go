@@ -0,0 +1,103 @@
+package nightlightdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/upstream"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// upstreamResolver resolves the targets of CNAMEs pointing outside the zones, so clients get the
+// final answer instead of having to chase the CNAME themselves.
+type upstreamResolver struct {
+	// addrs are the resolvers asked in order. Without them names are resolved by CoreDNS itself,
+	// following the plugin chain again.
+	addrs []string
+	self  *upstream.Upstream
+
+	// timeout bounds the whole lookup, over all resolvers.
+	timeout time.Duration
+}
+
+// newUpstreamResolver returns an upstreamResolver asking the resolvers at addrs, or CoreDNS itself
+// if there are none.
+func newUpstreamResolver(addrs []string, timeout time.Duration) *upstreamResolver {
+	return &upstreamResolver{addrs: addrs, self: upstream.New(), timeout: timeout}
+}
+
+// upstreamAddrs returns the addresses of the resolvers given as IP or IP:port, port 53 if it's not
+// given.
+func upstreamAddrs(args []string) ([]string, error) {
+	addrs := make([]string, 0, len(args))
+	for _, arg := range args {
+		host, port, err := net.SplitHostPort(arg)
+		if err != nil {
+			host, port = arg, "53"
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid upstream address '%s'", arg)
+		}
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+	return addrs, nil
+}
+
+// lookup resolves name and qtype. The first resolver responding wins, whatever its response code.
+func (u *upstreamResolver) lookup(ctx context.Context, state request.Request, name string, qtype uint16) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	if len(u.addrs) == 0 {
+		return u.self.Lookup(ctx, state, name, qtype)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	req.RecursionDesired = true
+	var err error
+	for _, addr := range u.addrs {
+		var m *dns.Msg
+		c := &dns.Client{Net: "udp"}
+		if m, _, err = c.ExchangeContext(ctx, req, addr); err == nil && m.Truncated {
+			c.Net = "tcp"
+			m, _, err = c.ExchangeContext(ctx, req, addr)
+		}
+		if err == nil {
+			return m, nil
+		}
+	}
+	return nil, err
+}
+
+// chase appends the upstream answer for the target of the CNAME ending answers, if that target is
+// outside our zones. When the lookup fails answers are returned as they are, and the resolver will
+// continue from the CNAME.
+func (n Nightlightdns) chase(ctx context.Context, state request.Request, answers []dns.RR) []dns.RR {
+	if n.upstream == nil || len(answers) == 0 || state.QType() == dns.TypeCNAME || state.QType() == dns.TypeANY {
+		return answers
+	}
+	last, ok := answers[len(answers)-1].(*dns.CNAME)
+	if !ok || plugin.Zones(n.Zones).Matches(last.Target) != "" {
+		return answers
+	}
+
+	m, err := n.upstream.lookup(ctx, state, last.Target, state.QType())
+	if err == nil && m == nil {
+		err = errors.New("no response")
+	}
+	if err != nil {
+		log.Warningf("Failed to resolve CNAME target %s upstream: %v", last.Target, err)
+		return answers
+	}
+	if m.Rcode != dns.RcodeSuccess {
+		return answers
+	}
+	return append(answers, m.Answer...)
+}
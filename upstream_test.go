@@ -0,0 +1,184 @@
+package nightlightdns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// startUpstream starts a mock resolver on UDP and TCP, answering from answers keyed by name. Other
+// names get NXDOMAIN, those in truncated get a truncated response over UDP.
+func startUpstream(t *testing.T, answers map[string][]string, truncated map[string]bool) string {
+	t.Helper()
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		name := r.Question[0].Name
+		rrs, ok := answers[name]
+		if !ok {
+			m.Rcode = dns.RcodeNameError
+		}
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp && truncated[name] {
+			m.Truncated = true
+			w.WriteMsg(m)
+			return
+		}
+		for _, s := range rrs {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				panic(err)
+			}
+			if rr.Header().Rrtype == r.Question[0].Qtype {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	for _, srv := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: ln, Handler: handler}} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go srv.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { srv.Shutdown() })
+	}
+	return pc.LocalAddr().String()
+}
+
+// deadAddr returns an address nothing listens on, queries to it are refused right away.
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
+
+// silentAddr returns an address that receives queries but never answers them.
+func silentAddr(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc.LocalAddr().String()
+}
+
+func TestSetupUpstream(t *testing.T) {
+	testParse(t, []parseTest{
+		{"nightlightdns {\nupstream\n}", false},
+		{"nightlightdns {\nupstream 192.0.2.53\n}", false},
+		{"nightlightdns {\nupstream 192.0.2.53:5353 [2001:db8::53]:53\n}", false},
+		{"nightlightdns {\nupstream resolver.example.net\n}", true},
+	})
+}
+
+func TestUpstreamAddrs(t *testing.T) {
+	addrs, err := upstreamAddrs([]string{"192.0.2.53", "192.0.2.54:5353", "2001:db8::53", "[2001:db8::54]:5353"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.53:53", "192.0.2.54:5353", "[2001:db8::53]:53", "[2001:db8::54]:5353"}
+	if fmt.Sprint(addrs) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, addrs)
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	upstream := startUpstream(t, map[string][]string{
+		"www.example.net.": {"www.example.net. 300 IN A 203.0.113.1", "www.example.net. 300 IN AAAA 2001:db8::1"},
+		"big.example.net.": {"big.example.net. 300 IN A 203.0.113.2"},
+	}, map[string]bool{"big.example.net.": true})
+	records := `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "type": "CNAME", "target": "www.example.net."},
+    {"name": "big", "type": "CNAME", "target": "big.example.net."},
+    {"name": "gone", "type": "CNAME", "target": "gone.example.net."},
+    {"name": "local", "type": "CNAME", "target": "host"},
+    {"name": "host", "ipaddress": "192.0.2.1"}
+  ]
+}`
+	www := test.CNAME("www.example.com. 30 IN CNAME www.example.net.")
+	tests := []struct {
+		options string
+		tc      test.Case
+	}{
+		{"upstream " + upstream, test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{www, test.A("www.example.net. 300 IN A 203.0.113.1")},
+		}},
+		{"upstream " + upstream, test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{www, test.AAAA("www.example.net. 300 IN AAAA 2001:db8::1")},
+		}},
+		// Truncated responses are retried over TCP.
+		{"upstream " + upstream, test.Case{
+			Qname: "big.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("big.example.com. 30 IN CNAME big.example.net."),
+				test.A("big.example.net. 300 IN A 203.0.113.2"),
+			},
+		}},
+		// Targets that don't resolve leave the CNAME for the resolver.
+		{"upstream " + upstream, test.Case{
+			Qname: "gone.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.CNAME("gone.example.com. 30 IN CNAME gone.example.net.")},
+		}},
+		{"upstream " + upstream, test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeCNAME,
+			Answer: []dns.RR{www},
+		}},
+		{"upstream " + upstream, test.Case{
+			Qname: "local.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.A("host.example.com. 30 IN A 192.0.2.1"),
+				test.CNAME("local.example.com. 30 IN CNAME host.example.com."),
+			},
+		}},
+		// The next resolver is asked when one fails, but the timeout bounds the whole lookup.
+		{"upstream " + deadAddr(t) + " " + upstream, test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{www, test.A("www.example.net. 300 IN A 203.0.113.1")},
+		}},
+		{"timeout 200ms\nupstream " + silentAddr(t) + " " + upstream, test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{www},
+		}},
+		{"", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{www},
+		}},
+	}
+	for i, tc := range tests {
+		n := newRecordsPlugin(t, records, tc.options)
+		start := time.Now()
+		resp := exchange(n, tc.tc.Msg())
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("Test %d: expected the lookup to be bounded by the timeout, took %s", i, d)
+		}
+		if err := test.CNAMEOrder(resp); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+		if err := test.SortAndCheck(resp, tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
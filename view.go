@@ -0,0 +1,26 @@
+package nightlightdns
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/plugin/metrics"
+)
+
+// view returns n answering from the records of the view of the server that received the query,
+// identified by its address as in the server label of the metrics, e.g. "dns://:53". Servers
+// without a view are answered from the Store.
+func (n Nightlightdns) view(ctx context.Context) Nightlightdns {
+	if s, ok := n.views[metrics.WithServer(ctx)]; ok {
+		n.Store = s
+	}
+	return n
+}
+
+// allStores returns the backends of the Store and the stores of the views.
+func (n Nightlightdns) allStores() []RecordStore {
+	all := stores(n.Store)
+	for _, s := range n.views {
+		all = append(all, s)
+	}
+	return all
+}
@@ -0,0 +1,86 @@
+package nightlightdns
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupView(t *testing.T) {
+	dir := t.TempDir()
+	internal := writeFile(t, dir, "internal.json", testRecords)
+	testParse(t, []parseTest{
+		{fmt.Sprintf("nightlightdns {\nview dns://:1053 %s\n}", internal), false},
+		{fmt.Sprintf("nightlightdns {\nview dns://:1053 %s %s\n}", internal, internal), false},
+		{fmt.Sprintf("nightlightdns {\nview dns://:1053 %s\nview dns://:1053 %s\n}", internal, internal), true},
+		{"nightlightdns {\nview dns://:1053\n}", true},
+	})
+}
+
+func TestView(t *testing.T) {
+	dir := t.TempDir()
+	external := writeFile(t, dir, "external.json", `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "203.0.113.1"},
+    {"name": "public", "ipaddress": "203.0.113.2"}
+  ]
+}`)
+	internal := writeFile(t, dir, "internal.json", `{
+  "origin": "example.com.",
+  "records": [
+    {"name": "www", "ipaddress": "10.0.0.1"},
+    {"name": "db", "ipaddress": "10.0.0.2"}
+  ]
+}`)
+	n := newTestPlugin(t, fmt.Sprintf("nightlightdns %s example.com {\nview dns://10.0.0.53:53 %s\n}", external, internal))
+
+	tests := []struct {
+		server string
+		tc     test.Case
+	}{
+		{"dns://:53", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 203.0.113.1")},
+		}},
+		{"dns://10.0.0.53:53", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 10.0.0.1")},
+		}},
+		// Each view only has its own records.
+		{"dns://:53", test.Case{
+			Qname: "db.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		}},
+		{"dns://10.0.0.53:53", test.Case{
+			Qname: "db.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("db.example.com. 30 IN A 10.0.0.2")},
+		}},
+		{"dns://10.0.0.53:53", test.Case{
+			Qname: "public.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+		}},
+		// Queries without a server in their context get the default records.
+		{"", test.Case{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 30 IN A 203.0.113.1")},
+		}},
+	}
+	for i, tc := range tests {
+		ctx := context.TODO()
+		if tc.server != "" {
+			ctx = context.WithValue(ctx, dnsserver.Key{}, &dnsserver.Server{Addr: tc.server})
+		}
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		n.ServeDNS(ctx, rec, tc.tc.Msg())
+		if err := test.SortAndCheck(rec.Msg, tc.tc); err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package nightlightdns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain limits how many CNAMEs are followed within a zone file.
+const maxCNAMEChain = 8
+
+// Zonefile holds the records of an RFC 1035 zone file. When configured, it is served instead of the
+// JSON records file.
+type Zonefile struct {
+	// Path is the path of the zone file.
+	Path string
+
+	// Origin is the origin used for relative names in the file, until it sets $ORIGIN.
+	Origin string
+
+	// TTL is the TTL of records without one, until the file sets $TTL.
+	TTL uint32
+
+	// soa is the SOA record of the zone, if the file has one.
+	soa dns.RR
+
+	// rrs maps lowercased owner names to their RRs, grouped by type.
+	rrs map[string]map[uint16][]dns.RR
+}
+
+// loadZonefile reads and parses the zone file at path, with origin and ttl as the defaults that its
+// $ORIGIN and $TTL directives override.
+func loadZonefile(path, origin string, ttl uint32) (*Zonefile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	z := &Zonefile{Path: path, Origin: dns.Fqdn(origin), TTL: ttl, rrs: make(map[string]map[uint16][]dns.RR)}
+
+	zp := dns.NewZoneParser(file, z.Origin, path)
+	zp.SetDefaultTTL(ttl)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeSOA && z.soa == nil {
+			z.soa = rr
+		}
+		name := strings.ToLower(hdr.Name)
+		if z.rrs[name] == nil {
+			z.rrs[name] = make(map[uint16][]dns.RR)
+		}
+		z.rrs[name][hdr.Rrtype] = append(z.rrs[name][hdr.Rrtype], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("unable to parse zone file %q: %v", path, err)
+	}
+	return z, nil
+}
+
+// lookup returns copies of the RRs of name with type qtype, all of them for ANY, and whether name
+// exists at all.
+func (z *Zonefile) lookup(name string, qtype uint16) ([]dns.RR, bool) {
+	types, ok := z.rrs[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	qtypes := []uint16{qtype}
+	if qtype == dns.TypeANY {
+		qtypes = qtypes[:0]
+		for t := range types {
+			qtypes = append(qtypes, t)
+		}
+		sort.Slice(qtypes, func(i, j int) bool { return qtypes[i] < qtypes[j] })
+	}
+	rrs := []dns.RR{}
+	for _, t := range qtypes {
+		for _, rr := range types[t] {
+			rrs = append(rrs, dns.Copy(rr))
+		}
+	}
+	return rrs, true
+}
+
+// answer returns the answer section for a query of name and qtype, following CNAMEs within the zone,
+// and whether name exists.
+func (z *Zonefile) answer(name string, qtype uint16) ([]dns.RR, bool) {
+	answers, exists := z.lookup(name, qtype)
+	if !exists || len(answers) > 0 || qtype == dns.TypeCNAME || qtype == dns.TypeANY {
+		return answers, exists
+	}
+
+	for i := 0; i < maxCNAMEChain; i++ {
+		cnames, _ := z.lookup(name, dns.TypeCNAME)
+		if len(cnames) == 0 {
+			break
+		}
+		answers = append(answers, cnames[0])
+		name = cnames[0].(*dns.CNAME).Target
+
+		rrs, _ := z.lookup(name, qtype)
+		if len(rrs) > 0 {
+			answers = append(answers, rrs...)
+			break
+		}
+	}
+	return answers, true
+}
+
+// serveZone answers the query from the zone file. Negative answers carry the zone's SOA in the
+// authority section, so resolvers can cache them. Queries that fall through are passed to the next
+// plugin with w. The query is for a name in zone, one of the plugin's zones.
+func (n Nightlightdns) serveZone(ctx context.Context, w dns.ResponseWriter, state request.Request, zone string) (int, error) {
+	answers, exists := n.Zonefile.answer(state.Name(), state.QType())
+	if !exists && n.Fall.Through(state.Name()) {
+		return plugin.NextOrFailure(n.Name(), n.Next, ctx, w, state.Req)
+	}
+	answers = n.allowedTypes[zone].filter(state.QType(), n.chase(ctx, state, answers))
+
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Authoritative = true
+	m.Answer = answers
+	n.jitter.apply(m.Answer)
+	if !exists {
+		m.Rcode = dns.RcodeNameError
+	}
+	if len(answers) == 0 && n.Zonefile.soa != nil {
+		m.Ns = []dns.RR{dns.Copy(n.Zonefile.soa)}
+	}
+
+	n.writeMsg(state, m)
+	return dns.RcodeSuccess, nil
+}
@@ -0,0 +1,134 @@
+package nightlightdns
+
+import (
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// testZone is a small zone file for example.com.
+const testZone = `example.com.        3600 IN SOA ns1.example.com. hostmaster.example.com. 2021010101 7200 3600 1209600 300
+example.com.        3600 IN NS  ns1.example.com.
+example.com.        3600 IN MX  10 mail.example.com.
+ns1.example.com.    3600 IN A   192.0.2.53
+www.example.com.    3600 IN A   192.0.2.1
+www.example.com.    3600 IN AAAA 2001:db8::1
+www.example.com.    3600 IN TXT "hello"
+alias.example.com.  3600 IN CNAME www.example.com.
+`
+
+// newZonePlugin returns the plugin serving the zone file zone for example.com.
+func newZonePlugin(t *testing.T, zone string) Nightlightdns {
+	t.Helper()
+	path := writeFile(t, t.TempDir(), "db.example.com", zone)
+	return newTestPlugin(t, "nightlightdns example.com {\nzonefile "+path+"\n}")
+}
+
+func TestZonefile(t *testing.T) {
+	n := newZonePlugin(t, testZone)
+	soa := test.SOA("example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 2021010101 7200 3600 1209600 300")
+	checkCases(t, n, []test.Case{
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{test.A("www.example.com. 3600 IN A 192.0.2.1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeAAAA,
+			Answer: []dns.RR{test.AAAA("www.example.com. 3600 IN AAAA 2001:db8::1")},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeTXT,
+			Answer: []dns.RR{test.TXT(`www.example.com. 3600 IN TXT "hello"`)},
+		},
+		{
+			Qname: "example.com.", Qtype: dns.TypeMX,
+			Answer: []dns.RR{test.MX("example.com. 3600 IN MX 10 mail.example.com.")},
+		},
+		{
+			Qname: "alias.example.com.", Qtype: dns.TypeA,
+			Answer: []dns.RR{
+				test.CNAME("alias.example.com. 3600 IN CNAME www.example.com."),
+				test.A("www.example.com. 3600 IN A 192.0.2.1"),
+			},
+		},
+		{
+			Qname: "www.example.com.", Qtype: dns.TypeMX,
+			Ns: []dns.RR{soa},
+		},
+		{
+			Qname: "nope.example.com.", Qtype: dns.TypeA,
+			Rcode: dns.RcodeNameError,
+			Ns:    []dns.RR{soa},
+		},
+	})
+}
+
+func TestZonefileDirectives(t *testing.T) {
+	tests := []struct {
+		zone, options string
+		cases         []test.Case
+	}{
+		// $TTL applies to the records without one, $ORIGIN to the relative names after it.
+		{`$TTL 600
+$ORIGIN example.com.
+@       IN A    192.0.2.10
+www        A    192.0.2.1
+api  60 IN A    192.0.2.2
+$ORIGIN sub.example.com.
+@          A    192.0.2.3
+host       CNAME www.example.com.
+$TTL 1200
+late       A    192.0.2.4
+`, "", []test.Case{
+			{Qname: "example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("example.com. 600 IN A 192.0.2.10")}},
+			{Qname: "www.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("www.example.com. 600 IN A 192.0.2.1")}},
+			{Qname: "api.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("api.example.com. 60 IN A 192.0.2.2")}},
+			{Qname: "sub.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("sub.example.com. 600 IN A 192.0.2.3")}},
+			{Qname: "host.sub.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{
+				test.CNAME("host.sub.example.com. 600 IN CNAME www.example.com."),
+				test.A("www.example.com. 600 IN A 192.0.2.1"),
+			}},
+			{Qname: "late.sub.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("late.sub.example.com. 1200 IN A 192.0.2.4")}},
+			{Qname: "host.example.com.", Qtype: dns.TypeA, Rcode: dns.RcodeNameError},
+		}},
+		// Without them, names are relative to the zone and records get the ttl directive's TTL.
+		{`www A 192.0.2.1
+`, "ttl 120", []test.Case{
+			{Qname: "www.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("www.example.com. 120 IN A 192.0.2.1")}},
+		}},
+		{`$TTL 600
+www A 192.0.2.1
+`, "ttl 120", []test.Case{
+			{Qname: "www.example.com.", Qtype: dns.TypeA, Answer: []dns.RR{test.A("www.example.com. 600 IN A 192.0.2.1")}},
+		}},
+	}
+	for i, tc := range tests {
+		path := writeFile(t, t.TempDir(), "db.example.com", tc.zone)
+		n := newTestPlugin(t, "nightlightdns example.com {\nzonefile "+path+"\n"+tc.options+"\n}")
+		for j, c := range tc.cases {
+			if err := test.SortAndCheck(exchange(n, c.Msg()), c); err != nil {
+				t.Errorf("Test %d, case %d: %v", i, j, err)
+			}
+		}
+	}
+}
+
+func TestZonefileOrigin(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "db", "www A 192.0.2.1\n")
+	z, err := loadZonefile(path, "example.org", defaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rrs, ok := z.lookup("www.example.org.", dns.TypeA); !ok || len(rrs) != 1 {
+		t.Errorf("Expected www to be relative to the origin, got %v", rrs)
+	}
+
+	for i, zone := range []string{"$TTL soon\nwww A 192.0.2.1\n", "$ORIGIN\nwww A 192.0.2.1\n"} {
+		path := writeFile(t, t.TempDir(), "db", zone)
+		if _, err := loadZonefile(path, "example.com.", defaultTTL); err == nil {
+			t.Errorf("Test %d: expected an error for %q, got none", i, zone)
+		}
+	}
+}